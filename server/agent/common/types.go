@@ -6,4 +6,43 @@ type State struct {
 	History   []*schema.Message
 	UserInput string
 	Name      string
+
+	// Chat model calls of the current request, see manus.WithStepBudget
+	Steps           int
+	BudgetExhausted bool
+
+	// Task list of the planner agent, empty for the other agents
+	Plan []Task
+
+	// Claims of the last answer as checked by the verifier, see manus.WithVerification
+	Claims []Claim
+
+	// Summary the chat model sees instead of the first Compacted messages,
+	// see manus.CompactHistory. History itself stays complete.
+	Summary   string
+	Compacted int
+}
+
+// Task statuses
+const (
+	TaskPending    = "pending"
+	TaskInProgress = "in_progress"
+	TaskDone       = "done"
+	TaskSkipped    = "skipped"
+)
+
+// Task is one step of the plan the planner agent works through
+type Task struct {
+	ID     int    `json:"id"`
+	Title  string `json:"title"`
+	Status string `json:"status"`
+	Result string `json:"result,omitempty"` // Short outcome once done or skipped
+}
+
+// Claim is a factual statement of an answer and whether a tool output backs it
+type Claim struct {
+	Claim     string `json:"claim"`
+	Supported bool   `json:"supported"`
+	Evidence  string `json:"evidence,omitempty"` // Quote of the supporting tool output
+	Sentence  string `json:"sentence,omitempty"` // Sentence of the answer making the claim
 }
@@ -6,7 +6,6 @@ import (
 	"log"
 
 	"gogogajeto/agent/common"
-	"gogogajeto/agent/prompts"
 	"gogogajeto/agent/tools"
 	"gogogajeto/util"
 
@@ -22,45 +21,29 @@ const (
 	NodeKeyChatModel     = "ChatModel"
 	NodeKeyToolsNode     = "ToolsNode"
 	NodeKeyOutputConvert = "OutputConverter"
+	NodeKeyToolApproval  = "ToolApproval"
+	NodeKeyToolsDenied   = "ToolsDenied"
+	NodeKeyBudgetSummary = "BudgetSummary"
 )
 
-// CreateAgent creates and configures a complete agent with Python and Kali tools
-func CreateAgent() compose.Runnable[string, string] {
+// CreateAgent creates and configures a complete agent with the given tools,
+// usually the Python and Kali tools from tools.NewAgentTools, on the named
+// chat model, empty for OPENAI_MODEL.
+// Graph state is checkpointed into store after every turn.
+func CreateAgent(store compose.CheckPointStore, allTools []tool.BaseTool, modelName string) compose.Runnable[string, string] {
 	util.LogMessage("=== AGENT CREATION START ===")
 	ctx := context.Background()
 
-	// init Python sandbox and tools
-	util.LogMessage("Creating Python sandbox...")
-	pythonSb := tools.NewSandbox(ctx)
-	//defer pythonSb.Cleanup(ctx)
-
-	util.LogMessage("Creating Python command line tools...")
-	pythonTools := tools.NewCommandLineTool(ctx, pythonSb)
-	util.LogMessage(fmt.Sprintf("Created %d Python tools", len(pythonTools)))
-
-	// init Kali Linux sandbox and tools
-	util.LogMessage("Creating Kali Linux sandbox...")
-	kaliSb := tools.NewKaliSandbox(ctx)
-	//defer kaliSb.Cleanup(ctx)
-
-	util.LogMessage("Creating Kali information gathering tools...")
-	kaliTools := tools.NewKaliCommandLineTool(ctx, kaliSb)
-	util.LogMessage(fmt.Sprintf("Created %d Kali tools", len(kaliTools)))
-
-	// Combine all tools
-	allTools := append(pythonTools, kaliTools...)
-	util.LogMessage(fmt.Sprintf("Total tools available: %d", len(allTools)))
-
 	// init chat model and bind tools
 	util.LogMessage("Creating chat model...")
-	cm := tools.NewChatModel(ctx)
+	cm := tools.NewChatModelFor(ctx, modelName)
 
 	util.LogMessage("Binding all tools to chat model...")
 	cm = tools.BindTools(ctx, cm, allTools)
 
 	// create agent
 	util.LogMessage("Composing agent...")
-	agent := composeAgent(ctx, cm, allTools)
+	agent := composeAgent(ctx, cm, allTools, store)
 
 	util.LogMessage("=== AGENT CREATION COMPLETE ===")
 	return agent
@@ -69,7 +52,23 @@ func CreateAgent() compose.Runnable[string, string] {
 func composeAgent(ctx context.Context,
 	cm model.BaseChatModel,
 	tools []tool.BaseTool,
+	store compose.CheckPointStore,
 ) compose.Runnable[string, string] {
+	return composeGraph(ctx, cm, tools, store, false)
+}
+
+// composeGraph builds the agent graph. With planning, every request passes
+// the Planner before the chat model and every tool result the Replanner.
+func composeGraph(ctx context.Context,
+	cm model.BaseChatModel,
+	tools []tool.BaseTool,
+	store compose.CheckPointStore,
+	planning bool,
+) compose.Runnable[string, string] {
+	// A cancelled run stops model and tools but still ends at the Human node
+	cm = NewCancellableModel(cm)
+	tools = CancellableTools(tools)
+
 	g := compose.NewGraph[string, string](compose.WithGenLocalState(func(ctx context.Context) *common.State {
 		return &common.State{History: []*schema.Message{}}
 	}))
@@ -120,12 +119,13 @@ func composeAgent(ctx context.Context,
 			// Initialize with system message if this is a new conversation (empty history)
 			if len(state.History) == 0 {
 				util.LogMessage("New conversation detected - adding system message")
-				systemMsg := schema.SystemMessage(prompts.SystemPrompt)
+				systemMsg := schema.SystemMessage(SystemPrompt(ctx))
 				state.History = append(state.History, systemMsg)
 			}
 
 			// Add new messages to history
 			state.History = append(state.History, in...)
+			state.Steps++
 
 			util.LogMessage(fmt.Sprintf("Updated history count: %d", len(state.History)))
 
@@ -152,7 +152,17 @@ func composeAgent(ctx context.Context,
 			}
 			util.LogMessage("=== End Recent History ===")
 
-			return state.History, nil
+			// Older messages are summarized once the input grows too large
+			if err := CompactHistory(ctx, cm, state); err != nil {
+				return nil, err
+			}
+			messages := ModelHistory(state)
+
+			// The plan is shown to the model but not kept in the history
+			if planning && len(state.Plan) > 0 {
+				messages = append(messages, schema.SystemMessage(renderPlan(state.Plan)))
+			}
+			return messages, nil
 		}),
 		// Post-handler logging with enhanced tracer
 		compose.WithStatePostHandler(func(ctx context.Context, out *schema.Message, state *common.State) (*schema.Message, error) {
@@ -183,7 +193,9 @@ func composeAgent(ctx context.Context,
 		log.Fatal(err)
 	}
 
-	toolsNode, err := compose.NewToolNode(ctx, &compose.ToolsNodeConfig{Tools: tools})
+	// The tool calls of one turn run in parallel, see LimitConcurrency for the
+	// caps. The results come back in the order of the calls.
+	toolsNode, err := compose.NewToolNode(ctx, &compose.ToolsNodeConfig{Tools: tools, ExecuteSequentially: false})
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -236,8 +248,49 @@ func composeAgent(ctx context.Context,
 		log.Fatal(err)
 	}
 
+	// Optional human approval before tools run, see WithToolApproval
+	err = g.AddLambdaNode(NodeKeyToolApproval, compose.InvokableLambda(approvalGate))
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = g.AddLambdaNode(NodeKeyToolsDenied, compose.InvokableLambda(denyTools))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Final answer without tools once the step budget is used up
+	err = g.AddLambdaNode(NodeKeyBudgetSummary, compose.InvokableLambda(budgetSummary(cm)))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Checks the final answer against the tool outputs, see WithVerification
+	err = g.AddLambdaNode(NodeKeyVerifier, compose.InvokableLambda(Verifier(cm)))
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	err = g.AddLambdaNode(NodeKeyHuman, compose.InvokableLambda(func(ctx context.Context, input *schema.Message) (output []*schema.Message, err error) {
 		util.LogMessage("=== Human Node START ===")
+
+		// Without a pending user message (e.g. after the state was only rewritten)
+		// keep waiting for the next one
+		var waiting bool
+		err = compose.ProcessState[*common.State](ctx, func(ctx context.Context, state *common.State) error {
+			waiting = len(state.UserInput) == 0
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		if waiting {
+			util.LogMessage("No user input, waiting for the next message")
+			return nil, compose.InterruptAndRerun
+		}
+		// A rerun after a rewritten state has no input, the post-handler supplies the user message
+		if input == nil {
+			return []*schema.Message{}, nil
+		}
 		util.LogMessage("Input message role: " + string(input.Role))
 		util.LogMessage("Input content: " + input.Content)
 
@@ -248,6 +301,11 @@ func composeAgent(ctx context.Context,
 			util.LogMessage("UserInput from state: " + state.UserInput)
 
 			if len(state.UserInput) > 0 {
+				// A new request starts with a fresh step budget
+				state.Steps = 0
+				state.BudgetExhausted = false
+				state.Claims = nil
+
 				userMsg := schema.UserMessage(state.UserInput)
 				util.LogMessage("Creating new user message: " + userMsg.Content)
 				util.LogMessage("=== Human Node END ===")
@@ -279,32 +337,87 @@ func composeAgent(ctx context.Context,
 	if err != nil {
 		log.Fatal(err)
 	}
-	err = g.AddEdge(NodeKeyInputConvert, NodeKeyChatModel)
+	// Where a request and a tool result go before they reach the chat model
+	requestNode, resultNode := NodeKeyChatModel, NodeKeyChatModel
+	if planning {
+		requestNode, resultNode = NodeKeyPlanner, NodeKeyReplanner
+
+		err = g.AddLambdaNode(NodeKeyPlanner, compose.InvokableLambda(planner(cm)))
+		if err != nil {
+			log.Fatal(err)
+		}
+		err = g.AddLambdaNode(NodeKeyReplanner, compose.InvokableLambda(replanner(cm)))
+		if err != nil {
+			log.Fatal(err)
+		}
+		err = g.AddEdge(NodeKeyPlanner, NodeKeyChatModel)
+		if err != nil {
+			log.Fatal(err)
+		}
+		err = g.AddEdge(NodeKeyReplanner, NodeKeyChatModel)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	err = g.AddEdge(NodeKeyInputConvert, requestNode)
 	if err != nil {
 		log.Fatal(err)
 	}
 	err = g.AddBranch(NodeKeyChatModel, compose.NewGraphBranch(func(ctx context.Context, in *schema.Message) (endNode string, err error) {
-		if len(in.ToolCalls) > 0 {
-			return NodeKeyToolsNode, nil
+		if len(in.ToolCalls) == 0 {
+			return NodeKeyVerifier, nil
+		}
+		exhausted, err := budgetExhausted(ctx)
+		if err != nil {
+			return "", err
+		}
+		if exhausted {
+			return NodeKeyBudgetSummary, nil
+		}
+		return NodeKeyToolApproval, nil
+	}, map[string]bool{
+		NodeKeyToolApproval:  true,
+		NodeKeyBudgetSummary: true,
+		NodeKeyVerifier:      true,
+	}))
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = g.AddBranch(NodeKeyToolApproval, compose.NewGraphBranch(func(ctx context.Context, in *schema.Message) (endNode string, err error) {
+		if isDenied(in) {
+			return NodeKeyToolsDenied, nil
 		}
-		return NodeKeyHuman, nil
+		return NodeKeyToolsNode, nil
 	}, map[string]bool{
-		NodeKeyToolsNode: true,
-		NodeKeyHuman:     true,
+		NodeKeyToolsNode:   true,
+		NodeKeyToolsDenied: true,
 	}))
 	if err != nil {
 		log.Fatal(err)
 	}
+	err = g.AddEdge(NodeKeyToolsDenied, NodeKeyChatModel)
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = g.AddEdge(NodeKeyBudgetSummary, NodeKeyVerifier)
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = g.AddEdge(NodeKeyVerifier, NodeKeyHuman)
+	if err != nil {
+		log.Fatal(err)
+	}
 	err = g.AddBranch(NodeKeyHuman, compose.NewGraphBranch(func(ctx context.Context, in []*schema.Message) (endNode string, err error) {
 		if in[len(in)-1].Role == schema.User {
-			return NodeKeyChatModel, nil
+			return requestNode, nil
 		}
 		return NodeKeyOutputConvert, nil
 	}, map[string]bool{
-		NodeKeyChatModel:     true,
+		requestNode:          true,
 		NodeKeyOutputConvert: true,
 	}))
-	err = g.AddEdge(NodeKeyToolsNode, NodeKeyChatModel)
+	err = g.AddEdge(NodeKeyToolsNode, resultNode)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -313,7 +426,7 @@ func composeAgent(ctx context.Context,
 		log.Fatal(err)
 	}
 
-	runner, err := g.Compile(ctx, compose.WithCheckPointStore(NewInMemoryStore()), compose.WithInterruptBeforeNodes([]string{NodeKeyHuman}))
+	runner, err := g.Compile(ctx, compose.WithCheckPointStore(store), compose.WithInterruptBeforeNodes([]string{NodeKeyHuman}))
 	if err != nil {
 		log.Fatal(err)
 	}
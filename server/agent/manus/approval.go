@@ -0,0 +1,195 @@
+package manus
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"gogogajeto/agent/common"
+	"gogogajeto/util"
+
+	"github.com/cloudwego/eino/compose"
+	"github.com/cloudwego/eino/schema"
+)
+
+// Actions for pending tool calls
+const (
+	ApprovalApprove = "approve" // Run the tool calls as requested
+	ApprovalDeny    = "deny"    // Skip the tool calls and tell the model why
+	ApprovalEdit    = "edit"    // Run the tool calls with changed arguments
+)
+
+// deniedKey marks a tool call message the operator denied, so the branch after
+// the approval gate routes it around the ToolsNode
+const deniedKey = "approval_denied"
+
+// ApprovalDecision resolves the tool calls waiting at the approval gate
+type ApprovalDecision struct {
+	Action    string            `json:"action"`
+	Reason    string            `json:"reason,omitempty"`    // Passed to the model when denying
+	Arguments map[string]string `json:"arguments,omitempty"` // New JSON arguments by ToolCallID when editing
+}
+
+// Validate checks the decision against the pending tool calls
+func (d *ApprovalDecision) Validate(pending []schema.ToolCall) error {
+	switch d.Action {
+	case ApprovalApprove, ApprovalDeny:
+		return nil
+	case ApprovalEdit:
+		if len(d.Arguments) == 0 {
+			return fmt.Errorf("edit requires arguments")
+		}
+		for id, args := range d.Arguments {
+			if !hasToolCall(pending, id) {
+				return fmt.Errorf("unknown tool call %s", id)
+			}
+			if !json.Valid([]byte(args)) {
+				return fmt.Errorf("arguments of tool call %s are not valid JSON", id)
+			}
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown action %q, use approve, deny or edit", d.Action)
+	}
+}
+
+func hasToolCall(calls []schema.ToolCall, id string) bool {
+	for _, tc := range calls {
+		if tc.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+type approvalKey struct{}
+
+// approvalContext is carried through one run. The decision is consumed by the
+// first gate it reaches, later tool calls of the same run wait again if
+// approval is required.
+type approvalContext struct {
+	required bool
+	decision *ApprovalDecision
+}
+
+// WithToolApproval makes the run stop before every tool execution until a
+// decision is given with WithApprovalDecision on resume
+func WithToolApproval(ctx context.Context) context.Context {
+	approval, _ := ctx.Value(approvalKey{}).(*approvalContext)
+	var decision *ApprovalDecision
+	if approval != nil {
+		decision = approval.decision
+	}
+	return context.WithValue(ctx, approvalKey{}, &approvalContext{required: true, decision: decision})
+}
+
+// WithApprovalDecision resumes a run that is waiting at the approval gate
+func WithApprovalDecision(ctx context.Context, decision *ApprovalDecision) context.Context {
+	approval, _ := ctx.Value(approvalKey{}).(*approvalContext)
+	required := approval != nil && approval.required
+	return context.WithValue(ctx, approvalKey{}, &approvalContext{required: required, decision: decision})
+}
+
+// IsAwaitingApproval reports whether the run was interrupted at the approval gate
+func IsAwaitingApproval(info *compose.InterruptInfo) bool {
+	if info == nil {
+		return false
+	}
+	for _, node := range info.RerunNodes {
+		if node == NodeKeyToolApproval {
+			return true
+		}
+	}
+	return false
+}
+
+// approvalGate holds tool calls until the operator decides on them. Without
+// WithToolApproval the calls pass straight through.
+func approvalGate(ctx context.Context, input *schema.Message) (*schema.Message, error) {
+	// A rerun from a checkpoint has no input, the tool calls are the last
+	// message of the history
+	if input == nil {
+		err := compose.ProcessState[*common.State](ctx, func(ctx context.Context, state *common.State) error {
+			if len(state.History) > 0 {
+				input = state.History[len(state.History)-1]
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		if input == nil || len(input.ToolCalls) == 0 {
+			return nil, fmt.Errorf("no pending tool calls to approve")
+		}
+	}
+
+	approval, ok := ctx.Value(approvalKey{}).(*approvalContext)
+	if !ok {
+		return input, nil
+	}
+
+	decision := approval.decision
+	approval.decision = nil
+	if decision == nil {
+		if !approval.required {
+			return input, nil
+		}
+		util.LogMessage(fmt.Sprintf("=== ToolApproval: waiting for approval of %d tool calls ===", len(input.ToolCalls)))
+		return nil, compose.InterruptAndRerun
+	}
+	util.LogMessage("=== ToolApproval: " + decision.Action + " ===")
+
+	out := *input
+	out.ToolCalls = append([]schema.ToolCall{}, input.ToolCalls...)
+	switch decision.Action {
+	case ApprovalDeny:
+		out.Extra = map[string]any{deniedKey: decision.Reason}
+		for k, v := range input.Extra {
+			out.Extra[k] = v
+		}
+	case ApprovalEdit:
+		for i, tc := range out.ToolCalls {
+			if args, ok := decision.Arguments[tc.ID]; ok {
+				out.ToolCalls[i].Function.Arguments = args
+			}
+		}
+		// Keep the history in line with what actually runs
+		err := compose.ProcessState[*common.State](ctx, func(ctx context.Context, state *common.State) error {
+			for _, msg := range state.History {
+				for i, tc := range msg.ToolCalls {
+					if args, ok := decision.Arguments[tc.ID]; ok {
+						msg.ToolCalls[i].Function.Arguments = args
+					}
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &out, nil
+}
+
+// denyTools answers every denied tool call with the operator's reason, so the
+// model learns why nothing ran
+func denyTools(ctx context.Context, input *schema.Message) ([]*schema.Message, error) {
+	reason, _ := input.Extra[deniedKey].(string)
+	if reason == "" {
+		reason = "no reason given"
+	}
+
+	results := make([]*schema.Message, 0, len(input.ToolCalls))
+	for _, tc := range input.ToolCalls {
+		msg := schema.ToolMessage("Tool call denied by the operator: "+reason, tc.ID)
+		msg.Name = tc.Function.Name
+		results = append(results, msg)
+	}
+	return results, nil
+}
+
+// isDenied routes denied tool calls around the ToolsNode
+func isDenied(input *schema.Message) bool {
+	_, denied := input.Extra[deniedKey]
+	return denied
+}
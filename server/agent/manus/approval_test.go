@@ -0,0 +1,192 @@
+package manus
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"gogogajeto/agent/common"
+
+	"github.com/cloudwego/eino/components/model"
+	"github.com/cloudwego/eino/components/tool"
+	"github.com/cloudwego/eino/compose"
+	"github.com/cloudwego/eino/schema"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// scriptedChatModel asks for one scan and answers with text once the tool
+// result is in the history
+type scriptedChatModel struct{}
+
+func (s *scriptedChatModel) Generate(ctx context.Context, input []*schema.Message, opts ...model.Option) (*schema.Message, error) {
+	last := input[len(input)-1]
+	if last.Role == schema.Tool {
+		return schema.AssistantMessage("tool said: "+last.Content, nil), nil
+	}
+	return schema.AssistantMessage("", []schema.ToolCall{{
+		ID:       "call-1",
+		Function: schema.FunctionCall{Name: "scan", Arguments: `{"target":"prod.example.com"}`},
+	}}), nil
+}
+
+func (s *scriptedChatModel) Stream(ctx context.Context, input []*schema.Message, opts ...model.Option) (*schema.StreamReader[*schema.Message], error) {
+	msg, err := s.Generate(ctx, input, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return schema.StreamReaderFromArray([]*schema.Message{msg}), nil
+}
+
+// recordingTool remembers the arguments it was called with
+type recordingTool struct {
+	mu    sync.Mutex
+	calls []string
+}
+
+func (r *recordingTool) Info(ctx context.Context) (*schema.ToolInfo, error) {
+	return &schema.ToolInfo{Name: "scan", Desc: "scan a target"}, nil
+}
+
+func (r *recordingTool) InvokableRun(ctx context.Context, arguments string, opts ...tool.Option) (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.calls = append(r.calls, arguments)
+	return "scanned " + arguments, nil
+}
+
+func (r *recordingTool) Calls() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string{}, r.calls...)
+}
+
+func newApprovalTestAgent(t *testing.T) (compose.Runnable[string, string], *InMemoryStore, *recordingTool) {
+	registerTestTypes(t)
+	store := NewInMemoryStore()
+	scan := &recordingTool{}
+	runner := composeAgent(context.Background(), &scriptedChatModel{}, []tool.BaseTool{scan}, store)
+	return runner, store, scan
+}
+
+func runWithApproval(ctx context.Context, runner compose.Runnable[string, string], checkPointID, input string) (*compose.InterruptInfo, error) {
+	_, err := runner.Invoke(ctx, input,
+		compose.WithCheckPointID(checkPointID),
+		compose.WithStateModifier(func(ctx context.Context, path compose.NodePath, s any) error {
+			s.(*common.State).UserInput = input
+			return nil
+		}),
+	)
+	info, ok := compose.ExtractInterruptInfo(err)
+	if !ok {
+		return nil, err
+	}
+	return info, nil
+}
+
+func TestApproval_NotRequiredRunsTools(t *testing.T) {
+	runner, _, scan := newApprovalTestAgent(t)
+
+	info, err := runWithApproval(context.Background(), runner, "session-1", "scan prod")
+	require.NoError(t, err)
+	assert.False(t, IsAwaitingApproval(info))
+	assert.Len(t, scan.Calls(), 1)
+}
+
+func TestApproval_WaitsAndApproves(t *testing.T) {
+	ctx := WithToolApproval(context.Background())
+	runner, store, scan := newApprovalTestAgent(t)
+
+	info, err := runWithApproval(ctx, runner, "session-1", "scan prod")
+	require.NoError(t, err)
+	require.True(t, IsAwaitingApproval(info))
+	assert.Empty(t, scan.Calls(), "tools must not run before approval")
+
+	// Reading or rewriting the waiting checkpoint does not run the tools
+	_, _, err = LoadState(context.Background(), runner, store, "session-1")
+	require.NoError(t, err)
+	require.NoError(t, RewriteState(context.Background(), runner, store, "session-1", "session-1", func(state *common.State) error { return nil }))
+	assert.Empty(t, scan.Calls())
+
+	// Without a decision the gate keeps waiting
+	info, err = runWithApproval(ctx, runner, "session-1", "")
+	require.NoError(t, err)
+	require.True(t, IsAwaitingApproval(info))
+	assert.Empty(t, scan.Calls())
+
+	// The server adds the requirement after the decision, the decision must survive
+	ctx = WithToolApproval(WithApprovalDecision(context.Background(), &ApprovalDecision{Action: ApprovalApprove}))
+	info, err = runWithApproval(ctx, runner, "session-1", "")
+	require.NoError(t, err)
+	assert.False(t, IsAwaitingApproval(info))
+	assert.Equal(t, []string{`{"target":"prod.example.com"}`}, scan.Calls())
+
+	state := info.State.(*common.State)
+	assert.Equal(t, schema.Tool, state.History[3].Role)
+	assert.Equal(t, `tool said: scanned {"target":"prod.example.com"}`, state.History[4].Content)
+}
+
+func TestApproval_Deny(t *testing.T) {
+	runner, _, scan := newApprovalTestAgent(t)
+
+	_, err := runWithApproval(WithToolApproval(context.Background()), runner, "session-1", "scan prod")
+	require.NoError(t, err)
+
+	decision := &ApprovalDecision{Action: ApprovalDeny, Reason: "production is out of scope"}
+	info, err := runWithApproval(WithApprovalDecision(context.Background(), decision), runner, "session-1", "")
+	require.NoError(t, err)
+	assert.Empty(t, scan.Calls())
+
+	// The model sees the reason as the tool result
+	state := info.State.(*common.State)
+	require.Len(t, state.History, 5)
+	assert.Equal(t, schema.Tool, state.History[3].Role)
+	assert.Equal(t, "call-1", state.History[3].ToolCallID)
+	assert.Contains(t, state.History[3].Content, "production is out of scope")
+	assert.Contains(t, state.History[4].Content, "production is out of scope")
+}
+
+func TestApproval_EditArguments(t *testing.T) {
+	runner, _, scan := newApprovalTestAgent(t)
+
+	_, err := runWithApproval(WithToolApproval(context.Background()), runner, "session-1", "scan prod")
+	require.NoError(t, err)
+
+	decision := &ApprovalDecision{Action: ApprovalEdit, Arguments: map[string]string{"call-1": `{"target":"staging.example.com"}`}}
+	info, err := runWithApproval(WithApprovalDecision(context.Background(), decision), runner, "session-1", "")
+	require.NoError(t, err)
+	assert.Equal(t, []string{`{"target":"staging.example.com"}`}, scan.Calls())
+
+	// The history shows the arguments that actually ran
+	state := info.State.(*common.State)
+	assert.Equal(t, `{"target":"staging.example.com"}`, state.History[2].ToolCalls[0].Function.Arguments)
+}
+
+func TestApprovalDecision_Validate(t *testing.T) {
+	pending := []schema.ToolCall{{ID: "call-1"}}
+
+	tests := []struct {
+		name     string
+		decision ApprovalDecision
+		wantErr  bool
+	}{
+		{name: "approve", decision: ApprovalDecision{Action: ApprovalApprove}},
+		{name: "deny", decision: ApprovalDecision{Action: ApprovalDeny, Reason: "no"}},
+		{name: "edit", decision: ApprovalDecision{Action: ApprovalEdit, Arguments: map[string]string{"call-1": `{}`}}},
+		{name: "edit without arguments", decision: ApprovalDecision{Action: ApprovalEdit}, wantErr: true},
+		{name: "edit unknown call", decision: ApprovalDecision{Action: ApprovalEdit, Arguments: map[string]string{"call-2": `{}`}}, wantErr: true},
+		{name: "edit invalid JSON", decision: ApprovalDecision{Action: ApprovalEdit, Arguments: map[string]string{"call-1": `{`}}, wantErr: true},
+		{name: "unknown action", decision: ApprovalDecision{Action: "maybe"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.decision.Validate(pending)
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
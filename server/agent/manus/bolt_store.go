@@ -0,0 +1,109 @@
+package manus
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	CheckpointBucket = "checkpoints"
+	SessionBucket    = "sessions"
+	ArtifactBucket   = "artifacts"
+)
+
+// BoltStore is a checkpoint store backed by a BoltDB file, so that graph state
+// survives server restarts. Several stores can share one file by using
+// different buckets.
+type BoltStore struct {
+	db     *bolt.DB
+	bucket []byte
+}
+
+// NewBoltStore opens (or creates) the BoltDB file at path and returns a store
+// that keeps its entries in the given bucket.
+func NewBoltStore(path string, bucket string) (*BoltStore, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open checkpoint database %s: %w", path, err)
+	}
+
+	store, err := newBoltStore(db, bucket)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return store, nil
+}
+
+func newBoltStore(db *bolt.DB, bucket string) (*BoltStore, error) {
+	err := db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists([]byte(bucket))
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create bucket %s: %w", bucket, err)
+	}
+	return &BoltStore{db: db, bucket: []byte(bucket)}, nil
+}
+
+// Bucket returns a store that shares the underlying database file but keeps
+// its entries in a different bucket.
+func (b *BoltStore) Bucket(name string) (*BoltStore, error) {
+	return newBoltStore(b.db, name)
+}
+
+func (b *BoltStore) Get(ctx context.Context, checkPointID string) ([]byte, bool, error) {
+	var data []byte
+	var ok bool
+	err := b.db.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket(b.bucket).Get([]byte(checkPointID))
+		if v == nil {
+			return nil
+		}
+		// bolt values are only valid inside the transaction
+		data = append([]byte{}, v...)
+		ok = true
+		return nil
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	return data, ok, nil
+}
+
+func (b *BoltStore) Set(ctx context.Context, checkPointID string, checkPoint []byte) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		// bolt treats a nil value as missing, store an empty slice instead
+		if checkPoint == nil {
+			checkPoint = []byte{}
+		}
+		return tx.Bucket(b.bucket).Put([]byte(checkPointID), checkPoint)
+	})
+}
+
+func (b *BoltStore) Delete(ctx context.Context, checkPointID string) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(b.bucket).Delete([]byte(checkPointID))
+	})
+}
+
+// Keys returns the IDs of all entries in the store's bucket.
+func (b *BoltStore) Keys(ctx context.Context) ([]string, error) {
+	var keys []string
+	err := b.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(b.bucket).ForEach(func(k, v []byte) error {
+			keys = append(keys, string(k))
+			return nil
+		})
+	})
+	return keys, err
+}
+
+// Close closes the underlying database file. All stores sharing the file
+// become unusable afterwards.
+func (b *BoltStore) Close() error {
+	return b.db.Close()
+}
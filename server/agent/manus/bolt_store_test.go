@@ -0,0 +1,123 @@
+package manus
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestBoltStore(t *testing.T) (*BoltStore, string) {
+	path := filepath.Join(t.TempDir(), "checkpoints.db")
+	store, err := NewBoltStore(path, CheckpointBucket)
+	require.NoError(t, err)
+	return store, path
+}
+
+func TestBoltStore_SetAndGet(t *testing.T) {
+	ctx := context.Background()
+	store, _ := newTestBoltStore(t)
+	defer store.Close()
+
+	err := store.Set(ctx, "checkpoint-1", []byte("checkpoint data"))
+	require.NoError(t, err)
+
+	data, ok, err := store.Get(ctx, "checkpoint-1")
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, []byte("checkpoint data"), data)
+
+	data, ok, err = store.Get(ctx, "non-existing-id")
+	require.NoError(t, err)
+	assert.False(t, ok)
+	assert.Nil(t, data)
+}
+
+func TestBoltStore_Overwrite(t *testing.T) {
+	ctx := context.Background()
+	store, _ := newTestBoltStore(t)
+	defer store.Close()
+
+	require.NoError(t, store.Set(ctx, "checkpoint-1", []byte("data 1")))
+	require.NoError(t, store.Set(ctx, "checkpoint-1", []byte("updated data 1")))
+
+	data, ok, err := store.Get(ctx, "checkpoint-1")
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, []byte("updated data 1"), data)
+}
+
+func TestBoltStore_EmptyData(t *testing.T) {
+	ctx := context.Background()
+	store, _ := newTestBoltStore(t)
+	defer store.Close()
+
+	require.NoError(t, store.Set(ctx, "nil-test", nil))
+
+	data, ok, err := store.Get(ctx, "nil-test")
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Len(t, data, 0)
+}
+
+func TestBoltStore_SurvivesReopen(t *testing.T) {
+	ctx := context.Background()
+	store, path := newTestBoltStore(t)
+
+	require.NoError(t, store.Set(ctx, "session-1", []byte("graph state")))
+	require.NoError(t, store.Close())
+
+	reopened, err := NewBoltStore(path, CheckpointBucket)
+	require.NoError(t, err)
+	defer reopened.Close()
+
+	data, ok, err := reopened.Get(ctx, "session-1")
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, []byte("graph state"), data)
+}
+
+func TestBoltStore_BucketsAreIsolated(t *testing.T) {
+	ctx := context.Background()
+	store, _ := newTestBoltStore(t)
+	defer store.Close()
+
+	sessions, err := store.Bucket(SessionBucket)
+	require.NoError(t, err)
+
+	require.NoError(t, store.Set(ctx, "id-1", []byte("checkpoint")))
+	require.NoError(t, sessions.Set(ctx, "id-1", []byte("metadata")))
+
+	data, _, err := store.Get(ctx, "id-1")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("checkpoint"), data)
+
+	data, _, err = sessions.Get(ctx, "id-1")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("metadata"), data)
+}
+
+func TestBoltStore_KeysAndDelete(t *testing.T) {
+	ctx := context.Background()
+	store, _ := newTestBoltStore(t)
+	defer store.Close()
+
+	for _, id := range []string{"a", "b", "c"} {
+		require.NoError(t, store.Set(ctx, id, []byte(id)))
+	}
+
+	keys, err := store.Keys(ctx)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"a", "b", "c"}, keys)
+
+	require.NoError(t, store.Delete(ctx, "b"))
+	_, ok, err := store.Get(ctx, "b")
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	keys, err = store.Keys(ctx)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"a", "c"}, keys)
+}
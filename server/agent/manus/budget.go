@@ -0,0 +1,115 @@
+package manus
+
+import (
+	"context"
+	"fmt"
+
+	"gogogajeto/agent/common"
+	"gogogajeto/util"
+
+	"github.com/cloudwego/eino/components/model"
+	"github.com/cloudwego/eino/compose"
+	"github.com/cloudwego/eino/schema"
+)
+
+// DefaultStepBudget is the number of chat model calls one request may use
+const DefaultStepBudget = 20
+
+// budgetSummaryPrompt asks for the final answer once the step budget is used up
+const budgetSummaryPrompt = "The step budget for this request is exhausted and no more tools can be run. " +
+	"Summarize what has been done and found so far, and list what remains to be done."
+
+// graphStepsPerModelCall covers the ChatModel, ToolApproval, ToolsNode and
+// Replanner steps of one tool round
+const graphStepsPerModelCall = 4
+
+// MaxGraphSteps is the runtime step limit of the graph for a step budget. The
+// budget ends the run first, the limit only guards against loops.
+func MaxGraphSteps(budget int) int {
+	return (budget+1)*graphStepsPerModelCall + 10
+}
+
+type stepBudgetKey struct{}
+
+// WithStepBudget limits the chat model calls of one request. Once the budget
+// is used up the model answers one last time without tools.
+func WithStepBudget(ctx context.Context, budget int) context.Context {
+	return context.WithValue(ctx, stepBudgetKey{}, budget)
+}
+
+func stepBudget(ctx context.Context) int {
+	budget, _ := ctx.Value(stepBudgetKey{}).(int)
+	return budget
+}
+
+// budgetExhausted reports whether the chat model used up the step budget. A
+// run without a budget is never exhausted.
+func budgetExhausted(ctx context.Context) (bool, error) {
+	budget := stepBudget(ctx)
+	if budget <= 0 {
+		return false, nil
+	}
+	var exhausted bool
+	err := compose.ProcessState[*common.State](ctx, func(ctx context.Context, state *common.State) error {
+		exhausted = state.Steps >= budget
+		return nil
+	})
+	return exhausted, err
+}
+
+// StepBudget returns the budget set with WithStepBudget, 0 if there is none
+func StepBudget(ctx context.Context) int {
+	return stepBudget(ctx)
+}
+
+// SummarizeBudget gives the model one last turn without tools once the budget
+// is used up. The tool calls of pending are answered as not run, so the history
+// stays valid for the next request. It returns those answers and the summary,
+// both to be appended to history.
+func SummarizeBudget(ctx context.Context, cm model.BaseChatModel, history []*schema.Message, pending *schema.Message, budget int) (notRun []*schema.Message, summary *schema.Message, err error) {
+	util.LogMessage(fmt.Sprintf("=== Step budget of %d exhausted, summarizing ===", budget))
+	for _, tc := range pending.ToolCalls {
+		msg := schema.ToolMessage(fmt.Sprintf("Not run: the step budget of %d steps is exhausted", budget), tc.ID)
+		msg.Name = tc.Function.Name
+		notRun = append(notRun, msg)
+	}
+
+	messages := make([]*schema.Message, 0, len(history)+len(notRun)+1)
+	messages = append(append(append(messages, history...), notRun...), schema.SystemMessage(budgetSummaryPrompt))
+	summary, err = cm.Generate(ctx, messages, model.WithToolChoice(schema.ToolChoiceForbidden))
+	if err != nil {
+		return nil, nil, err
+	}
+	// Nothing runs after the summary, drop tool calls the model made anyway
+	summary.ToolCalls = nil
+	return notRun, summary, nil
+}
+
+// budgetSummary is the graph node running SummarizeBudget on the state
+func budgetSummary(cm model.BaseChatModel) func(ctx context.Context, input *schema.Message) (*schema.Message, error) {
+	return func(ctx context.Context, input *schema.Message) (*schema.Message, error) {
+		var history []*schema.Message
+		err := compose.ProcessState[*common.State](ctx, func(ctx context.Context, state *common.State) error {
+			history = ModelHistory(state)
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		notRun, summary, err := SummarizeBudget(ctx, cm, history, input, stepBudget(ctx))
+		if err != nil {
+			return nil, err
+		}
+
+		err = compose.ProcessState[*common.State](ctx, func(ctx context.Context, state *common.State) error {
+			state.History = append(append(state.History, notRun...), summary)
+			state.BudgetExhausted = true
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		return summary, nil
+	}
+}
@@ -0,0 +1,112 @@
+package manus
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+
+	"gogogajeto/agent/common"
+
+	"github.com/cloudwego/eino/components/model"
+	"github.com/cloudwego/eino/components/tool"
+	"github.com/cloudwego/eino/compose"
+	"github.com/cloudwego/eino/schema"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// loopingChatModel asks for another scan on every call unless tools are
+// forbidden, then it summarizes
+type loopingChatModel struct {
+	mu    sync.Mutex
+	calls int
+}
+
+func (l *loopingChatModel) Generate(ctx context.Context, input []*schema.Message, opts ...model.Option) (*schema.Message, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.calls++
+
+	options := model.GetCommonOptions(nil, opts...)
+	if options.ToolChoice != nil && *options.ToolChoice == schema.ToolChoiceForbidden {
+		return schema.AssistantMessage("summary: scanned, more to do", nil), nil
+	}
+	return schema.AssistantMessage("", []schema.ToolCall{{
+		ID:       fmt.Sprintf("call-%d", l.calls),
+		Function: schema.FunctionCall{Name: "scan", Arguments: `{"target":"prod.example.com"}`},
+	}}), nil
+}
+
+func (l *loopingChatModel) Stream(ctx context.Context, input []*schema.Message, opts ...model.Option) (*schema.StreamReader[*schema.Message], error) {
+	msg, err := l.Generate(ctx, input, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return schema.StreamReaderFromArray([]*schema.Message{msg}), nil
+}
+
+func runWithBudget(runner compose.Runnable[string, string], checkPointID, input string, budget int) (*common.State, error) {
+	_, err := runner.Invoke(WithStepBudget(context.Background(), budget), input,
+		compose.WithCheckPointID(checkPointID),
+		compose.WithStateModifier(func(ctx context.Context, path compose.NodePath, s any) error {
+			s.(*common.State).UserInput = input
+			return nil
+		}),
+		compose.WithRuntimeMaxSteps(MaxGraphSteps(budget)),
+	)
+	info, ok := compose.ExtractInterruptInfo(err)
+	if !ok {
+		return nil, err
+	}
+	return info.State.(*common.State), nil
+}
+
+func TestStepBudget_SummarizesWhenExhausted(t *testing.T) {
+	registerTestTypes(t)
+	cm := &loopingChatModel{}
+	scan := &recordingTool{}
+	runner := composeAgent(context.Background(), cm, []tool.BaseTool{scan}, NewInMemoryStore())
+
+	state, err := runWithBudget(runner, "session-1", "scan prod", 3)
+	require.NoError(t, err)
+
+	assert.True(t, state.BudgetExhausted)
+	assert.Equal(t, 3, state.Steps)
+	assert.Len(t, scan.Calls(), 2, "the third round must not run tools")
+	assert.Equal(t, 4, cm.calls, "three budgeted calls and the summary")
+
+	last := state.History[len(state.History)-1]
+	assert.Equal(t, schema.Assistant, last.Role)
+	assert.Equal(t, "summary: scanned, more to do", last.Content)
+	assert.Empty(t, last.ToolCalls)
+
+	// The unanswered tool calls are closed with a result
+	notRun := state.History[len(state.History)-2]
+	assert.Equal(t, schema.Tool, notRun.Role)
+	assert.Equal(t, "call-3", notRun.ToolCallID)
+	assert.Contains(t, notRun.Content, "step budget")
+
+	// The next request gets a fresh budget
+	state, err = runWithBudget(runner, "session-1", "continue", 1)
+	require.NoError(t, err)
+	assert.True(t, state.BudgetExhausted)
+	assert.Equal(t, 1, state.Steps)
+	assert.Len(t, scan.Calls(), 2)
+}
+
+func TestStepBudget_NotExhausted(t *testing.T) {
+	runner, _, scan := newApprovalTestAgent(t)
+
+	state, err := runWithBudget(runner, "session-1", "scan prod", 5)
+	require.NoError(t, err)
+
+	assert.False(t, state.BudgetExhausted)
+	assert.Equal(t, 2, state.Steps)
+	assert.Len(t, scan.Calls(), 1)
+}
+
+func TestMaxGraphSteps(t *testing.T) {
+	assert.Greater(t, MaxGraphSteps(1), graphStepsPerModelCall*2)
+	assert.Greater(t, MaxGraphSteps(DefaultStepBudget), MaxGraphSteps(1))
+}
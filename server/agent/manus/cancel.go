@@ -0,0 +1,184 @@
+package manus
+
+import (
+	"context"
+	"errors"
+	"io"
+
+	"github.com/cloudwego/eino/components"
+	"github.com/cloudwego/eino/components/model"
+	"github.com/cloudwego/eino/components/tool"
+	"github.com/cloudwego/eino/schema"
+)
+
+// CancelledAnswer is the final answer of a cancelled request
+const CancelledAnswer = "[Cancelled by user]"
+
+// Results of the tool calls a cancelled request stopped or skipped
+const (
+	cancelledToolResult = "Cancelled by user while running, the command was stopped"
+	skippedToolResult   = "Not run: the request was cancelled by user"
+)
+
+type runKey struct{}
+
+// Cancellable returns the context to run an agent with, so that cancelling ctx
+// ends the run cleanly: running model and tool calls are stopped, open tool
+// calls are answered as cancelled, the model answers with CancelledAnswer and
+// the graph waits for the next message. The returned context itself is never
+// cancelled, so the graph reaches the Human node and checkpoints the cancelled
+// state like any other answer, and the next message resumes from there.
+func Cancellable(ctx context.Context) context.Context {
+	return context.WithValue(context.WithoutCancel(ctx), runKey{}, ctx)
+}
+
+// Cancelled reports whether the run of a context from Cancellable was cancelled
+func Cancelled(ctx context.Context) bool {
+	run, ok := ctx.Value(runKey{}).(context.Context)
+	return ok && run.Err() != nil
+}
+
+// callContext returns ctx for one model or tool call, cancelled together with
+// the run. release must be called once the call has finished.
+func callContext(ctx context.Context) (callCtx context.Context, release func()) {
+	run, ok := ctx.Value(runKey{}).(context.Context)
+	if !ok {
+		return ctx, func() {}
+	}
+	callCtx, cancel := context.WithCancelCause(ctx)
+	stop := context.AfterFunc(run, func() {
+		cancel(context.Cause(run))
+	})
+	return callCtx, func() {
+		stop()
+		cancel(nil)
+	}
+}
+
+// CancellableModel stops the wrapped chat model when the run is cancelled,
+// see Cancellable, and answers with CancelledAnswer instead
+type CancellableModel struct {
+	model.BaseChatModel
+}
+
+func NewCancellableModel(cm model.BaseChatModel) *CancellableModel {
+	return &CancellableModel{BaseChatModel: cm}
+}
+
+func (m *CancellableModel) Generate(ctx context.Context, input []*schema.Message, opts ...model.Option) (*schema.Message, error) {
+	if Cancelled(ctx) {
+		return schema.AssistantMessage(CancelledAnswer, nil), nil
+	}
+	callCtx, release := callContext(ctx)
+	defer release()
+
+	out, err := m.BaseChatModel.Generate(callCtx, input, opts...)
+	if err != nil && Cancelled(ctx) {
+		return schema.AssistantMessage(CancelledAnswer, nil), nil
+	}
+	return out, err
+}
+
+// Stream passes the chunks on until the run is cancelled, then ends the
+// message with CancelledAnswer
+func (m *CancellableModel) Stream(ctx context.Context, input []*schema.Message, opts ...model.Option) (*schema.StreamReader[*schema.Message], error) {
+	if Cancelled(ctx) {
+		return schema.StreamReaderFromArray([]*schema.Message{schema.AssistantMessage(CancelledAnswer, nil)}), nil
+	}
+	callCtx, release := callContext(ctx)
+
+	sr, err := m.BaseChatModel.Stream(callCtx, input, opts...)
+	if err != nil {
+		release()
+		if Cancelled(ctx) {
+			return schema.StreamReaderFromArray([]*schema.Message{schema.AssistantMessage(CancelledAnswer, nil)}), nil
+		}
+		return nil, err
+	}
+
+	out, writer := schema.Pipe[*schema.Message](1)
+	go func() {
+		defer release()
+		defer sr.Close()
+		defer writer.Close()
+		for {
+			chunk, err := sr.Recv()
+			if errors.Is(err, io.EOF) {
+				return
+			}
+			if err != nil && Cancelled(ctx) {
+				chunk, err = schema.AssistantMessage("\n"+CancelledAnswer, nil), nil
+				writer.Send(chunk, err)
+				return
+			}
+			if closed := writer.Send(chunk, err); closed || err != nil {
+				return
+			}
+		}
+	}()
+	return out, nil
+}
+
+func (m *CancellableModel) WithTools(tools []*schema.ToolInfo) (model.ToolCallingChatModel, error) {
+	cm, ok := m.BaseChatModel.(model.ToolCallingChatModel)
+	if !ok {
+		return nil, errors.New("chat model does not support tool binding")
+	}
+	bound, err := cm.WithTools(tools)
+	if err != nil {
+		return nil, err
+	}
+	return NewCancellableModel(bound), nil
+}
+
+// The wrapped model keeps its own type and callbacks
+
+func (m *CancellableModel) GetType() string {
+	typ, _ := components.GetType(m.BaseChatModel)
+	return typ
+}
+
+func (m *CancellableModel) IsCallbacksEnabled() bool {
+	return components.IsCallbacksEnabled(m.BaseChatModel)
+}
+
+// CancellableTools wraps the invokable tools so a cancelled run stops them,
+// see Cancellable. Cancelled calls return a result saying so instead of an
+// error, so the history stays valid.
+func CancellableTools(tools []tool.BaseTool) []tool.BaseTool {
+	wrapped := make([]tool.BaseTool, 0, len(tools))
+	for _, t := range tools {
+		if it, ok := t.(tool.InvokableTool); ok {
+			t = &cancellableTool{InvokableTool: it}
+		}
+		wrapped = append(wrapped, t)
+	}
+	return wrapped
+}
+
+type cancellableTool struct {
+	tool.InvokableTool
+}
+
+func (t *cancellableTool) InvokableRun(ctx context.Context, arguments string, opts ...tool.Option) (string, error) {
+	if Cancelled(ctx) {
+		return skippedToolResult, nil
+	}
+	callCtx, release := callContext(ctx)
+	defer release()
+
+	out, err := t.InvokableTool.InvokableRun(callCtx, arguments, opts...)
+	if err != nil && Cancelled(ctx) {
+		return cancelledToolResult, nil
+	}
+	return out, err
+}
+
+func (t *cancellableTool) GetType() string {
+	typ, _ := components.GetType(t.InvokableTool)
+	return typ
+}
+
+func (t *cancellableTool) IsCallbacksEnabled() bool {
+	return components.IsCallbacksEnabled(t.InvokableTool)
+}
@@ -0,0 +1,155 @@
+package manus
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+
+	"gogogajeto/agent/common"
+
+	"github.com/cloudwego/eino/components/model"
+	"github.com/cloudwego/eino/components/tool"
+	"github.com/cloudwego/eino/compose"
+	"github.com/cloudwego/eino/schema"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// scanningChatModel asks for a scan when told to scan and answers directly
+// otherwise
+type scanningChatModel struct {
+	calls int
+}
+
+func (s *scanningChatModel) Generate(ctx context.Context, input []*schema.Message, opts ...model.Option) (*schema.Message, error) {
+	s.calls++
+	if last := input[len(input)-1]; last.Role == schema.User && last.Content == "scan" {
+		return schema.AssistantMessage("", []schema.ToolCall{{
+			ID:       "call-1",
+			Function: schema.FunctionCall{Name: "scan", Arguments: `{"target":"10.0.0.0/8"}`},
+		}}), nil
+	}
+	return schema.AssistantMessage("done", nil), nil
+}
+
+func (s *scanningChatModel) Stream(ctx context.Context, input []*schema.Message, opts ...model.Option) (*schema.StreamReader[*schema.Message], error) {
+	msg, err := s.Generate(ctx, input, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return schema.StreamReaderFromArray([]*schema.Message{msg}), nil
+}
+
+// blockingTool runs until its context is cancelled
+type blockingTool struct {
+	started chan struct{}
+	err     error
+}
+
+func (b *blockingTool) Info(ctx context.Context) (*schema.ToolInfo, error) {
+	return &schema.ToolInfo{Name: "scan", Desc: "scan a target"}, nil
+}
+
+func (b *blockingTool) InvokableRun(ctx context.Context, arguments string, opts ...tool.Option) (string, error) {
+	close(b.started)
+	<-ctx.Done()
+	b.err = ctx.Err()
+	return "", b.err
+}
+
+func TestAgent_CancelCheckpointsResumableState(t *testing.T) {
+	registerTestTypes(t)
+	cm := &scanningChatModel{}
+	scan := &blockingTool{started: make(chan struct{})}
+	store := NewInMemoryStore()
+	runner := composeAgent(context.Background(), cm, []tool.BaseTool{scan}, store)
+
+	invoke := func(ctx context.Context, input string) (*common.State, error) {
+		_, err := runner.Invoke(ctx, input,
+			compose.WithCheckPointID("session-1"),
+			compose.WithStateModifier(func(ctx context.Context, path compose.NodePath, s any) error {
+				s.(*common.State).UserInput = input
+				return nil
+			}),
+		)
+		info, ok := compose.ExtractInterruptInfo(err)
+		if !ok {
+			return nil, err
+		}
+		return info.State.(*common.State), nil
+	}
+
+	runCtx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-scan.started
+		cancel()
+	}()
+	ctx := Cancellable(runCtx)
+	state, err := invoke(ctx, "scan")
+	require.NoError(t, err)
+
+	// The tool was stopped, its call answered and the run ended at the Human node
+	assert.True(t, Cancelled(ctx))
+	assert.ErrorIs(t, scan.err, context.Canceled)
+	require.Len(t, state.History, 5)
+	assert.Equal(t, schema.Tool, state.History[3].Role)
+	assert.Equal(t, "call-1", state.History[3].ToolCallID)
+	assert.Equal(t, cancelledToolResult, state.History[3].Content)
+	assert.Equal(t, CancelledAnswer, state.History[4].Content)
+	assert.Equal(t, 1, cm.calls, "the cancelled answer needs no model call")
+
+	// The next message continues the checkpoint
+	state, err = invoke(Cancellable(context.Background()), "what did you find?")
+	require.NoError(t, err)
+	require.Len(t, state.History, 7)
+	assert.Equal(t, "done", state.History[6].Content)
+}
+
+// stallingChatModel streams one chunk and then waits for its context
+type stallingChatModel struct {
+	scanningChatModel
+	sent chan struct{}
+}
+
+func (s *stallingChatModel) Stream(ctx context.Context, input []*schema.Message, opts ...model.Option) (*schema.StreamReader[*schema.Message], error) {
+	sr, sw := schema.Pipe[*schema.Message](1)
+	go func() {
+		defer sw.Close()
+		sw.Send(schema.AssistantMessage("partial", nil), nil)
+		close(s.sent)
+		<-ctx.Done()
+		sw.Send(nil, ctx.Err())
+	}()
+	return sr, nil
+}
+
+func TestCancellableModel_Stream(t *testing.T) {
+	inner := &stallingChatModel{sent: make(chan struct{})}
+	runCtx, cancel := context.WithCancel(context.Background())
+	ctx := Cancellable(runCtx)
+
+	sr, err := NewCancellableModel(inner).Stream(ctx, []*schema.Message{schema.UserMessage("hi")})
+	require.NoError(t, err)
+	<-inner.sent
+	cancel()
+
+	var chunks []*schema.Message
+	for {
+		chunk, err := sr.Recv()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		require.NoError(t, err)
+		chunks = append(chunks, chunk)
+	}
+	msg, err := schema.ConcatMessages(chunks)
+	require.NoError(t, err)
+	assert.Equal(t, "partial\n"+CancelledAnswer, msg.Content)
+
+	// Once cancelled the model is not called anymore
+	msg, err = NewCancellableModel(inner).Generate(ctx, []*schema.Message{schema.UserMessage("scan")})
+	require.NoError(t, err)
+	assert.Equal(t, CancelledAnswer, msg.Content)
+	assert.Zero(t, inner.calls)
+}
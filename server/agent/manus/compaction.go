@@ -0,0 +1,143 @@
+package manus
+
+import (
+	"context"
+	"fmt"
+
+	"gogogajeto/agent/common"
+	"gogogajeto/util"
+
+	"github.com/cloudwego/eino/components/model"
+	"github.com/cloudwego/eino/schema"
+)
+
+// DefaultCompactionThreshold is the estimated token count of the model input
+// above which older messages are summarized
+const DefaultCompactionThreshold = 60000
+
+// compactionPrompt asks for the summary that replaces older messages
+const compactionPrompt = "The conversation above is too long to be kept in full. " +
+	"Summarize it for yourself so you can continue the work: the user's requests, " +
+	"targets, findings such as open ports, services, versions, paths and credentials, " +
+	"what has been tried and what remains to be done. Keep exact values, leave out raw tool output."
+
+// summaryPrefix introduces the summary in the model input
+const summaryPrefix = "Summary of the earlier conversation, the original messages were compacted:\n"
+
+// charsPerToken is the rough ratio used to estimate token counts without a
+// tokenizer
+const charsPerToken = 4
+
+type compactionKey struct{}
+
+// WithCompactionThreshold makes the chat model see a summary of older messages
+// once its input is estimated above threshold tokens. The system prompt and
+// the recent messages, about half of the threshold left after the system
+// prompt, stay verbatim. A threshold of 0 or less sends the full history.
+func WithCompactionThreshold(ctx context.Context, threshold int) context.Context {
+	return context.WithValue(ctx, compactionKey{}, threshold)
+}
+
+func compactionThreshold(ctx context.Context) int {
+	threshold, _ := ctx.Value(compactionKey{}).(int)
+	return threshold
+}
+
+// ModelHistory returns the messages the chat model sees for state: the history
+// with the compacted messages replaced by their summary. state.History itself
+// always stays complete.
+func ModelHistory(state *common.State) []*schema.Message {
+	if state.Compacted <= 1 || state.Compacted > len(state.History) {
+		return append([]*schema.Message{}, state.History...)
+	}
+	messages := make([]*schema.Message, 0, len(state.History)-state.Compacted+2)
+	messages = append(messages, state.History[0], schema.SystemMessage(summaryPrefix+state.Summary))
+	return append(messages, state.History[state.Compacted:]...)
+}
+
+// CompactHistory summarizes older messages of state when the model input is
+// above the threshold set with WithCompactionThreshold. The summary extends a
+// previous one and is kept in state.Summary, state.Compacted is the number of
+// messages it stands in for, the system prompt included.
+func CompactHistory(ctx context.Context, cm model.BaseChatModel, state *common.State) error {
+	threshold := compactionThreshold(ctx)
+	if threshold <= 0 || len(state.History) == 0 || Cancelled(ctx) {
+		return nil
+	}
+	// A rewritten history no longer matches the summary
+	if state.Compacted > len(state.History) {
+		ResetCompaction(state)
+	}
+	tokens := EstimateTokens(ModelHistory(state))
+	if tokens <= threshold {
+		return nil
+	}
+
+	start := max(state.Compacted, 1)
+	keep := (threshold - estimateMessage(state.History[0])) / 2
+	cut := compactionCut(state.History, start, keep)
+	if cut <= start || cut == len(state.History) {
+		util.LogMessage(fmt.Sprintf("History of about %d tokens cannot be compacted further", tokens))
+		return nil
+	}
+	util.LogMessage(fmt.Sprintf("=== Compacting messages %d to %d, about %d tokens ===", start, cut-1, tokens))
+
+	messages := []*schema.Message{state.History[0]}
+	if state.Compacted > 1 {
+		messages = append(messages, schema.SystemMessage(summaryPrefix+state.Summary))
+	}
+	messages = append(append(messages, state.History[start:cut]...), schema.SystemMessage(compactionPrompt))
+	summary, err := cm.Generate(ctx, messages, model.WithToolChoice(schema.ToolChoiceForbidden))
+	if err != nil {
+		return fmt.Errorf("failed to compact history: %w", err)
+	}
+
+	state.Summary = summary.Content
+	state.Compacted = cut
+	util.LogMessage(fmt.Sprintf("=== Compacted to about %d tokens ===", EstimateTokens(ModelHistory(state))))
+	return nil
+}
+
+// ResetCompaction drops the summary, the model sees the full history again
+func ResetCompaction(state *common.State) {
+	state.Summary = ""
+	state.Compacted = 0
+}
+
+// compactionCut returns the first message to keep verbatim: the recent
+// messages after start that fit into keep tokens, and at least the latest
+// message with the tool results answering it. The cut never separates tool
+// results from the tool calls they answer.
+func compactionCut(history []*schema.Message, start, keep int) int {
+	cut := len(history)
+	var tokens int
+	for i := len(history) - 1; i > start; i-- {
+		tokens += estimateMessage(history[i])
+		if history[i].Role == schema.Tool {
+			continue
+		}
+		if tokens > keep && cut < len(history) {
+			break
+		}
+		cut = i
+	}
+	return cut
+}
+
+// EstimateTokens estimates the token count of messages from their length
+func EstimateTokens(messages []*schema.Message) int {
+	var tokens int
+	for _, msg := range messages {
+		tokens += estimateMessage(msg)
+	}
+	return tokens
+}
+
+func estimateMessage(msg *schema.Message) int {
+	chars := len(msg.Content)
+	for _, tc := range msg.ToolCalls {
+		chars += len(tc.Function.Name) + len(tc.Function.Arguments)
+	}
+	// A few tokens of framing per message
+	return chars/charsPerToken + 4
+}
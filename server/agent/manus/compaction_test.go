@@ -0,0 +1,189 @@
+package manus
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+
+	"gogogajeto/agent/common"
+	"gogogajeto/agent/prompts"
+
+	"github.com/cloudwego/eino/components/model"
+	"github.com/cloudwego/eino/components/tool"
+	"github.com/cloudwego/eino/compose"
+	"github.com/cloudwego/eino/schema"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// summarizingChatModel answers every request directly and numbers the
+// summaries it writes when tools are forbidden
+type summarizingChatModel struct {
+	mu        sync.Mutex
+	summaries [][]*schema.Message // Inputs of the summary calls
+	answers   [][]*schema.Message // Inputs of the other calls
+}
+
+func (s *summarizingChatModel) Generate(ctx context.Context, input []*schema.Message, opts ...model.Option) (*schema.Message, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	options := model.GetCommonOptions(nil, opts...)
+	if options.ToolChoice != nil && *options.ToolChoice == schema.ToolChoiceForbidden {
+		s.summaries = append(s.summaries, input)
+		return schema.AssistantMessage(fmt.Sprintf("summary %d", len(s.summaries)), nil), nil
+	}
+	s.answers = append(s.answers, input)
+	return schema.AssistantMessage("ok", nil), nil
+}
+
+func (s *summarizingChatModel) Stream(ctx context.Context, input []*schema.Message, opts ...model.Option) (*schema.StreamReader[*schema.Message], error) {
+	msg, err := s.Generate(ctx, input, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return schema.StreamReaderFromArray([]*schema.Message{msg}), nil
+}
+
+// verboseHistory is a conversation with two scans of about 250 tokens each
+func verboseHistory() []*schema.Message {
+	output := strings.Repeat("x", 1000)
+	scan := func(id string) *schema.Message {
+		return schema.AssistantMessage("", []schema.ToolCall{{ID: id, Function: schema.FunctionCall{Name: "scan"}}})
+	}
+	return []*schema.Message{
+		schema.SystemMessage("system"),
+		schema.UserMessage("scan 10.0.0.5"),
+		scan("call-1"),
+		schema.ToolMessage(output, "call-1"),
+		schema.AssistantMessage("port 22 is open", nil),
+		schema.UserMessage("scan 10.0.0.6"),
+		scan("call-2"),
+		schema.ToolMessage(output, "call-2"),
+	}
+}
+
+func TestCompactHistory(t *testing.T) {
+	cm := &summarizingChatModel{}
+	history := verboseHistory()
+	state := &common.State{History: append([]*schema.Message{}, history...)}
+	ctx := WithCompactionThreshold(context.Background(), 400)
+
+	require.NoError(t, CompactHistory(ctx, cm, state))
+
+	// The first scan is summarized, the latest tool round stays verbatim
+	assert.Equal(t, 6, state.Compacted)
+	assert.Equal(t, "summary 1", state.Summary)
+	assert.Equal(t, history, state.History, "the originals are kept")
+	messages := ModelHistory(state)
+	require.Len(t, messages, 4)
+	assert.Equal(t, "system", messages[0].Content)
+	assert.Equal(t, summaryPrefix+"summary 1", messages[1].Content)
+	assert.Equal(t, history[6:], messages[2:])
+
+	require.Len(t, cm.summaries, 1)
+	summarized := cm.summaries[0]
+	assert.Equal(t, history[:6], summarized[:6])
+	assert.Equal(t, compactionPrompt, summarized[6].Content)
+
+	// Below the threshold nothing happens
+	require.NoError(t, CompactHistory(ctx, cm, state))
+	assert.Len(t, cm.summaries, 1)
+
+	// The next compaction extends the summary
+	state.History = append(state.History,
+		schema.AssistantMessage("port 80 is open", nil),
+		schema.UserMessage("scan 10.0.0.7"),
+		verboseHistory()[2],
+		schema.ToolMessage(strings.Repeat("y", 1000), "call-1"),
+	)
+	require.NoError(t, CompactHistory(ctx, cm, state))
+	assert.Equal(t, 10, state.Compacted)
+	assert.Equal(t, "summary 2", state.Summary)
+	require.Len(t, cm.summaries, 2)
+	assert.Equal(t, summaryPrefix+"summary 1", cm.summaries[1][1].Content)
+	assert.Equal(t, state.History[6:10], cm.summaries[1][2:6])
+}
+
+func TestCompactHistory_Disabled(t *testing.T) {
+	cm := &summarizingChatModel{}
+	state := &common.State{History: verboseHistory()}
+
+	require.NoError(t, CompactHistory(context.Background(), cm, state))
+	assert.Zero(t, state.Compacted)
+	assert.Empty(t, cm.summaries)
+	assert.Equal(t, state.History, ModelHistory(state))
+}
+
+func TestCompactHistory_ResetsAfterRewrite(t *testing.T) {
+	cm := &summarizingChatModel{}
+	state := &common.State{History: verboseHistory()[:3], Summary: "stale", Compacted: 6}
+
+	require.NoError(t, CompactHistory(WithCompactionThreshold(context.Background(), 400), cm, state))
+	assert.Zero(t, state.Compacted)
+	assert.Empty(t, state.Summary)
+	assert.Empty(t, cm.summaries)
+}
+
+func TestCompactionCut(t *testing.T) {
+	history := verboseHistory()
+	tests := []struct {
+		name  string
+		start int
+		keep  int
+		want  int
+	}{
+		{name: "keeps the latest tool round", start: 1, keep: 0, want: 6},
+		{name: "keeps what fits", start: 1, keep: 1000, want: 2},
+		{name: "never cuts at a tool result", start: 1, keep: 400, want: 4},
+		{name: "nothing before the latest round", start: 6, keep: 0, want: len(history)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, compactionCut(history, tt.start, tt.keep))
+		})
+	}
+}
+
+func TestAgent_CompactsHistory(t *testing.T) {
+	registerTestTypes(t)
+	cm := &summarizingChatModel{}
+	store := NewInMemoryStore()
+	runner := composeAgent(context.Background(), cm, []tool.BaseTool{&recordingTool{}}, store)
+	// Room for two requests besides the system prompt
+	ctx := WithCompactionThreshold(context.Background(), EstimateTokens([]*schema.Message{schema.SystemMessage(prompts.SystemPrompt)})+300)
+
+	var state *common.State
+	for i := 0; i < 3; i++ {
+		input := fmt.Sprintf("request %d: %s", i, strings.Repeat("z", 400))
+		_, err := runner.Invoke(ctx, input,
+			compose.WithCheckPointID("session-1"),
+			compose.WithStateModifier(func(ctx context.Context, path compose.NodePath, s any) error {
+				s.(*common.State).UserInput = input
+				return nil
+			}),
+		)
+		info, ok := compose.ExtractInterruptInfo(err)
+		require.True(t, ok, "%v", err)
+		state = info.State.(*common.State)
+	}
+
+	// The model saw the summary, the checkpoint keeps every message
+	assert.Len(t, state.History, 7)
+	assert.Equal(t, 4, state.Compacted)
+	require.Len(t, cm.summaries, 1)
+	last := cm.answers[len(cm.answers)-1]
+	require.Len(t, last, 4)
+	assert.Equal(t, schema.System, last[1].Role)
+	assert.Equal(t, summaryPrefix+"summary 1", last[1].Content)
+	assert.Equal(t, state.History[4:6], last[2:])
+
+	loaded, ok, err := LoadState(context.Background(), runner, store, "session-1")
+	require.NoError(t, err)
+	require.True(t, ok)
+	assert.Equal(t, state.Summary, loaded.Summary)
+	assert.Equal(t, 4, loaded.Compacted)
+}
@@ -0,0 +1,146 @@
+package manus
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"gogogajeto/util"
+
+	"github.com/cloudwego/eino/components"
+	"github.com/cloudwego/eino/components/tool"
+)
+
+// ConcurrencyLimits caps the tool calls running at the same time, across all
+// sessions. The tool calls of one model turn run in parallel up to these
+// limits, their results keep the order of the calls.
+type ConcurrencyLimits struct {
+	Global int // All tool calls, 0 for no limit
+
+	// By tool name, or by the command a tool runs when its arguments name it
+	// in a "tool" field like kali_info_gathering does, e.g. "masscan"
+	PerTool map[string]int
+}
+
+// ParseToolLimits reads per tool limits written as "masscan=1,nmap=2"
+func ParseToolLimits(value string) (map[string]int, error) {
+	limits := make(map[string]int)
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		name, n, ok := strings.Cut(entry, "=")
+		limit, err := strconv.Atoi(strings.TrimSpace(n))
+		if !ok || strings.TrimSpace(name) == "" || err != nil || limit < 1 {
+			return nil, fmt.Errorf("invalid tool limit %q, expected name=n with n >= 1", entry)
+		}
+		limits[strings.TrimSpace(name)] = limit
+	}
+	return limits, nil
+}
+
+// LimitConcurrency wraps the invokable tools so that they keep to limits. The
+// wrapped tools share the limits, so wrap the tools once and hand the result to
+// every agent. A call waiting for a slot gives up when its context ends.
+func LimitConcurrency(tools []tool.BaseTool, limits ConcurrencyLimits) []tool.BaseTool {
+	l := &limiter{perTool: make(map[string]chan struct{})}
+	if limits.Global > 0 {
+		l.global = make(chan struct{}, limits.Global)
+	}
+	for name, n := range limits.PerTool {
+		l.perTool[name] = make(chan struct{}, n)
+	}
+
+	wrapped := make([]tool.BaseTool, 0, len(tools))
+	for _, t := range tools {
+		if it, ok := t.(tool.InvokableTool); ok {
+			info, err := it.Info(context.Background())
+			if err == nil {
+				t = &limitedTool{InvokableTool: it, name: info.Name, limiter: l}
+			}
+		}
+		wrapped = append(wrapped, t)
+	}
+	return wrapped
+}
+
+type limiter struct {
+	global  chan struct{}
+	perTool map[string]chan struct{}
+}
+
+// acquire takes a slot of every limit that applies to the call, the per tool
+// ones first so a waiting call holds no global slot
+func (l *limiter) acquire(ctx context.Context, name, arguments string) (release func(), err error) {
+	var slots []chan struct{}
+	for _, key := range limitKeys(name, arguments) {
+		if slot, ok := l.perTool[key]; ok {
+			slots = append(slots, slot)
+		}
+	}
+	if l.global != nil {
+		slots = append(slots, l.global)
+	}
+
+	release = func() {
+		for i := len(slots) - 1; i >= 0; i-- {
+			<-slots[i]
+		}
+	}
+	for i, slot := range slots {
+		select {
+		case slot <- struct{}{}:
+			continue
+		default:
+		}
+		util.LogMessage(fmt.Sprintf("Tool %s waits for a free slot", name))
+		select {
+		case slot <- struct{}{}:
+		case <-ctx.Done():
+			slots = slots[:i]
+			release()
+			return nil, ctx.Err()
+		}
+	}
+	return release, nil
+}
+
+// limitKeys returns the names a call is limited by: the tool and the command
+// named in its "tool" argument
+func limitKeys(name, arguments string) []string {
+	keys := []string{name}
+	var args struct {
+		Tool string `json:"tool"`
+	}
+	if json.Unmarshal([]byte(arguments), &args) == nil && args.Tool != "" && args.Tool != name {
+		keys = append(keys, args.Tool)
+	}
+	return keys
+}
+
+type limitedTool struct {
+	tool.InvokableTool
+	name    string
+	limiter *limiter
+}
+
+func (t *limitedTool) InvokableRun(ctx context.Context, arguments string, opts ...tool.Option) (string, error) {
+	release, err := t.limiter.acquire(ctx, t.name, arguments)
+	if err != nil {
+		return "", err
+	}
+	defer release()
+	return t.InvokableTool.InvokableRun(ctx, arguments, opts...)
+}
+
+func (t *limitedTool) GetType() string {
+	typ, _ := components.GetType(t.InvokableTool)
+	return typ
+}
+
+func (t *limitedTool) IsCallbacksEnabled() bool {
+	return components.IsCallbacksEnabled(t.InvokableTool)
+}
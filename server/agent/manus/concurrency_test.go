@@ -0,0 +1,174 @@
+package manus
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"testing"
+	"time"
+
+	"gogogajeto/agent/common"
+
+	"github.com/cloudwego/eino/components/model"
+	"github.com/cloudwego/eino/components/tool"
+	"github.com/cloudwego/eino/compose"
+	"github.com/cloudwego/eino/schema"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// kaliLikeTool runs the command named in its "tool" argument for a while and
+// tracks how many calls run at the same time
+type kaliLikeTool struct {
+	mu      sync.Mutex
+	delay   map[string]time.Duration
+	running map[string]int
+	peak    map[string]int
+	total   int
+	peakAll int
+}
+
+func newKaliLikeTool() *kaliLikeTool {
+	return &kaliLikeTool{delay: map[string]time.Duration{}, running: map[string]int{}, peak: map[string]int{}}
+}
+
+func (k *kaliLikeTool) Info(ctx context.Context) (*schema.ToolInfo, error) {
+	return &schema.ToolInfo{Name: "kali", Desc: "run a command"}, nil
+}
+
+func (k *kaliLikeTool) InvokableRun(ctx context.Context, arguments string, opts ...tool.Option) (string, error) {
+	var args struct {
+		Tool string `json:"tool"`
+	}
+	if err := json.Unmarshal([]byte(arguments), &args); err != nil {
+		return "", err
+	}
+
+	k.mu.Lock()
+	k.running[args.Tool]++
+	k.total++
+	k.peak[args.Tool] = max(k.peak[args.Tool], k.running[args.Tool])
+	k.peakAll = max(k.peakAll, k.total)
+	delay, ok := k.delay[args.Tool]
+	k.mu.Unlock()
+	if !ok {
+		delay = 20 * time.Millisecond
+	}
+
+	time.Sleep(delay)
+
+	k.mu.Lock()
+	k.running[args.Tool]--
+	k.total--
+	k.mu.Unlock()
+	return args.Tool + " done", nil
+}
+
+func TestLimitConcurrency(t *testing.T) {
+	kali := newKaliLikeTool()
+	limited := LimitConcurrency([]tool.BaseTool{kali}, ConcurrencyLimits{
+		Global:  3,
+		PerTool: map[string]int{"masscan": 1},
+	})[0].(tool.InvokableTool)
+
+	var wg sync.WaitGroup
+	for _, command := range []string{"masscan", "masscan", "masscan", "whois", "dig", "whatweb"} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := limited.InvokableRun(context.Background(), `{"tool": "`+command+`"}`)
+			assert.NoError(t, err)
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, 1, kali.peak["masscan"])
+	assert.Equal(t, 3, kali.peakAll)
+}
+
+func TestLimitConcurrency_GivesUpWhenContextEnds(t *testing.T) {
+	kali := newKaliLikeTool()
+	kali.delay["masscan"] = 200 * time.Millisecond
+	limited := LimitConcurrency([]tool.BaseTool{kali}, ConcurrencyLimits{
+		PerTool: map[string]int{"masscan": 1},
+	})[0].(tool.InvokableTool)
+
+	go limited.InvokableRun(context.Background(), `{"tool": "masscan"}`)
+	time.Sleep(20 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err := limited.InvokableRun(ctx, `{"tool": "masscan"}`)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	// Other commands are not held up
+	out, err := limited.InvokableRun(context.Background(), `{"tool": "whois"}`)
+	require.NoError(t, err)
+	assert.Equal(t, "whois done", out)
+}
+
+func TestParseToolLimits(t *testing.T) {
+	limits, err := ParseToolLimits(" masscan=1, nmap = 2,,")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int{"masscan": 1, "nmap": 2}, limits)
+
+	for _, value := range []string{"masscan", "masscan=0", "=1", "nmap=two"} {
+		_, err := ParseToolLimits(value)
+		assert.Error(t, err, value)
+	}
+}
+
+// fanOutChatModel asks for several commands in one turn, then answers
+type fanOutChatModel struct {
+	commands []string
+}
+
+func (f *fanOutChatModel) Generate(ctx context.Context, input []*schema.Message, opts ...model.Option) (*schema.Message, error) {
+	if input[len(input)-1].Role == schema.Tool {
+		return schema.AssistantMessage("all done", nil), nil
+	}
+	calls := make([]schema.ToolCall, len(f.commands))
+	for i, command := range f.commands {
+		calls[i] = schema.ToolCall{
+			ID:       "call-" + command,
+			Function: schema.FunctionCall{Name: "kali", Arguments: `{"tool": "` + command + `"}`},
+		}
+	}
+	return schema.AssistantMessage("", calls), nil
+}
+
+func (f *fanOutChatModel) Stream(ctx context.Context, input []*schema.Message, opts ...model.Option) (*schema.StreamReader[*schema.Message], error) {
+	msg, err := f.Generate(ctx, input, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return schema.StreamReaderFromArray([]*schema.Message{msg}), nil
+}
+
+func TestAgent_ParallelToolCallsKeepCallOrder(t *testing.T) {
+	registerTestTypes(t)
+	kali := newKaliLikeTool()
+	// The first call finishes last
+	kali.delay["whois"] = 60 * time.Millisecond
+	cm := &fanOutChatModel{commands: []string{"whois", "dig", "whatweb"}}
+	runner := composeAgent(context.Background(), cm, LimitConcurrency([]tool.BaseTool{kali}, ConcurrencyLimits{Global: 4}), NewInMemoryStore())
+
+	_, err := runner.Invoke(context.Background(), "recon example.com",
+		compose.WithCheckPointID("session-1"),
+		compose.WithStateModifier(func(ctx context.Context, path compose.NodePath, s any) error {
+			s.(*common.State).UserInput = "recon example.com"
+			return nil
+		}),
+	)
+	info, ok := compose.ExtractInterruptInfo(err)
+	require.True(t, ok, "%v", err)
+	state := info.State.(*common.State)
+
+	assert.Equal(t, 3, kali.peakAll, "the calls ran at the same time")
+	require.Len(t, state.History, 7)
+	for i, command := range cm.commands {
+		result := state.History[3+i]
+		assert.Equal(t, "call-"+command, result.ToolCallID)
+		assert.Equal(t, command+" done", result.Content)
+	}
+}
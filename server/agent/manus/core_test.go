@@ -3,6 +3,7 @@ package manus
 import (
 	"context"
 	"fmt"
+	"sync"
 	"testing"
 
 	"github.com/cloudwego/eino/schema"
@@ -209,6 +210,31 @@ func TestInMemoryStore_ConcurrentAccess_Core(t *testing.T) {
 	}
 }
 
+func TestInMemoryStore_ConcurrentSetDelete_Core(t *testing.T) {
+	ctx := context.Background()
+	store := NewInMemoryStore()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			key := fmt.Sprintf("key-%d", i)
+			assert.NoError(t, store.Set(ctx, key, []byte("data")))
+			if i%2 == 0 {
+				assert.NoError(t, store.Delete(ctx, key))
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	for i := 0; i < 50; i++ {
+		_, ok, err := store.Get(ctx, fmt.Sprintf("key-%d", i))
+		require.NoError(t, err)
+		assert.Equal(t, i%2 != 0, ok)
+	}
+}
+
 // Test the node key constants
 func TestNodeKeyConstants_Core(t *testing.T) {
 	expectedKeys := map[string]string{
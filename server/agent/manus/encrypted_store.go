@@ -0,0 +1,214 @@
+package manus
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// KeySize is the length of an encryption key in bytes (AES-256)
+const KeySize = 32
+
+// Every encrypted value starts with this header, followed by the ID of the key
+// it was encrypted with, the nonce and the sealed data.
+var encryptedHeader = []byte("GGE\x01")
+
+const keyIDSize = 8
+
+var (
+	ErrUnknownKey   = errors.New("value was encrypted with an unknown key")
+	ErrNotEncrypted = errors.New("value is not encrypted")
+)
+
+// KeyedStore is a store whose entries can be listed
+type KeyedStore interface {
+	Store
+	Keys(ctx context.Context) ([]string, error)
+}
+
+type encryptionKey struct {
+	id   []byte
+	aead cipher.AEAD
+}
+
+// EncryptedStore encrypts the values of any store with AES-GCM. The first key
+// encrypts, all keys decrypt, so keys can be rotated by putting a new key in
+// front and calling Migrate. The entry ID is bound to the ciphertext, so values
+// cannot be swapped between entries unnoticed.
+type EncryptedStore struct {
+	store Store
+	keys  []encryptionKey // The primary key comes first
+}
+
+// NewEncryptedStore wraps store. keys must hold at least one KeySize byte key;
+// the first one is used for new values.
+func NewEncryptedStore(store Store, keys [][]byte) (*EncryptedStore, error) {
+	if len(keys) == 0 {
+		return nil, errors.New("no encryption key configured")
+	}
+
+	e := &EncryptedStore{store: store}
+	for i, key := range keys {
+		if len(key) != KeySize {
+			return nil, fmt.Errorf("encryption key %d has %d bytes, want %d", i+1, len(key), KeySize)
+		}
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, err
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+		sum := sha256.Sum256(key)
+		e.keys = append(e.keys, encryptionKey{id: sum[:keyIDSize], aead: aead})
+	}
+	return e, nil
+}
+
+// ParseKeys reads base64 encoded keys separated by newlines or commas, as found
+// in a keyfile or an environment variable. Blank lines and lines starting with
+// # are ignored.
+func ParseKeys(data string) ([][]byte, error) {
+	var keys [][]byte
+	for _, line := range strings.Split(data, "\n") {
+		if line = strings.TrimSpace(line); line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		for _, value := range strings.Split(line, ",") {
+			if value = strings.TrimSpace(value); value == "" {
+				continue
+			}
+			key, err := base64.StdEncoding.DecodeString(value)
+			if err != nil {
+				return nil, fmt.Errorf("encryption key %d is not valid base64: %w", len(keys)+1, err)
+			}
+			keys = append(keys, key)
+		}
+	}
+	return keys, nil
+}
+
+func (e *EncryptedStore) Get(ctx context.Context, checkPointID string) ([]byte, bool, error) {
+	data, ok, err := e.store.Get(ctx, checkPointID)
+	if err != nil || !ok {
+		return nil, ok, err
+	}
+
+	plain, err := e.decrypt(checkPointID, data)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to decrypt %s: %w", checkPointID, err)
+	}
+	return plain, true, nil
+}
+
+func (e *EncryptedStore) Set(ctx context.Context, checkPointID string, checkPoint []byte) error {
+	data, err := e.encrypt(checkPointID, checkPoint)
+	if err != nil {
+		return err
+	}
+	return e.store.Set(ctx, checkPointID, data)
+}
+
+func (e *EncryptedStore) Delete(ctx context.Context, checkPointID string) error {
+	return e.store.Delete(ctx, checkPointID)
+}
+
+// Keys lists the entries of the underlying store if it supports listing
+func (e *EncryptedStore) Keys(ctx context.Context) ([]string, error) {
+	keyed, ok := e.store.(KeyedStore)
+	if !ok {
+		return nil, fmt.Errorf("store %T cannot list its entries", e.store)
+	}
+	return keyed.Keys(ctx)
+}
+
+// Migrate re-encrypts every entry that is not yet encrypted with the primary
+// key: plaintext written before encryption was enabled, and values sealed with
+// a rotated key. It returns the number of rewritten entries.
+func (e *EncryptedStore) Migrate(ctx context.Context) (int, error) {
+	ids, err := e.Keys(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	migrated := 0
+	for _, id := range ids {
+		data, ok, err := e.store.Get(ctx, id)
+		if err != nil {
+			return migrated, err
+		}
+		if !ok || e.isPrimary(data) {
+			continue
+		}
+
+		plain, err := e.decrypt(id, data)
+		if errors.Is(err, ErrNotEncrypted) {
+			plain, err = data, nil
+		}
+		if err != nil {
+			return migrated, fmt.Errorf("failed to migrate %s: %w", id, err)
+		}
+		if err := e.Set(ctx, id, plain); err != nil {
+			return migrated, err
+		}
+		migrated++
+	}
+	return migrated, nil
+}
+
+func (e *EncryptedStore) isPrimary(data []byte) bool {
+	return bytes.HasPrefix(data, encryptedHeader) &&
+		bytes.HasPrefix(data[len(encryptedHeader):], e.keys[0].id)
+}
+
+func (e *EncryptedStore) encrypt(checkPointID string, plain []byte) ([]byte, error) {
+	key := e.keys[0]
+	nonce := make([]byte, key.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	out := make([]byte, 0, len(encryptedHeader)+keyIDSize+len(nonce)+len(plain)+key.aead.Overhead())
+	out = append(out, encryptedHeader...)
+	out = append(out, key.id...)
+	out = append(out, nonce...)
+	return key.aead.Seal(out, nonce, plain, []byte(checkPointID)), nil
+}
+
+func (e *EncryptedStore) decrypt(checkPointID string, data []byte) ([]byte, error) {
+	if !bytes.HasPrefix(data, encryptedHeader) {
+		return nil, ErrNotEncrypted
+	}
+	data = data[len(encryptedHeader):]
+	if len(data) < keyIDSize {
+		return nil, errors.New("encrypted value is truncated")
+	}
+
+	id, data := data[:keyIDSize], data[keyIDSize:]
+	for _, key := range e.keys {
+		if !bytes.Equal(key.id, id) {
+			continue
+		}
+		if len(data) < key.aead.NonceSize() {
+			return nil, errors.New("encrypted value is truncated")
+		}
+		nonce, sealed := data[:key.aead.NonceSize()], data[key.aead.NonceSize():]
+		plain, err := key.aead.Open(nil, nonce, sealed, []byte(checkPointID))
+		if err != nil {
+			return nil, err
+		}
+		if plain == nil {
+			plain = []byte{}
+		}
+		return plain, nil
+	}
+	return nil, ErrUnknownKey
+}
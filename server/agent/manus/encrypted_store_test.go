@@ -0,0 +1,167 @@
+package manus
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestKey(t *testing.T) []byte {
+	key := make([]byte, KeySize)
+	_, err := rand.Read(key)
+	require.NoError(t, err)
+	return key
+}
+
+func TestEncryptedStore_RoundTrip(t *testing.T) {
+	ctx := context.Background()
+	inner := NewInMemoryStore()
+	store, err := NewEncryptedStore(inner, [][]byte{newTestKey(t)})
+	require.NoError(t, err)
+
+	secret := []byte("22/tcp open ssh; admin:hunter2")
+	require.NoError(t, store.Set(ctx, "checkpoint-1", secret))
+
+	data, ok, err := store.Get(ctx, "checkpoint-1")
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, secret, data)
+
+	raw, _, _ := inner.Get(ctx, "checkpoint-1")
+	assert.False(t, bytes.Contains(raw, []byte("hunter2")), "value must not be stored in plaintext")
+
+	_, ok, err = store.Get(ctx, "missing")
+	require.NoError(t, err)
+	assert.False(t, ok)
+}
+
+func TestEncryptedStore_EmptyValue(t *testing.T) {
+	ctx := context.Background()
+	store, err := NewEncryptedStore(NewInMemoryStore(), [][]byte{newTestKey(t)})
+	require.NoError(t, err)
+
+	require.NoError(t, store.Set(ctx, "empty", nil))
+	data, ok, err := store.Get(ctx, "empty")
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Empty(t, data)
+}
+
+func TestEncryptedStore_WrongKey(t *testing.T) {
+	ctx := context.Background()
+	inner := NewInMemoryStore()
+	store, err := NewEncryptedStore(inner, [][]byte{newTestKey(t)})
+	require.NoError(t, err)
+	require.NoError(t, store.Set(ctx, "checkpoint-1", []byte("data")))
+
+	other, err := NewEncryptedStore(inner, [][]byte{newTestKey(t)})
+	require.NoError(t, err)
+	_, _, err = other.Get(ctx, "checkpoint-1")
+	assert.ErrorIs(t, err, ErrUnknownKey)
+}
+
+func TestEncryptedStore_DetectsSwappedAndTamperedValues(t *testing.T) {
+	ctx := context.Background()
+	inner := NewInMemoryStore()
+	store, err := NewEncryptedStore(inner, [][]byte{newTestKey(t)})
+	require.NoError(t, err)
+	require.NoError(t, store.Set(ctx, "session-a", []byte("data a")))
+
+	// A value copied to another ID no longer decrypts
+	raw, _, _ := inner.Get(ctx, "session-a")
+	require.NoError(t, inner.Set(ctx, "session-b", raw))
+	_, _, err = store.Get(ctx, "session-b")
+	assert.Error(t, err)
+
+	tampered := append([]byte{}, raw...)
+	tampered[len(tampered)-1] ^= 0xff
+	require.NoError(t, inner.Set(ctx, "session-a", tampered))
+	_, _, err = store.Get(ctx, "session-a")
+	assert.Error(t, err)
+}
+
+func TestEncryptedStore_RejectsPlaintext(t *testing.T) {
+	ctx := context.Background()
+	inner := NewInMemoryStore()
+	require.NoError(t, inner.Set(ctx, "legacy", []byte("written before encryption")))
+
+	store, err := NewEncryptedStore(inner, [][]byte{newTestKey(t)})
+	require.NoError(t, err)
+	_, _, err = store.Get(ctx, "legacy")
+	assert.ErrorIs(t, err, ErrNotEncrypted)
+}
+
+func TestEncryptedStore_MigrateAndRotate(t *testing.T) {
+	ctx := context.Background()
+	inner := NewInMemoryStore()
+	require.NoError(t, inner.Set(ctx, "legacy", []byte("plain data")))
+
+	oldKey, newKey := newTestKey(t), newTestKey(t)
+	oldStore, err := NewEncryptedStore(inner, [][]byte{oldKey})
+	require.NoError(t, err)
+
+	// Enabling encryption encrypts the plaintext entry
+	migrated, err := oldStore.Migrate(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, migrated)
+	require.NoError(t, oldStore.Set(ctx, "current", []byte("old key data")))
+
+	// Rotation: the new key comes first, the old one still decrypts
+	rotated, err := NewEncryptedStore(inner, [][]byte{newKey, oldKey})
+	require.NoError(t, err)
+	data, _, err := rotated.Get(ctx, "current")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("old key data"), data)
+
+	migrated, err = rotated.Migrate(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 2, migrated)
+
+	// After migrating, the old key can be dropped
+	newOnly, err := NewEncryptedStore(inner, [][]byte{newKey})
+	require.NoError(t, err)
+	for id, want := range map[string]string{"legacy": "plain data", "current": "old key data"} {
+		data, ok, err := newOnly.Get(ctx, id)
+		require.NoError(t, err)
+		assert.True(t, ok)
+		assert.Equal(t, []byte(want), data)
+	}
+
+	migrated, err = newOnly.Migrate(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 0, migrated)
+}
+
+func TestEncryptedStore_InvalidKeys(t *testing.T) {
+	_, err := NewEncryptedStore(NewInMemoryStore(), nil)
+	assert.Error(t, err)
+
+	_, err = NewEncryptedStore(NewInMemoryStore(), [][]byte{[]byte("too short")})
+	assert.Error(t, err)
+}
+
+func TestParseKeys(t *testing.T) {
+	key1, key2 := newTestKey(t), newTestKey(t)
+	enc1 := base64.StdEncoding.EncodeToString(key1)
+	enc2 := base64.StdEncoding.EncodeToString(key2)
+
+	keys, err := ParseKeys("# current key\n" + enc1 + "\n\n# previous key\n" + enc2 + "\n")
+	require.NoError(t, err)
+	assert.Equal(t, [][]byte{key1, key2}, keys)
+
+	keys, err = ParseKeys(enc1 + ", " + enc2)
+	require.NoError(t, err)
+	assert.Equal(t, [][]byte{key1, key2}, keys)
+
+	keys, err = ParseKeys("")
+	require.NoError(t, err)
+	assert.Empty(t, keys)
+
+	_, err = ParseKeys("not base64!")
+	assert.Error(t, err)
+}
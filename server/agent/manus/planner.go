@@ -0,0 +1,287 @@
+package manus
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"gogogajeto/agent/common"
+	"gogogajeto/agent/prompts"
+	"gogogajeto/agent/tools"
+	"gogogajeto/util"
+
+	"github.com/cloudwego/eino/components/model"
+	"github.com/cloudwego/eino/components/tool"
+	"github.com/cloudwego/eino/compose"
+	"github.com/cloudwego/eino/schema"
+)
+
+const (
+	NodeKeyPlanner   = "Planner"
+	NodeKeyReplanner = "Replanner"
+)
+
+var errInvalidPlan = errors.New("invalid plan")
+
+// CreatePlannerAgent creates the planner/executor variant of the agent. A
+// planner turns every request into a task list kept in common.State.Plan, the
+// chat model works through it with the tools, and a replanner updates the
+// list after every tool result. modelName picks the chat model as in CreateAgent.
+func CreatePlannerAgent(store compose.CheckPointStore, allTools []tool.BaseTool, modelName string) compose.Runnable[string, string] {
+	util.LogMessage("=== PLANNER AGENT CREATION START ===")
+	ctx := context.Background()
+
+	util.LogMessage("Creating chat model...")
+	cm := tools.NewChatModelFor(ctx, modelName)
+	cm = tools.BindTools(ctx, cm, allTools)
+
+	util.LogMessage("Composing planner agent...")
+	agent := composePlannerAgent(ctx, cm, allTools, store)
+
+	util.LogMessage("=== PLANNER AGENT CREATION COMPLETE ===")
+	return agent
+}
+
+func composePlannerAgent(ctx context.Context,
+	cm model.BaseChatModel,
+	tools []tool.BaseTool,
+	store compose.CheckPointStore,
+) compose.Runnable[string, string] {
+	return composeGraph(ctx, cm, tools, store, true)
+}
+
+type planHandlerKey struct{}
+
+// PlanHandler is called with the new plan whenever the planner or replanner
+// changed it
+type PlanHandler func(plan []common.Task)
+
+// WithPlanHandler reports plan updates of the run to handler
+func WithPlanHandler(ctx context.Context, handler PlanHandler) context.Context {
+	return context.WithValue(ctx, planHandlerKey{}, handler)
+}
+
+func notifyPlan(ctx context.Context, plan []common.Task) {
+	if handler, ok := ctx.Value(planHandlerKey{}).(PlanHandler); ok && handler != nil {
+		handler(append([]common.Task{}, plan...))
+	}
+}
+
+// planner writes the task list for a new request. The user message is passed
+// on to the chat model unchanged.
+func planner(cm model.BaseChatModel) func(ctx context.Context, in []*schema.Message) ([]*schema.Message, error) {
+	return func(ctx context.Context, in []*schema.Message) ([]*schema.Message, error) {
+		util.LogMessage("=== Planner Node START ===")
+		if Cancelled(ctx) {
+			return in, nil
+		}
+		history, current, err := planContext(ctx, in)
+		if err != nil {
+			return nil, err
+		}
+
+		plan, err := requestPlan(ctx, cm, prompts.PlannerPrompt, history, current)
+		if errors.Is(err, errInvalidPlan) {
+			// Without a usable plan the request itself is the only task
+			util.LogMessage("Planner returned no usable plan: " + err.Error())
+			plan = normalizePlan(append(finishedTasks(current), common.Task{Title: lastUserRequest(in)}))
+		} else if err != nil {
+			return nil, err
+		}
+
+		if err := storePlan(ctx, plan); err != nil {
+			return nil, err
+		}
+		util.LogMessage(fmt.Sprintf("=== Planner Node END, %d tasks ===", len(plan)))
+		return in, nil
+	}
+}
+
+// replanner updates the task list with the tool results. The results are
+// passed on to the chat model unchanged.
+func replanner(cm model.BaseChatModel) func(ctx context.Context, in []*schema.Message) ([]*schema.Message, error) {
+	return func(ctx context.Context, in []*schema.Message) ([]*schema.Message, error) {
+		util.LogMessage("=== Replanner Node START ===")
+		if Cancelled(ctx) {
+			return in, nil
+		}
+		history, current, err := planContext(ctx, in)
+		if err != nil {
+			return nil, err
+		}
+		if len(current) == 0 {
+			return in, nil
+		}
+
+		plan, err := requestPlan(ctx, cm, prompts.ReplannerPrompt, history, current)
+		if errors.Is(err, errInvalidPlan) {
+			// Keep working on the old plan rather than losing it
+			util.LogMessage("Replanner returned no usable plan: " + err.Error())
+			return in, nil
+		} else if err != nil {
+			return nil, err
+		}
+
+		if err := storePlan(ctx, keepFinished(current, plan)); err != nil {
+			return nil, err
+		}
+		util.LogMessage("=== Replanner Node END ===")
+		return in, nil
+	}
+}
+
+// planContext returns the history as the chat model sees it, including the
+// messages not yet added by the chat model node, and a copy of the current plan
+func planContext(ctx context.Context, in []*schema.Message) (history []*schema.Message, plan []common.Task, err error) {
+	err = compose.ProcessState[*common.State](ctx, func(ctx context.Context, state *common.State) error {
+		history = append(ModelHistory(state), in...)
+		plan = append([]common.Task{}, state.Plan...)
+		return nil
+	})
+	return history, plan, err
+}
+
+func storePlan(ctx context.Context, plan []common.Task) error {
+	err := compose.ProcessState[*common.State](ctx, func(ctx context.Context, state *common.State) error {
+		state.Plan = plan
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	notifyPlan(ctx, plan)
+	return nil
+}
+
+// requestPlan asks the model for a plan without tools and parses the answer
+func requestPlan(ctx context.Context, cm model.BaseChatModel, prompt string, history []*schema.Message, current []common.Task) ([]common.Task, error) {
+	b, err := json.Marshal(map[string][]common.Task{"tasks": current})
+	if err != nil {
+		return nil, err
+	}
+	messages := append(history, schema.SystemMessage(prompt+"\nCurrent plan:\n"+string(b)))
+
+	out, err := cm.Generate(ctx, messages, model.WithToolChoice(schema.ToolChoiceForbidden))
+	if err != nil {
+		return nil, err
+	}
+	return parsePlan(out.Content)
+}
+
+// parsePlan reads the {"tasks": [...]} object from a model answer, which may
+// be wrapped in a code fence or text
+func parsePlan(content string) ([]common.Task, error) {
+	start := strings.Index(content, "{")
+	end := strings.LastIndex(content, "}")
+	if start < 0 || end < start {
+		return nil, fmt.Errorf("%w: no JSON object in answer", errInvalidPlan)
+	}
+
+	var answer struct {
+		Tasks []common.Task `json:"tasks"`
+	}
+	if err := json.Unmarshal([]byte(content[start:end+1]), &answer); err != nil {
+		return nil, fmt.Errorf("%w: %v", errInvalidPlan, err)
+	}
+	plan := normalizePlan(answer.Tasks)
+	if len(plan) == 0 {
+		return nil, fmt.Errorf("%w: no tasks", errInvalidPlan)
+	}
+	return plan, nil
+}
+
+// normalizePlan drops tasks without a title, fixes unknown statuses and
+// duplicate IDs, and marks the first pending task in progress if none is
+func normalizePlan(tasks []common.Task) []common.Task {
+	plan := make([]common.Task, 0, len(tasks))
+	seen := make(map[int]bool)
+	renumber := false
+	for _, task := range tasks {
+		task.Title = strings.TrimSpace(task.Title)
+		if task.Title == "" {
+			continue
+		}
+		switch task.Status {
+		case common.TaskPending, common.TaskInProgress, common.TaskDone, common.TaskSkipped:
+		default:
+			task.Status = common.TaskPending
+		}
+		if task.ID <= 0 || seen[task.ID] {
+			renumber = true
+		}
+		seen[task.ID] = true
+		plan = append(plan, task)
+	}
+	if renumber {
+		for i := range plan {
+			plan[i].ID = i + 1
+		}
+	}
+
+	inProgress := -1
+	for i, task := range plan {
+		if task.Status == common.TaskInProgress {
+			inProgress = i
+			break
+		}
+	}
+	if inProgress < 0 {
+		for i, task := range plan {
+			if task.Status == common.TaskPending {
+				plan[i].Status = common.TaskInProgress
+				break
+			}
+		}
+	}
+	return plan
+}
+
+// keepFinished restores tasks the replanner must not change
+func keepFinished(current, plan []common.Task) []common.Task {
+	finished := make(map[int]common.Task)
+	for _, task := range finishedTasks(current) {
+		finished[task.ID] = task
+	}
+	for i, task := range plan {
+		if old, ok := finished[task.ID]; ok {
+			plan[i] = old
+		}
+	}
+	return plan
+}
+
+func finishedTasks(plan []common.Task) []common.Task {
+	var finished []common.Task
+	for _, task := range plan {
+		if task.Status == common.TaskDone || task.Status == common.TaskSkipped {
+			finished = append(finished, task)
+		}
+	}
+	return finished
+}
+
+func lastUserRequest(messages []*schema.Message) string {
+	for i := len(messages) - 1; i >= 0; i-- {
+		if messages[i].Role == schema.User {
+			return messages[i].Content
+		}
+	}
+	return "Answer the request"
+}
+
+// renderPlan describes the plan to the chat model
+func renderPlan(plan []common.Task) string {
+	var b strings.Builder
+	b.WriteString("Plan for the current request:\n")
+	for _, task := range plan {
+		fmt.Fprintf(&b, "%d. [%s] %s", task.ID, task.Status, task.Title)
+		if task.Result != "" {
+			b.WriteString(" - " + task.Result)
+		}
+		b.WriteString("\n")
+	}
+	b.WriteString("Work on the task in progress. Once every task is done or skipped, answer without tools and report the results.")
+	return b.String()
+}
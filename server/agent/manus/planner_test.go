@@ -0,0 +1,155 @@
+package manus
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+
+	"gogogajeto/agent/common"
+	"gogogajeto/agent/prompts"
+
+	"github.com/cloudwego/eino/components/model"
+	"github.com/cloudwego/eino/components/tool"
+	"github.com/cloudwego/eino/compose"
+	"github.com/cloudwego/eino/schema"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// planningChatModel answers the planner and replanner prompts with fixed
+// plans and otherwise runs one scan before answering
+type planningChatModel struct {
+	mu       sync.Mutex
+	executor [][]*schema.Message // Inputs of the executor calls
+}
+
+func (p *planningChatModel) Generate(ctx context.Context, input []*schema.Message, opts ...model.Option) (*schema.Message, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	last := input[len(input)-1]
+	switch {
+	case strings.Contains(last.Content, prompts.PlannerPrompt):
+		return schema.AssistantMessage("```json\n"+`{"tasks": [{"id": 1, "title": "Scan ports"}, {"id": 2, "title": "Report"}]}`+"\n```", nil), nil
+	case strings.Contains(last.Content, prompts.ReplannerPrompt):
+		return schema.AssistantMessage(`{"tasks": [{"id": 1, "title": "Scan ports", "status": "done", "result": "22 open"}, {"id": 2, "title": "Report", "status": "pending"}]}`, nil), nil
+	}
+
+	p.executor = append(p.executor, input)
+	for _, msg := range input {
+		if msg.Role == schema.Tool {
+			return schema.AssistantMessage("port 22 is open", nil), nil
+		}
+	}
+	return schema.AssistantMessage("", []schema.ToolCall{{
+		ID:       "call-1",
+		Function: schema.FunctionCall{Name: "scan", Arguments: `{"target":"10.0.0.5"}`},
+	}}), nil
+}
+
+func (p *planningChatModel) Stream(ctx context.Context, input []*schema.Message, opts ...model.Option) (*schema.StreamReader[*schema.Message], error) {
+	msg, err := p.Generate(ctx, input, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return schema.StreamReaderFromArray([]*schema.Message{msg}), nil
+}
+
+func TestPlannerAgent_PlansAndReplans(t *testing.T) {
+	registerTestTypes(t)
+	cm := &planningChatModel{}
+	store := NewInMemoryStore()
+	runner := composePlannerAgent(context.Background(), cm, []tool.BaseTool{&recordingTool{}}, store)
+
+	var updates [][]common.Task
+	ctx := WithPlanHandler(context.Background(), func(plan []common.Task) {
+		updates = append(updates, plan)
+	})
+	_, err := runner.Invoke(ctx, "assess 10.0.0.5", compose.WithCheckPointID("session-1"))
+	info, ok := compose.ExtractInterruptInfo(err)
+	require.True(t, ok, "%v", err)
+	state := info.State.(*common.State)
+
+	// Planned, then updated after the scan
+	require.Len(t, updates, 2)
+	assert.Equal(t, []common.Task{
+		{ID: 1, Title: "Scan ports", Status: common.TaskInProgress},
+		{ID: 2, Title: "Report", Status: common.TaskPending},
+	}, updates[0])
+	assert.Equal(t, []common.Task{
+		{ID: 1, Title: "Scan ports", Status: common.TaskDone, Result: "22 open"},
+		{ID: 2, Title: "Report", Status: common.TaskInProgress},
+	}, state.Plan)
+
+	// The executor sees the plan, the history does not keep it
+	require.Len(t, cm.executor, 2)
+	last := cm.executor[1][len(cm.executor[1])-1]
+	assert.Equal(t, schema.System, last.Role)
+	assert.Contains(t, last.Content, "1. [done] Scan ports - 22 open")
+	for _, msg := range state.History[1:] {
+		assert.NotEqual(t, schema.System, msg.Role)
+	}
+	assert.Equal(t, "port 22 is open", state.History[len(state.History)-1].Content)
+
+	// The plan is part of the checkpoint
+	loaded, ok, err := LoadState(context.Background(), runner, store, "session-1")
+	require.NoError(t, err)
+	require.True(t, ok)
+	assert.Equal(t, state.Plan, loaded.Plan)
+}
+
+func TestParsePlan(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    []common.Task
+		wantErr bool
+	}{
+		{
+			name:    "plain",
+			content: `{"tasks": [{"id": 1, "title": "a", "status": "done"}, {"id": 2, "title": "b"}]}`,
+			want:    []common.Task{{ID: 1, Title: "a", Status: common.TaskDone}, {ID: 2, Title: "b", Status: common.TaskInProgress}},
+		},
+		{
+			name:    "text around, missing and duplicate IDs",
+			content: "Here is the plan:\n" + `{"tasks": [{"title": "a"}, {"id": 1, "title": "b", "status": "unknown"}]}` + "\nGood luck",
+			want:    []common.Task{{ID: 1, Title: "a", Status: common.TaskInProgress}, {ID: 2, Title: "b", Status: common.TaskPending}},
+		},
+		{
+			name:    "empty titles dropped",
+			content: `{"tasks": [{"id": 1, "title": " "}, {"id": 2, "title": "b", "status": "in_progress"}]}`,
+			want:    []common.Task{{ID: 2, Title: "b", Status: common.TaskInProgress}},
+		},
+		{name: "no JSON", content: "I will scan first", wantErr: true},
+		{name: "no tasks", content: `{"tasks": []}`, wantErr: true},
+		{name: "broken JSON", content: `{"tasks": [}`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parsePlan(tt.content)
+			if tt.wantErr {
+				assert.ErrorIs(t, err, errInvalidPlan)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestKeepFinished(t *testing.T) {
+	current := []common.Task{
+		{ID: 1, Title: "a", Status: common.TaskDone, Result: "ok"},
+		{ID: 2, Title: "b", Status: common.TaskInProgress},
+	}
+	plan := []common.Task{
+		{ID: 1, Title: "a changed", Status: common.TaskPending},
+		{ID: 2, Title: "b", Status: common.TaskDone, Result: "found"},
+	}
+	assert.Equal(t, []common.Task{
+		{ID: 1, Title: "a", Status: common.TaskDone, Result: "ok"},
+		{ID: 2, Title: "b", Status: common.TaskDone, Result: "found"},
+	}, keepFinished(current, plan))
+}
@@ -0,0 +1,25 @@
+package manus
+
+import (
+	"context"
+
+	"gogogajeto/agent/prompts"
+)
+
+type systemPromptKey struct{}
+
+// WithSystemPrompt sets the system prompt a new conversation starts with,
+// e.g. the one of an agent profile. Conversations that have started keep
+// theirs.
+func WithSystemPrompt(ctx context.Context, prompt string) context.Context {
+	return context.WithValue(ctx, systemPromptKey{}, prompt)
+}
+
+// SystemPrompt returns the system prompt of the run, prompts.SystemPrompt
+// unless WithSystemPrompt set another one
+func SystemPrompt(ctx context.Context) string {
+	if prompt, ok := ctx.Value(systemPromptKey{}).(string); ok && prompt != "" {
+		return prompt
+	}
+	return prompts.SystemPrompt
+}
@@ -0,0 +1,33 @@
+package manus
+
+import (
+	"context"
+	"testing"
+
+	"gogogajeto/agent/prompts"
+
+	"github.com/cloudwego/eino/components/tool"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSystemPrompt(t *testing.T) {
+	assert.Equal(t, prompts.SystemPrompt, SystemPrompt(context.Background()))
+	assert.Equal(t, prompts.SystemPrompt, SystemPrompt(WithSystemPrompt(context.Background(), "")))
+	assert.Equal(t, prompts.ReconPrompt, SystemPrompt(WithSystemPrompt(context.Background(), prompts.ReconPrompt)))
+}
+
+func TestAgent_StartsWithSystemPromptOfRun(t *testing.T) {
+	registerTestTypes(t)
+	runner := composeAgent(context.Background(), &scanningChatModel{}, []tool.BaseTool{&recordingTool{}}, NewInMemoryStore())
+
+	state, err := runRequest(WithSystemPrompt(context.Background(), "You review code."), runner, "hello")
+	require.NoError(t, err)
+	assert.Equal(t, "You review code.", state.History[0].Content)
+
+	// A conversation that has started keeps its prompt
+	state, err = runRequest(WithSystemPrompt(context.Background(), "You scan networks."), runner, "hello again")
+	require.NoError(t, err)
+	assert.Equal(t, "You review code.", state.History[0].Content)
+	assert.Len(t, state.History, 5)
+}
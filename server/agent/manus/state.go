@@ -0,0 +1,121 @@
+package manus
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"gogogajeto/agent/common"
+
+	"github.com/cloudwego/eino/compose"
+	"github.com/cloudwego/eino/schema"
+)
+
+// errStateLoaded aborts a run right after the checkpointed state was read
+var errStateLoaded = errors.New("state loaded")
+
+// LoadState returns the graph state checkpointed under checkPointID without
+// executing any node. The checkpoint is decoded by the runner itself, so the
+// serialization format stays an implementation detail of eino. ok is false if
+// the store holds no checkpoint for the ID.
+func LoadState(ctx context.Context, runner compose.Runnable[string, string], store compose.CheckPointStore, checkPointID string) (state *common.State, ok bool, err error) {
+	// Without a checkpoint the runner would start a fresh run, so check first
+	_, exists, err := store.Get(ctx, checkPointID)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to read checkpoint %s: %w", checkPointID, err)
+	}
+	if !exists {
+		return nil, false, nil
+	}
+
+	_, err = runner.Invoke(ctx, "",
+		compose.WithCheckPointID(checkPointID),
+		compose.WithStateModifier(func(ctx context.Context, path compose.NodePath, s any) error {
+			state = s.(*common.State)
+			return errStateLoaded
+		}),
+	)
+	if state != nil {
+		return state, true, nil
+	}
+	if err == nil {
+		err = errors.New("checkpoint holds no state")
+	}
+	return nil, false, fmt.Errorf("failed to load state of checkpoint %s: %w", checkPointID, err)
+}
+
+// ErrInvalidHistoryPoint is returned when a history position cannot be used
+// to cut the conversation
+var ErrInvalidHistoryPoint = errors.New("invalid history point")
+
+// RewriteState loads the checkpoint srcID, applies modify to its state and
+// stores the result under dstID, which may be the same ID. No model or tool is
+// run: the graph resumes at the Human node, which finds no pending user input
+// and interrupts again, so the rewritten checkpoint waits for the next message
+// like any other. A checkpoint at the approval gate stays there.
+func RewriteState(ctx context.Context, runner compose.Runnable[string, string], store compose.CheckPointStore, srcID, dstID string, modify func(state *common.State) error) error {
+	_, exists, err := store.Get(ctx, srcID)
+	if err != nil {
+		return fmt.Errorf("failed to read checkpoint %s: %w", srcID, err)
+	}
+	if !exists {
+		return fmt.Errorf("checkpoint %s not found", srcID)
+	}
+
+	// A checkpoint waiting for tool approval must keep waiting
+	ctx = WithToolApproval(ctx)
+
+	var modifyErr error
+	_, err = runner.Invoke(ctx, "",
+		compose.WithCheckPointID(srcID),
+		compose.WithWriteToCheckPointID(dstID),
+		compose.WithStateModifier(func(ctx context.Context, path compose.NodePath, s any) error {
+			state := s.(*common.State)
+			state.UserInput = ""
+			if modifyErr = modify(state); modifyErr != nil {
+				return modifyErr
+			}
+			// A summary of messages that were cut no longer applies
+			if state.Compacted > len(state.History) {
+				ResetCompaction(state)
+			}
+			return nil
+		}),
+	)
+	if modifyErr != nil {
+		return modifyErr
+	}
+	if _, ok := compose.ExtractInterruptInfo(err); ok {
+		return nil
+	}
+	if err == nil {
+		err = errors.New("graph finished without waiting for input")
+	}
+	return fmt.Errorf("failed to rewrite checkpoint %s: %w", srcID, err)
+}
+
+// TruncateHistory returns the history up to and including the message at
+// orderID. The cut must not separate tool calls from their results, because
+// the model API rejects unanswered tool calls.
+func TruncateHistory(history []*schema.Message, orderID int) ([]*schema.Message, error) {
+	if orderID < 0 || orderID >= len(history) {
+		return nil, fmt.Errorf("%w: message %d does not exist, history has %d messages", ErrInvalidHistoryPoint, orderID, len(history))
+	}
+
+	truncated := history[:orderID+1]
+
+	pending := make(map[string]bool)
+	for _, msg := range truncated {
+		for _, tc := range msg.ToolCalls {
+			pending[tc.ID] = true
+		}
+		if msg.Role == schema.Tool {
+			delete(pending, msg.ToolCallID)
+		}
+	}
+	if len(pending) > 0 {
+		return nil, fmt.Errorf("%w: message %d is in the middle of a tool call, pick a later message", ErrInvalidHistoryPoint, orderID)
+	}
+
+	return append([]*schema.Message{}, truncated...), nil
+}
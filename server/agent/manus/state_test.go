@@ -0,0 +1,258 @@
+package manus
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"gogogajeto/agent/common"
+
+	"github.com/cloudwego/eino/components/model"
+	"github.com/cloudwego/eino/compose"
+	"github.com/cloudwego/eino/schema"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var registerStateOnce sync.Once
+
+// fakeChatModel answers every request with a fixed assistant message and
+// counts how often it was called. When streaming, the reply is sent in chunks
+// if they are set.
+type fakeChatModel struct {
+	mu     sync.Mutex
+	calls  int
+	reply  string
+	chunks []string
+}
+
+func (f *fakeChatModel) Generate(ctx context.Context, input []*schema.Message, opts ...model.Option) (*schema.Message, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls++
+	return schema.AssistantMessage(f.reply, nil), nil
+}
+
+func (f *fakeChatModel) Stream(ctx context.Context, input []*schema.Message, opts ...model.Option) (*schema.StreamReader[*schema.Message], error) {
+	msg, err := f.Generate(ctx, input, opts...)
+	if err != nil {
+		return nil, err
+	}
+	if len(f.chunks) == 0 {
+		return schema.StreamReaderFromArray([]*schema.Message{msg}), nil
+	}
+	chunks := make([]*schema.Message, len(f.chunks))
+	for i, c := range f.chunks {
+		chunks[i] = schema.AssistantMessage(c, nil)
+	}
+	return schema.StreamReaderFromArray(chunks), nil
+}
+
+func (f *fakeChatModel) Calls() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.calls
+}
+
+// registerTestTypes registers the state types like the server does at startup
+func registerTestTypes(t *testing.T) {
+	registerStateOnce.Do(func() {
+		require.NoError(t, compose.RegisterSerializableType[common.State]("my state"))
+		require.NoError(t, compose.RegisterSerializableType[common.Task]("plan task"))
+		require.NoError(t, compose.RegisterSerializableType[common.Claim]("verified claim"))
+	})
+}
+
+func newTestAgent(t *testing.T) (compose.Runnable[string, string], *InMemoryStore, *fakeChatModel) {
+	registerTestTypes(t)
+	store := NewInMemoryStore()
+	cm := &fakeChatModel{reply: "hello from the agent"}
+	runner := composeAgent(context.Background(), cm, nil, store)
+	return runner, store, cm
+}
+
+// sendMessage drives one conversation turn the same way the server does
+func sendMessage(t *testing.T, runner compose.Runnable[string, string], checkPointID, input string) *common.State {
+	_, err := runner.Invoke(context.Background(), input,
+		compose.WithCheckPointID(checkPointID),
+		compose.WithStateModifier(func(ctx context.Context, path compose.NodePath, s any) error {
+			s.(*common.State).UserInput = input
+			return nil
+		}),
+	)
+	info, ok := compose.ExtractInterruptInfo(err)
+	require.True(t, ok, "expected interrupt before the human node, got %v", err)
+	return info.State.(*common.State)
+}
+
+func TestLoadState_ReturnsCheckpointedHistory(t *testing.T) {
+	ctx := context.Background()
+	runner, store, cm := newTestAgent(t)
+
+	sendMessage(t, runner, "session-1", "first question")
+	sendMessage(t, runner, "session-1", "second question")
+	callsBefore := cm.Calls()
+
+	state, ok, err := LoadState(ctx, runner, store, "session-1")
+	require.NoError(t, err)
+	require.True(t, ok)
+
+	roles := make([]schema.RoleType, len(state.History))
+	for i, msg := range state.History {
+		roles[i] = msg.Role
+	}
+	assert.Equal(t, []schema.RoleType{schema.System, schema.User, schema.Assistant, schema.User, schema.Assistant}, roles)
+	assert.Equal(t, "first question", state.History[1].Content)
+	assert.Equal(t, "second question", state.History[3].Content)
+
+	// Loading must not run the model
+	assert.Equal(t, callsBefore, cm.Calls())
+}
+
+func TestLoadState_MissingCheckpoint(t *testing.T) {
+	ctx := context.Background()
+	runner, store, cm := newTestAgent(t)
+
+	state, ok, err := LoadState(ctx, runner, store, "unknown")
+	require.NoError(t, err)
+	assert.False(t, ok)
+	assert.Nil(t, state)
+	assert.Equal(t, 0, cm.Calls())
+}
+
+func TestLoadState_DoesNotModifyCheckpoint(t *testing.T) {
+	ctx := context.Background()
+	runner, store, _ := newTestAgent(t)
+
+	sendMessage(t, runner, "session-1", "question")
+	before, _, _ := store.Get(ctx, "session-1")
+
+	_, _, err := LoadState(ctx, runner, store, "session-1")
+	require.NoError(t, err)
+
+	after, _, _ := store.Get(ctx, "session-1")
+	assert.Equal(t, before, after)
+}
+
+func TestRewriteState_ForksTruncatedHistory(t *testing.T) {
+	ctx := context.Background()
+	runner, store, cm := newTestAgent(t)
+
+	sendMessage(t, runner, "parent", "first question")
+	sendMessage(t, runner, "parent", "second question")
+	callsBefore := cm.Calls()
+
+	err := RewriteState(ctx, runner, store, "parent", "child", func(state *common.State) error {
+		history, err := TruncateHistory(state.History, 2)
+		state.History = history
+		return err
+	})
+	require.NoError(t, err)
+	assert.Equal(t, callsBefore, cm.Calls(), "rewriting must not run the model")
+
+	child, ok, err := LoadState(ctx, runner, store, "child")
+	require.NoError(t, err)
+	require.True(t, ok)
+	assert.Len(t, child.History, 3)
+	assert.Equal(t, "first question", child.History[1].Content)
+
+	parent, _, err := LoadState(ctx, runner, store, "parent")
+	require.NoError(t, err)
+	assert.Len(t, parent.History, 5)
+
+	// The fork continues from the cut
+	state := sendMessage(t, runner, "child", "another approach")
+	require.Len(t, state.History, 5)
+	assert.Equal(t, "another approach", state.History[3].Content)
+	assert.Equal(t, schema.Assistant, state.History[4].Role)
+}
+
+func TestRewriteState_InPlace(t *testing.T) {
+	ctx := context.Background()
+	runner, store, _ := newTestAgent(t)
+
+	sendMessage(t, runner, "session-1", "question")
+
+	err := RewriteState(ctx, runner, store, "session-1", "session-1", func(state *common.State) error {
+		state.Name = "renamed"
+		return nil
+	})
+	require.NoError(t, err)
+
+	state, _, err := LoadState(ctx, runner, store, "session-1")
+	require.NoError(t, err)
+	assert.Equal(t, "renamed", state.Name)
+	assert.Len(t, state.History, 3)
+}
+
+func TestRewriteState_ModifyErrorKeepsCheckpoint(t *testing.T) {
+	ctx := context.Background()
+	runner, store, _ := newTestAgent(t)
+
+	sendMessage(t, runner, "session-1", "question")
+	before, _, _ := store.Get(ctx, "session-1")
+
+	err := RewriteState(ctx, runner, store, "session-1", "session-1", func(state *common.State) error {
+		return ErrInvalidHistoryPoint
+	})
+	assert.ErrorIs(t, err, ErrInvalidHistoryPoint)
+
+	after, _, _ := store.Get(ctx, "session-1")
+	assert.Equal(t, before, after)
+}
+
+func TestRewriteState_MissingCheckpoint(t *testing.T) {
+	ctx := context.Background()
+	runner, store, cm := newTestAgent(t)
+
+	err := RewriteState(ctx, runner, store, "unknown", "child", func(state *common.State) error {
+		return nil
+	})
+	assert.Error(t, err)
+	assert.Equal(t, 0, cm.Calls())
+}
+
+func TestTruncateHistory(t *testing.T) {
+	history := []*schema.Message{
+		schema.SystemMessage("system"),
+		schema.UserMessage("scan example.com"),
+		schema.AssistantMessage("", []schema.ToolCall{
+			{ID: "call-1", Function: schema.FunctionCall{Name: "kali_info_gathering"}},
+			{ID: "call-2", Function: schema.FunctionCall{Name: "kali_info_gathering"}},
+		}),
+		schema.ToolMessage("nmap output", "call-1"),
+		schema.ToolMessage("whois output", "call-2"),
+		schema.AssistantMessage("port 80 is open", nil),
+	}
+
+	tests := []struct {
+		name    string
+		orderID int
+		length  int
+		wantErr bool
+	}{
+		{name: "system prompt only", orderID: 0, length: 1},
+		{name: "at user message", orderID: 1, length: 2},
+		{name: "unanswered tool calls", orderID: 2, wantErr: true},
+		{name: "partially answered tool calls", orderID: 3, wantErr: true},
+		{name: "after all tool results", orderID: 4, length: 5},
+		{name: "whole history", orderID: 5, length: 6},
+		{name: "negative", orderID: -1, wantErr: true},
+		{name: "out of range", orderID: 6, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			truncated, err := TruncateHistory(history, tt.orderID)
+			if tt.wantErr {
+				assert.ErrorIs(t, err, ErrInvalidHistoryPoint)
+				return
+			}
+			require.NoError(t, err)
+			assert.Len(t, truncated, tt.length)
+		})
+	}
+
+	// The original history is left untouched
+	assert.Len(t, history, 6)
+}
@@ -1,21 +1,56 @@
 package manus
 
-import "context"
+import (
+	"context"
+	"sync"
+
+	"github.com/cloudwego/eino/compose"
+)
+
+// Store is a checkpoint store whose checkpoints can also be removed, e.g. when
+// a session expires.
+type Store interface {
+	compose.CheckPointStore
+	Delete(ctx context.Context, checkPointID string) error
+}
 
 func NewInMemoryStore() *InMemoryStore {
 	return &InMemoryStore{m: make(map[string][]byte)}
 }
 
 type InMemoryStore struct {
-	m map[string][]byte
+	mu sync.RWMutex
+	m  map[string][]byte
 }
 
 func (i *InMemoryStore) Get(ctx context.Context, checkPointID string) ([]byte, bool, error) {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
 	data, ok := i.m[checkPointID]
 	return data, ok, nil
 }
 
 func (i *InMemoryStore) Set(ctx context.Context, checkPointID string, checkPoint []byte) error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
 	i.m[checkPointID] = checkPoint
 	return nil
 }
+
+func (i *InMemoryStore) Delete(ctx context.Context, checkPointID string) error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	delete(i.m, checkPointID)
+	return nil
+}
+
+// Keys returns the IDs of all stored checkpoints
+func (i *InMemoryStore) Keys(ctx context.Context) ([]string, error) {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+	keys := make([]string, 0, len(i.m))
+	for k := range i.m {
+		keys = append(keys, k)
+	}
+	return keys, nil
+}
@@ -245,3 +245,32 @@ func BenchmarkInMemoryStore_SetGet_Standalone(b *testing.B) {
 		store.Get(ctx, key)
 	}
 }
+
+func TestInMemoryStore_Delete_Standalone(t *testing.T) {
+	ctx := context.Background()
+	store := NewInMemoryStore()
+
+	store.Set(ctx, "checkpoint-1", []byte("data 1"))
+	store.Set(ctx, "checkpoint-2", []byte("data 2"))
+
+	err := store.Delete(ctx, "checkpoint-1")
+	if err != nil {
+		t.Errorf("Delete() returned error: %v", err)
+	}
+
+	_, ok, _ := store.Get(ctx, "checkpoint-1")
+	if ok {
+		t.Error("Get() returned ok=true for deleted checkpoint")
+	}
+
+	data, ok, _ := store.Get(ctx, "checkpoint-2")
+	if !ok || string(data) != "data 2" {
+		t.Error("Delete() removed the wrong checkpoint")
+	}
+
+	// Deleting a missing checkpoint is not an error
+	err = store.Delete(ctx, "non-existing-id")
+	if err != nil {
+		t.Errorf("Delete() returned error for non-existing key: %v", err)
+	}
+}
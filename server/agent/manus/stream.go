@@ -0,0 +1,88 @@
+package manus
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/cloudwego/eino/callbacks"
+	"github.com/cloudwego/eino/components/model"
+	"github.com/cloudwego/eino/compose"
+	"github.com/cloudwego/eino/schema"
+	callbackutils "github.com/cloudwego/eino/utils/callbacks"
+)
+
+// ChunkHandler receives the chat model output while it is generated. Every
+// model call of a run is a new step, numbered from 0; a tool loop produces
+// several steps per user message.
+type ChunkHandler func(step int, chunk *schema.Message)
+
+// StreamRun runs the graph like Invoke but in streaming mode, passing every
+// chunk of the chat model output to onChunk. Checkpointing is the same as for
+// Invoke: the interrupt before the Human node is returned as the error. All
+// chunks have been delivered when StreamRun returns.
+func StreamRun(ctx context.Context, runner compose.Runnable[string, string], input string, onChunk ChunkHandler, opts ...compose.Option) (string, error) {
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	step := 0
+	previous := make(chan struct{})
+	close(previous)
+
+	handler := callbackutils.NewHandlerHelper().ChatModel(&callbackutils.ModelCallbackHandler{
+		OnEndWithStreamOutput: func(ctx context.Context, info *callbacks.RunInfo, output *schema.StreamReader[*model.CallbackOutput]) context.Context {
+			mu.Lock()
+			current, wait, done := step, previous, make(chan struct{})
+			step++
+			previous = done
+			mu.Unlock()
+
+			// The stream is a copy of the node output and must be drained
+			// without blocking the graph. Steps are delivered in order.
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				defer close(done)
+				defer output.Close()
+				<-wait
+				for {
+					out, err := output.Recv()
+					if err != nil {
+						return
+					}
+					if out != nil && out.Message != nil {
+						onChunk(current, out.Message)
+					}
+				}
+			}()
+			return ctx
+		},
+	}).Handler()
+
+	opts = append(opts, compose.WithCallbacks(handler).DesignateNode(NodeKeyChatModel))
+	sr, err := runner.Stream(ctx, input, opts...)
+	if err == nil {
+		var result string
+		result, err = concatStream(sr)
+		wg.Wait()
+		return result, err
+	}
+	wg.Wait()
+	return "", err
+}
+
+func concatStream(sr *schema.StreamReader[string]) (string, error) {
+	defer sr.Close()
+	var sb strings.Builder
+	for {
+		chunk, err := sr.Recv()
+		if errors.Is(err, io.EOF) {
+			return sb.String(), nil
+		}
+		if err != nil {
+			return sb.String(), err
+		}
+		sb.WriteString(chunk)
+	}
+}
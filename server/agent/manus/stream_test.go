@@ -0,0 +1,53 @@
+package manus
+
+import (
+	"context"
+	"testing"
+
+	"gogogajeto/agent/common"
+
+	"github.com/cloudwego/eino/compose"
+	"github.com/cloudwego/eino/schema"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStreamRun_ForwardsChunksAndCheckpoints(t *testing.T) {
+	ctx := context.Background()
+	runner, store, cm := newTestAgent(t)
+	cm.chunks = []string{"port ", "80 ", "is open"}
+
+	var steps []int
+	var chunks []string
+	_, err := StreamRun(ctx, runner, "scan it", func(step int, chunk *schema.Message) {
+		steps = append(steps, step)
+		chunks = append(chunks, chunk.Content)
+	},
+		compose.WithCheckPointID("session-1"),
+		compose.WithStateModifier(func(ctx context.Context, path compose.NodePath, s any) error {
+			s.(*common.State).UserInput = "scan it"
+			return nil
+		}),
+	)
+
+	// Streaming stops at the same interrupt as Invoke
+	info, ok := compose.ExtractInterruptInfo(err)
+	require.True(t, ok, "expected interrupt before the human node, got %v", err)
+	assert.Equal(t, []string{"port ", "80 ", "is open"}, chunks)
+	assert.Equal(t, []int{0, 0, 0}, steps)
+
+	// The checkpoint holds the complete message
+	state := info.State.(*common.State)
+	require.Len(t, state.History, 3)
+	assert.Equal(t, "port 80 is open", state.History[2].Content)
+
+	loaded, _, err := LoadState(ctx, runner, store, "session-1")
+	require.NoError(t, err)
+	assert.Equal(t, "port 80 is open", loaded.History[2].Content)
+
+	// The conversation continues normally afterwards
+	state = sendMessage(t, runner, "session-1", "and 443?")
+	require.Len(t, state.History, 5)
+	assert.Equal(t, "and 443?", state.History[3].Content)
+	assert.Equal(t, schema.Assistant, state.History[4].Role)
+}
@@ -0,0 +1,184 @@
+package manus
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"gogogajeto/agent/common"
+	"gogogajeto/agent/prompts"
+	"gogogajeto/util"
+
+	"github.com/cloudwego/eino/components/model"
+	"github.com/cloudwego/eino/compose"
+	"github.com/cloudwego/eino/schema"
+)
+
+const NodeKeyVerifier = "Verifier"
+
+// UnverifiedLabel marks sentences of an answer no tool output supports
+const UnverifiedLabel = "[Unverified]"
+
+var errInvalidClaims = errors.New("invalid claims")
+
+type verificationKey struct{}
+
+// WithVerification has the final answer of every request checked against the
+// tool outputs in the history. Sentences with unsupported claims are labelled
+// with UnverifiedLabel and the claims are kept in common.State.Claims.
+func WithVerification(ctx context.Context) context.Context {
+	return context.WithValue(ctx, verificationKey{}, true)
+}
+
+func verificationEnabled(ctx context.Context) bool {
+	enabled, _ := ctx.Value(verificationKey{}).(bool)
+	return enabled
+}
+
+// Verifier returns the graph node that checks the final answer when the run
+// has WithVerification, shared with the ReAct agent. Without it, or if the
+// model's verdict cannot be read, the answer passes unchanged.
+func Verifier(cm model.BaseChatModel) func(ctx context.Context, answer *schema.Message) (*schema.Message, error) {
+	return func(ctx context.Context, answer *schema.Message) (*schema.Message, error) {
+		if !verificationEnabled(ctx) || Cancelled(ctx) || answer == nil || strings.TrimSpace(answer.Content) == "" {
+			return answer, nil
+		}
+		util.LogMessage("=== Verifier Node START ===")
+
+		var history []*schema.Message
+		err := compose.ProcessState[*common.State](ctx, func(ctx context.Context, state *common.State) error {
+			history = ModelHistory(state)
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		claims, err := checkClaims(ctx, cm, history, answer.Content)
+		if errors.Is(err, errInvalidClaims) {
+			util.LogMessage("Verifier returned no usable claims: " + err.Error())
+			return answer, nil
+		} else if err != nil {
+			return nil, err
+		}
+
+		verified := *answer
+		verified.Content = annotateUnverified(answer.Content, claims)
+		err = compose.ProcessState[*common.State](ctx, func(ctx context.Context, state *common.State) error {
+			state.Claims = claims
+			// The answer is the last message, the history keeps the annotated one
+			if last := len(state.History) - 1; last >= 0 && state.History[last].Role == schema.Assistant {
+				state.History[last] = &verified
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		util.LogMessage(fmt.Sprintf("=== Verifier Node END, %d claims, %d unsupported ===", len(claims), countUnsupported(claims)))
+		return &verified, nil
+	}
+}
+
+// checkClaims asks the model without tools which claims of answer the tool
+// outputs in history support
+func checkClaims(ctx context.Context, cm model.BaseChatModel, history []*schema.Message, answer string) ([]common.Claim, error) {
+	var evidence strings.Builder
+	for _, msg := range history {
+		switch {
+		case msg.Role == schema.Tool:
+			fmt.Fprintf(&evidence, "--- Output of %s (%s) ---\n%s\n", msg.Name, msg.ToolCallID, msg.Content)
+		case msg.Role == schema.System && strings.HasPrefix(msg.Content, summaryPrefix):
+			// Earlier tool outputs only survive in the summary
+			fmt.Fprintf(&evidence, "--- %s ---\n", msg.Content)
+		}
+	}
+	if evidence.Len() == 0 {
+		evidence.WriteString("No tool was run.\n")
+	}
+
+	messages := []*schema.Message{
+		schema.SystemMessage(prompts.VerifierPrompt),
+		schema.UserMessage("Tool outputs:\n" + evidence.String() + "\nAnswer to check:\n" + answer),
+	}
+	out, err := cm.Generate(ctx, messages, model.WithToolChoice(schema.ToolChoiceForbidden))
+	if err != nil {
+		return nil, err
+	}
+	return parseClaims(out.Content)
+}
+
+// parseClaims reads the {"claims": [...]} object from a model answer, which
+// may be wrapped in a code fence or text
+func parseClaims(content string) ([]common.Claim, error) {
+	start := strings.Index(content, "{")
+	end := strings.LastIndex(content, "}")
+	if start < 0 || end < start {
+		return nil, fmt.Errorf("%w: no JSON object in answer", errInvalidClaims)
+	}
+
+	var verdict struct {
+		Claims *[]common.Claim `json:"claims"`
+	}
+	if err := json.Unmarshal([]byte(content[start:end+1]), &verdict); err != nil {
+		return nil, fmt.Errorf("%w: %v", errInvalidClaims, err)
+	}
+	if verdict.Claims == nil {
+		return nil, fmt.Errorf("%w: no claims field", errInvalidClaims)
+	}
+
+	claims := make([]common.Claim, 0, len(*verdict.Claims))
+	for _, claim := range *verdict.Claims {
+		claim.Claim = strings.TrimSpace(claim.Claim)
+		if claim.Claim == "" {
+			continue
+		}
+		claim.Sentence = strings.TrimSpace(claim.Sentence)
+		claims = append(claims, claim)
+	}
+	return claims, nil
+}
+
+// annotateUnverified labels the sentences making unsupported claims. Claims
+// whose sentence cannot be found are listed at the end.
+func annotateUnverified(content string, claims []common.Claim) string {
+	var unplaced []string
+	for _, claim := range claims {
+		if claim.Supported {
+			continue
+		}
+		i := -1
+		if claim.Sentence != "" {
+			i = strings.Index(content, claim.Sentence)
+		}
+		if i < 0 {
+			unplaced = append(unplaced, claim.Claim)
+			continue
+		}
+		// Sentences the answer labelled itself keep their label
+		if strings.HasPrefix(claim.Sentence, UnverifiedLabel) || strings.HasSuffix(strings.TrimRight(content[:i], " "), UnverifiedLabel) {
+			continue
+		}
+		content = content[:i] + UnverifiedLabel + " " + content[i:]
+	}
+
+	if len(unplaced) > 0 {
+		content += "\n\nNot supported by the tool outputs:"
+		for _, claim := range unplaced {
+			content += "\n- " + UnverifiedLabel + " " + claim
+		}
+	}
+	return content
+}
+
+func countUnsupported(claims []common.Claim) int {
+	var n int
+	for _, claim := range claims {
+		if !claim.Supported {
+			n++
+		}
+	}
+	return n
+}
@@ -0,0 +1,151 @@
+package manus
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"gogogajeto/agent/common"
+	"gogogajeto/agent/prompts"
+
+	"github.com/cloudwego/eino/components/model"
+	"github.com/cloudwego/eino/components/tool"
+	"github.com/cloudwego/eino/compose"
+	"github.com/cloudwego/eino/schema"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const verifiedAnswer = "Port 22 is open on 10.0.0.5. The SSH server is vulnerable to CVE-2016-6210."
+
+// claimingChatModel scans once, then answers with verifiedAnswer, which claims
+// more than the scan showed. Asked to verify it returns verdict.
+type claimingChatModel struct {
+	verdict  string
+	verified []*schema.Message // Input of the verifier call
+}
+
+func (c *claimingChatModel) Generate(ctx context.Context, input []*schema.Message, opts ...model.Option) (*schema.Message, error) {
+	if input[0].Content == prompts.VerifierPrompt {
+		options := model.GetCommonOptions(nil, opts...)
+		if options.ToolChoice == nil || *options.ToolChoice != schema.ToolChoiceForbidden {
+			return nil, assert.AnError
+		}
+		c.verified = input
+		return schema.AssistantMessage(c.verdict, nil), nil
+	}
+	if input[len(input)-1].Role == schema.Tool {
+		return schema.AssistantMessage(verifiedAnswer, nil), nil
+	}
+	return schema.AssistantMessage("", []schema.ToolCall{{
+		ID:       "call-1",
+		Function: schema.FunctionCall{Name: "scan", Arguments: `{"target":"10.0.0.5"}`},
+	}}), nil
+}
+
+func (c *claimingChatModel) Stream(ctx context.Context, input []*schema.Message, opts ...model.Option) (*schema.StreamReader[*schema.Message], error) {
+	msg, err := c.Generate(ctx, input, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return schema.StreamReaderFromArray([]*schema.Message{msg}), nil
+}
+
+const claimsVerdict = "```json\n" + `{"claims": [
+	{"claim": "Port 22 is open on 10.0.0.5", "supported": true, "evidence": "scanned", "sentence": "Port 22 is open on 10.0.0.5."},
+	{"claim": "The SSH server is vulnerable to CVE-2016-6210", "supported": false, "sentence": "The SSH server is vulnerable to CVE-2016-6210."}
+]}` + "\n```"
+
+func runRequest(ctx context.Context, runner compose.Runnable[string, string], input string) (*common.State, error) {
+	_, err := runner.Invoke(ctx, input,
+		compose.WithCheckPointID("session-1"),
+		compose.WithStateModifier(func(ctx context.Context, path compose.NodePath, s any) error {
+			s.(*common.State).UserInput = input
+			return nil
+		}),
+	)
+	info, ok := compose.ExtractInterruptInfo(err)
+	if !ok {
+		return nil, err
+	}
+	return info.State.(*common.State), nil
+}
+
+func TestVerifier_LabelsUnsupportedClaims(t *testing.T) {
+	registerTestTypes(t)
+	cm := &claimingChatModel{verdict: claimsVerdict}
+	runner := composeAgent(context.Background(), cm, []tool.BaseTool{&recordingTool{}}, NewInMemoryStore())
+
+	state, err := runRequest(WithVerification(context.Background()), runner, "scan 10.0.0.5")
+	require.NoError(t, err)
+
+	answer := state.History[len(state.History)-1]
+	assert.Equal(t, "Port 22 is open on 10.0.0.5. [Unverified] The SSH server is vulnerable to CVE-2016-6210.", answer.Content)
+	require.Len(t, state.Claims, 2)
+	assert.True(t, state.Claims[0].Supported)
+	assert.False(t, state.Claims[1].Supported)
+
+	// The verifier saw the tool output and the answer
+	require.Len(t, cm.verified, 2)
+	assert.Contains(t, cm.verified[1].Content, `scanned {"target":"10.0.0.5"}`)
+	assert.Contains(t, cm.verified[1].Content, verifiedAnswer)
+}
+
+func TestVerifier_Disabled(t *testing.T) {
+	registerTestTypes(t)
+	cm := &claimingChatModel{verdict: claimsVerdict}
+	runner := composeAgent(context.Background(), cm, []tool.BaseTool{&recordingTool{}}, NewInMemoryStore())
+
+	state, err := runRequest(context.Background(), runner, "scan 10.0.0.5")
+	require.NoError(t, err)
+
+	assert.Equal(t, verifiedAnswer, state.History[len(state.History)-1].Content)
+	assert.Empty(t, state.Claims)
+	assert.Nil(t, cm.verified)
+}
+
+func TestVerifier_KeepsAnswerOnInvalidVerdict(t *testing.T) {
+	registerTestTypes(t)
+	cm := &claimingChatModel{verdict: "I cannot tell."}
+	runner := composeAgent(context.Background(), cm, []tool.BaseTool{&recordingTool{}}, NewInMemoryStore())
+
+	state, err := runRequest(WithVerification(context.Background()), runner, "scan 10.0.0.5")
+	require.NoError(t, err)
+
+	assert.NotNil(t, cm.verified)
+	assert.Equal(t, verifiedAnswer, state.History[len(state.History)-1].Content)
+	assert.Empty(t, state.Claims)
+}
+
+func TestParseClaims(t *testing.T) {
+	claims, err := parseClaims(claimsVerdict)
+	require.NoError(t, err)
+	require.Len(t, claims, 2)
+	assert.Equal(t, "Port 22 is open on 10.0.0.5", claims[0].Claim)
+	assert.Equal(t, "scanned", claims[0].Evidence)
+
+	claims, err = parseClaims(`{"claims": [{"claim": " ", "supported": false}]}`)
+	require.NoError(t, err)
+	assert.Empty(t, claims)
+
+	for _, content := range []string{"no claims", `{"tasks": []}`, `{"claims": [}`} {
+		_, err := parseClaims(content)
+		assert.ErrorIs(t, err, errInvalidClaims, content)
+	}
+}
+
+func TestAnnotateUnverified(t *testing.T) {
+	content := "Host is up. [Unverified] Runs Debian. Admin panel at /admin."
+	claims := []common.Claim{
+		{Claim: "Host is up", Supported: true, Sentence: "Host is up."},
+		{Claim: "Runs Debian", Supported: false, Sentence: "Runs Debian."},
+		{Claim: "Admin panel at /admin", Supported: false, Sentence: "Admin panel at /admin."},
+		{Claim: "Default credentials work", Supported: false, Sentence: "Not in the answer."},
+	}
+
+	annotated := annotateUnverified(content, claims)
+	lines := strings.Split(annotated, "\n")
+	assert.Equal(t, "Host is up. [Unverified] Runs Debian. [Unverified] Admin panel at /admin.", lines[0])
+	assert.Equal(t, "- [Unverified] Default credentials work", lines[len(lines)-1])
+	assert.Equal(t, content, annotateUnverified(content, claims[:1]))
+}
@@ -1,42 +1,322 @@
-package model
+package react
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+
+	"gogogajeto/agent/common"
+	"gogogajeto/agent/manus"
+	"gogogajeto/agent/tools"
 	"gogogajeto/util"
 
+	"github.com/cloudwego/eino/callbacks"
 	"github.com/cloudwego/eino/components/model"
 	"github.com/cloudwego/eino/components/tool"
 	"github.com/cloudwego/eino/compose"
+	"github.com/cloudwego/eino/flow/agent"
+	einoreact "github.com/cloudwego/eino/flow/agent/react"
 	"github.com/cloudwego/eino/schema"
+	callbackutils "github.com/cloudwego/eino/utils/callbacks"
 )
 
-type State struct {
-	History   []*schema.Message
-	UserInput string
-	Name      string
-}
-
 const (
 	NodeKeyHuman         = "Human"
 	NodeKeyInputConvert  = "InputConverter"
-	NodeKeyChatModel     = "ChatModel"
-	NodeKeyToolsNode     = "ToolsNode"
+	NodeKeyChatModel     = "ChatModel" // Runs the whole ReAct loop for one request
+	NodeKeyToolsNode     = "ToolsNode" // Tools node inside the ReAct loop
 	NodeKeyOutputConvert = "OutputConverter"
+	NodeKeyVerifier      = "Verifier"
 )
 
-func CreateAgent() compose.Runnable[string, string] {
-	util.LogMessage("=== AGENT CREATION START ===")
+// loopSteps is the runtime step limit of the ReAct loop that allows budget
+// chat model calls: every call but the last is followed by the tools node
+func loopSteps(budget int) int {
+	return 2*budget - 1
+}
+
+// CreateAgent creates an agent running eino's ReAct loop with the given tools,
+// usually the Python and Kali tools from tools.NewAgentTools, on the named
+// chat model, empty for OPENAI_MODEL. Like the manus agent it keeps the
+// conversation in common.State and checkpoints it into store after every
+// turn, so both agents serve sessions the same way.
+func CreateAgent(store compose.CheckPointStore, allTools []tool.BaseTool, modelName string) compose.Runnable[string, string] {
+	util.LogMessage("=== REACT AGENT CREATION START ===")
 	ctx := context.Background()
 
-	agent := composeAgent(ctx, cm, toolsList)
+	util.LogMessage("Creating chat model...")
+	cm := tools.NewChatModelFor(ctx, modelName)
+
+	util.LogMessage("Composing ReAct agent...")
+	agent := composeAgent(ctx, cm, allTools, store)
 
-	util.LogMessage("=== AGENT CREATION COMPLETE ===")
+	util.LogMessage("=== REACT AGENT CREATION COMPLETE ===")
 	return agent
 }
 
 func composeAgent(ctx context.Context,
-	cm model.BaseChatModel,
+	cm model.ToolCallingChatModel,
 	tools []tool.BaseTool,
+	store compose.CheckPointStore,
 ) compose.Runnable[string, string] {
+	// A cancelled run stops model and tools but still ends at the Human node
+	cm = manus.NewCancellableModel(cm)
+	tools = manus.CancellableTools(tools)
+
+	loop, err := einoreact.NewAgent(ctx, &einoreact.AgentConfig{
+		ToolCallingModel: cm,
+		ToolsConfig:      compose.ToolsNodeConfig{Tools: tools, ExecuteSequentially: false},
+		MaxStep:          loopSteps(manus.DefaultStepBudget),
+		ModelNodeName:    NodeKeyChatModel,
+		ToolsNodeName:    NodeKeyToolsNode,
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// The summaries of an exhausted budget and of compacted history need the
+	// tools bound, otherwise the model cannot be told to leave them alone
+	infos := make([]*schema.ToolInfo, 0, len(tools))
+	for _, t := range tools {
+		info, err := t.Info(ctx)
+		if err != nil {
+			log.Fatal(err)
+		}
+		infos = append(infos, info)
+	}
+	summaryModel, err := cm.WithTools(infos)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	g := compose.NewGraph[string, string](compose.WithGenLocalState(func(ctx context.Context) *common.State {
+		return &common.State{History: []*schema.Message{}}
+	}))
+
+	err = g.AddLambdaNode(NodeKeyInputConvert, compose.InvokableLambda(func(ctx context.Context, input string) ([]*schema.Message, error) {
+		return []*schema.Message{schema.UserMessage(input)}, nil
+	}))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	err = g.AddLambdaNode(NodeKeyChatModel, compose.InvokableLambda(runLoop(loop, summaryModel)),
+		compose.WithStatePreHandler(func(ctx context.Context, in []*schema.Message, state *common.State) ([]*schema.Message, error) {
+			if len(state.History) == 0 {
+				state.History = append(state.History, schema.SystemMessage(manus.SystemPrompt(ctx)))
+			}
+			state.History = append(state.History, in...)
+			// Older messages are summarized before the loop starts
+			if err := manus.CompactHistory(ctx, summaryModel, state); err != nil {
+				return nil, err
+			}
+			return manus.ModelHistory(state), nil
+		}))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Checks the final answer against the tool outputs, see manus.WithVerification
+	err = g.AddLambdaNode(NodeKeyVerifier, compose.InvokableLambda(manus.Verifier(summaryModel)))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	err = g.AddLambdaNode(NodeKeyHuman, compose.InvokableLambda(func(ctx context.Context, input *schema.Message) ([]*schema.Message, error) {
+		var userInput string
+		err := compose.ProcessState[*common.State](ctx, func(ctx context.Context, state *common.State) error {
+			userInput = state.UserInput
+			if userInput != "" {
+				// A new request starts with a fresh step budget
+				state.Steps = 0
+				state.BudgetExhausted = false
+				state.Claims = nil
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		// Without a pending user message keep waiting for the next one
+		if userInput == "" {
+			return nil, compose.InterruptAndRerun
+		}
+		return []*schema.Message{schema.UserMessage(userInput)}, nil
+	}))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	err = g.AddLambdaNode(NodeKeyOutputConvert, compose.InvokableLambda(func(ctx context.Context, input []*schema.Message) (string, error) {
+		if len(input) == 0 {
+			return "", nil
+		}
+		return input[len(input)-1].Content, nil
+	}))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	err = g.AddEdge(compose.START, NodeKeyInputConvert)
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = g.AddEdge(NodeKeyInputConvert, NodeKeyChatModel)
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = g.AddEdge(NodeKeyChatModel, NodeKeyVerifier)
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = g.AddEdge(NodeKeyVerifier, NodeKeyHuman)
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = g.AddBranch(NodeKeyHuman, compose.NewGraphBranch(func(ctx context.Context, in []*schema.Message) (string, error) {
+		if len(in) > 0 && in[len(in)-1].Role == schema.User {
+			return NodeKeyChatModel, nil
+		}
+		return NodeKeyOutputConvert, nil
+	}, map[string]bool{
+		NodeKeyChatModel:     true,
+		NodeKeyOutputConvert: true,
+	}))
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = g.AddEdge(NodeKeyOutputConvert, compose.END)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	runner, err := g.Compile(ctx, compose.WithCheckPointStore(store), compose.WithInterruptBeforeNodes([]string{NodeKeyHuman}))
+	if err != nil {
+		log.Fatal(err)
+	}
+	return runner
+}
+
+// runLoop runs the ReAct loop over the history. Every message the loop
+// produces, tool calls and tool results included, is added to the state so
+// the transcript looks like the one of the manus agent.
+func runLoop(loop *einoreact.Agent, summaryModel model.BaseChatModel) func(ctx context.Context, history []*schema.Message) (*schema.Message, error) {
+	return func(ctx context.Context, history []*schema.Message) (*schema.Message, error) {
+		budget := manus.StepBudget(ctx)
+		collector := &messageCollector{}
+		opts := []agent.AgentOption{agent.WithComposeOptions(compose.WithCallbacks(collector.handler()))}
+		if budget > 0 {
+			opts = append(opts, agent.WithComposeOptions(compose.WithRuntimeMaxSteps(loopSteps(budget))))
+		}
+
+		util.LogMessage(fmt.Sprintf("=== ReAct loop START, %d messages ===", len(history)))
+		out, err := loop.Generate(ctx, history, opts...)
+		produced := collector.messages()
+		util.LogMessage(fmt.Sprintf("=== ReAct loop END, %d new messages ===", len(produced)))
+
+		exhausted := errors.Is(err, compose.ErrExceedMaxSteps)
+		if err != nil && !exhausted {
+			return nil, err
+		}
+
+		var steps int
+		for _, msg := range produced {
+			if msg.Role == schema.Assistant {
+				steps++
+			}
+		}
+
+		if exhausted {
+			pending := &schema.Message{}
+			if len(produced) > 0 {
+				pending = produced[len(produced)-1]
+			}
+			notRun, summary, err := manus.SummarizeBudget(ctx, summaryModel, append(history, produced...), pending, budget)
+			if err != nil {
+				return nil, err
+			}
+			produced = append(append(produced, notRun...), summary)
+			out = summary
+		}
+		// The cancelled answer does not pass the callbacks of a model that
+		// runs its own
+		if manus.Cancelled(ctx) && (len(produced) == 0 || produced[len(produced)-1] != out) {
+			produced = append(produced, out)
+			steps++
+		}
+
+		err = compose.ProcessState[*common.State](ctx, func(ctx context.Context, state *common.State) error {
+			state.History = append(state.History, produced...)
+			state.Steps += steps
+			state.BudgetExhausted = exhausted
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		return out, nil
+	}
+}
+
+// messageCollector records the chat model replies and tool results of one run
+// of the ReAct loop. Tool results finish in any order when the calls run in
+// parallel, they are returned in the order of the calls.
+type messageCollector struct {
+	mu   sync.Mutex
+	msgs []*schema.Message
+}
+
+func (c *messageCollector) add(msg *schema.Message) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.msgs = append(c.msgs, msg)
+}
+
+func (c *messageCollector) messages() []*schema.Message {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	msgs := append([]*schema.Message{}, c.msgs...)
+
+	// Sort the results following each reply by the position of their call
+	for i, msg := range msgs {
+		if len(msg.ToolCalls) < 2 {
+			continue
+		}
+		order := make(map[string]int, len(msg.ToolCalls))
+		for j, tc := range msg.ToolCalls {
+			order[tc.ID] = j
+		}
+		end := i + 1
+		for end < len(msgs) && msgs[end].Role == schema.Tool {
+			end++
+		}
+		sort.SliceStable(msgs[i+1:end], func(a, b int) bool {
+			return order[msgs[i+1+a].ToolCallID] < order[msgs[i+1+b].ToolCallID]
+		})
+	}
+	return msgs
+}
 
+func (c *messageCollector) handler() callbacks.Handler {
+	return callbackutils.NewHandlerHelper().
+		ChatModel(&callbackutils.ModelCallbackHandler{
+			OnEnd: func(ctx context.Context, info *callbacks.RunInfo, output *model.CallbackOutput) context.Context {
+				c.add(output.Message)
+				return ctx
+			},
+		}).
+		Tool(&callbackutils.ToolCallbackHandler{
+			OnEnd: func(ctx context.Context, info *callbacks.RunInfo, output *tool.CallbackOutput) context.Context {
+				msg := schema.ToolMessage(output.Response, compose.GetToolCallID(ctx))
+				if info != nil {
+					msg.Name = info.Name
+				}
+				c.add(msg)
+				return ctx
+			},
+		}).
+		Handler()
 }
@@ -0,0 +1,202 @@
+package react
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"gogogajeto/agent/common"
+	"gogogajeto/agent/manus"
+
+	"github.com/cloudwego/eino/components/model"
+	"github.com/cloudwego/eino/components/tool"
+	"github.com/cloudwego/eino/compose"
+	"github.com/cloudwego/eino/schema"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func init() {
+	if err := compose.RegisterSerializableType[common.State]("my state"); err != nil {
+		panic(err)
+	}
+	if err := compose.RegisterSerializableType[common.Task]("plan task"); err != nil {
+		panic(err)
+	}
+	if err := compose.RegisterSerializableType[common.Claim]("verified claim"); err != nil {
+		panic(err)
+	}
+}
+
+// scanningChatModel asks for a scan until it has seen rounds tool results,
+// then it answers with text. With tools forbidden it summarizes.
+type scanningChatModel struct {
+	mu     sync.Mutex
+	rounds int
+	calls  int
+}
+
+func (s *scanningChatModel) Generate(ctx context.Context, input []*schema.Message, opts ...model.Option) (*schema.Message, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.calls++
+
+	options := model.GetCommonOptions(nil, opts...)
+	if options.ToolChoice != nil && *options.ToolChoice == schema.ToolChoiceForbidden {
+		return schema.AssistantMessage("summary", nil), nil
+	}
+
+	var results int
+	for _, msg := range input {
+		if msg.Role == schema.Tool {
+			results++
+		}
+	}
+	if results >= s.rounds {
+		return schema.AssistantMessage(fmt.Sprintf("done after %d scans", results), nil), nil
+	}
+	return schema.AssistantMessage("", []schema.ToolCall{{
+		ID:       fmt.Sprintf("call-%d", s.calls),
+		Function: schema.FunctionCall{Name: "scan", Arguments: `{"target":"10.0.0.5"}`},
+	}}), nil
+}
+
+func (s *scanningChatModel) Stream(ctx context.Context, input []*schema.Message, opts ...model.Option) (*schema.StreamReader[*schema.Message], error) {
+	msg, err := s.Generate(ctx, input, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return schema.StreamReaderFromArray([]*schema.Message{msg}), nil
+}
+
+func (s *scanningChatModel) WithTools(tools []*schema.ToolInfo) (model.ToolCallingChatModel, error) {
+	return s, nil
+}
+
+type scanTool struct{}
+
+func (t *scanTool) Info(ctx context.Context) (*schema.ToolInfo, error) {
+	return &schema.ToolInfo{Name: "scan", Desc: "scan a target"}, nil
+}
+
+func (t *scanTool) InvokableRun(ctx context.Context, arguments string, opts ...tool.Option) (string, error) {
+	return "22/tcp open", nil
+}
+
+func run(ctx context.Context, runner compose.Runnable[string, string], checkPointID, input string) (*common.State, error) {
+	_, err := runner.Invoke(ctx, input,
+		compose.WithCheckPointID(checkPointID),
+		compose.WithStateModifier(func(ctx context.Context, path compose.NodePath, s any) error {
+			s.(*common.State).UserInput = input
+			return nil
+		}),
+	)
+	info, ok := compose.ExtractInterruptInfo(err)
+	if !ok {
+		return nil, err
+	}
+	return info.State.(*common.State), nil
+}
+
+func TestReActAgent_KeepsTranscript(t *testing.T) {
+	store := manus.NewInMemoryStore()
+	runner := composeAgent(context.Background(), &scanningChatModel{rounds: 2}, []tool.BaseTool{&scanTool{}}, store)
+
+	state, err := run(context.Background(), runner, "session-1", "scan 10.0.0.5")
+	require.NoError(t, err)
+
+	roles := make([]schema.RoleType, len(state.History))
+	for i, msg := range state.History {
+		roles[i] = msg.Role
+	}
+	assert.Equal(t, []schema.RoleType{
+		schema.System, schema.User,
+		schema.Assistant, schema.Tool,
+		schema.Assistant, schema.Tool,
+		schema.Assistant,
+	}, roles)
+	assert.Equal(t, state.History[2].ToolCalls[0].ID, state.History[3].ToolCallID)
+	assert.Equal(t, "scan", state.History[3].Name)
+	assert.Equal(t, "done after 2 scans", state.History[6].Content)
+	assert.Equal(t, 3, state.Steps)
+
+	// The checkpoint continues like a manus session
+	state, err = run(context.Background(), runner, "session-1", "and again")
+	require.NoError(t, err)
+	assert.Equal(t, "and again", state.History[7].Content)
+	assert.Equal(t, "done after 2 scans", state.History[8].Content)
+
+	loaded, ok, err := manus.LoadState(context.Background(), runner, store, "session-1")
+	require.NoError(t, err)
+	require.True(t, ok)
+	assert.Len(t, loaded.History, 9)
+}
+
+func TestReActAgent_StepBudget(t *testing.T) {
+	cm := &scanningChatModel{rounds: 10}
+	runner := composeAgent(context.Background(), cm, []tool.BaseTool{&scanTool{}}, manus.NewInMemoryStore())
+
+	state, err := run(manus.WithStepBudget(context.Background(), 3), runner, "session-1", "scan everything")
+	require.NoError(t, err)
+
+	assert.True(t, state.BudgetExhausted)
+	assert.Equal(t, 3, state.Steps)
+	assert.Equal(t, 4, cm.calls, "three budgeted calls and the summary")
+
+	last := state.History[len(state.History)-1]
+	assert.Equal(t, "summary", last.Content)
+	notRun := state.History[len(state.History)-2]
+	assert.Equal(t, schema.Tool, notRun.Role)
+	assert.Equal(t, "call-3", notRun.ToolCallID)
+}
+
+// fanOutChatModel asks for several lookups in one turn, then answers
+type fanOutChatModel struct {
+	scanningChatModel
+	targets []string
+}
+
+func (f *fanOutChatModel) Generate(ctx context.Context, input []*schema.Message, opts ...model.Option) (*schema.Message, error) {
+	if input[len(input)-1].Role == schema.Tool {
+		return schema.AssistantMessage("all done", nil), nil
+	}
+	calls := make([]schema.ToolCall, len(f.targets))
+	for i, target := range f.targets {
+		calls[i] = schema.ToolCall{
+			ID:       "call-" + target,
+			Function: schema.FunctionCall{Name: "scan", Arguments: target},
+		}
+	}
+	return schema.AssistantMessage("", calls), nil
+}
+
+func (f *fanOutChatModel) WithTools(tools []*schema.ToolInfo) (model.ToolCallingChatModel, error) {
+	return f, nil
+}
+
+// slowFirstTool takes longest for the first target
+type slowFirstTool struct{ scanTool }
+
+func (s *slowFirstTool) InvokableRun(ctx context.Context, arguments string, opts ...tool.Option) (string, error) {
+	if arguments == "a" {
+		time.Sleep(50 * time.Millisecond)
+	}
+	return "scanned " + arguments, nil
+}
+
+func TestReActAgent_ParallelToolResultsInCallOrder(t *testing.T) {
+	cm := &fanOutChatModel{targets: []string{"a", "b", "c"}}
+	runner := composeAgent(context.Background(), cm, []tool.BaseTool{&slowFirstTool{}}, manus.NewInMemoryStore())
+
+	state, err := run(context.Background(), runner, "session-1", "scan a, b and c")
+	require.NoError(t, err)
+
+	require.Len(t, state.History, 7)
+	for i, target := range cm.targets {
+		assert.Equal(t, "call-"+target, state.History[3+i].ToolCallID)
+		assert.Equal(t, "scanned "+target, state.History[3+i].Content)
+	}
+	assert.Equal(t, "all done", state.History[6].Content)
+}
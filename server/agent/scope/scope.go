@@ -0,0 +1,307 @@
+// Package scope decides whether a target lies inside the authorized scope of
+// an engagement.
+package scope
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"net/url"
+	"strings"
+)
+
+// Scope lists what an engagement may touch. A target is in scope if an
+// include rule covers it and no exclusion does. A scope without includes
+// covers nothing.
+type Scope struct {
+	CIDRs   []string `json:"cidrs,omitempty"`   // Networks or single addresses, e.g. "10.0.0.0/24"
+	Domains []string `json:"domains,omitempty"` // "example.com" for the domain itself, "*.example.com" for its subdomains
+	URLs    []string `json:"urls,omitempty"`    // URL prefixes, e.g. "https://app.example.com/api/"
+
+	// CIDRs, domains, wildcards or URL prefixes that are out of scope even
+	// if an include rule covers them
+	Exclude []string `json:"exclude,omitempty"`
+}
+
+// ErrOutOfScope is wrapped by the errors of Check for targets outside the scope
+var ErrOutOfScope = errors.New("out of scope")
+
+// Resolve looks up the addresses of a host name, replaced in tests
+var Resolve = func(ctx context.Context, host string) ([]netip.Addr, error) {
+	return net.DefaultResolver.LookupNetIP(ctx, "ip", host)
+}
+
+type scopeKey struct{}
+
+// WithScope has the tools of a run check their targets against s. Without a
+// scope in the context, targets are not checked.
+func WithScope(ctx context.Context, s *Scope) context.Context {
+	return context.WithValue(ctx, scopeKey{}, s)
+}
+
+// FromContext returns the scope of a run, if it has one
+func FromContext(ctx context.Context) (*Scope, bool) {
+	s, ok := ctx.Value(scopeKey{}).(*Scope)
+	return s, ok && s != nil
+}
+
+// rules is a parsed Scope
+type rules struct {
+	prefixes []netip.Prefix
+	domains  []string
+	urls     []string
+}
+
+func parseRules(cidrs, domains, urls []string) (rules, error) {
+	var r rules
+	for _, cidr := range cidrs {
+		prefix, err := parsePrefix(cidr)
+		if err != nil {
+			return r, err
+		}
+		r.prefixes = append(r.prefixes, prefix)
+	}
+	for _, domain := range domains {
+		domain = normalizeHost(domain)
+		name := strings.TrimPrefix(domain, "*.")
+		if name == "" || strings.ContainsAny(name, "*/: ") {
+			return r, fmt.Errorf("invalid domain %q, use example.com or *.example.com", domain)
+		}
+		r.domains = append(r.domains, domain)
+	}
+	for _, prefix := range urls {
+		u, err := url.Parse(strings.TrimSpace(prefix))
+		if err != nil || u.Scheme == "" || u.Host == "" {
+			return r, fmt.Errorf("invalid URL prefix %q", prefix)
+		}
+		r.urls = append(r.urls, normalizeURL(u))
+	}
+	return r, nil
+}
+
+// parse splits the scope into include and exclude rules. Exclusions are
+// sorted into CIDRs, URL prefixes and domains by their form.
+func (s *Scope) parse() (include, exclude rules, err error) {
+	include, err = parseRules(s.CIDRs, s.Domains, s.URLs)
+	if err != nil {
+		return include, exclude, err
+	}
+	var cidrs, domains, urls []string
+	for _, entry := range s.Exclude {
+		entry = strings.TrimSpace(entry)
+		switch {
+		case strings.Contains(entry, "://"):
+			urls = append(urls, entry)
+		case isAddressLike(entry):
+			cidrs = append(cidrs, entry)
+		default:
+			domains = append(domains, entry)
+		}
+	}
+	exclude, err = parseRules(cidrs, domains, urls)
+	return include, exclude, err
+}
+
+// Validate reports the first entry of the scope that cannot be parsed
+func (s *Scope) Validate() error {
+	_, _, err := s.parse()
+	return err
+}
+
+// Check reports whether target is in scope. Targets are addresses, CIDRs,
+// address ranges like 10.0.0.1-20, host names, host:port or URLs. Host names
+// are resolved: a name outside the domain rules is in scope only if all its
+// addresses are, and any excluded address puts a name out of scope.
+func (s *Scope) Check(ctx context.Context, target string) error {
+	include, exclude, err := s.parse()
+	if err != nil {
+		return err
+	}
+	target = strings.TrimSpace(target)
+
+	if strings.Contains(target, "://") {
+		u, err := url.Parse(target)
+		if err != nil || u.Hostname() == "" {
+			return fmt.Errorf("%w: cannot parse URL %s", ErrOutOfScope, target)
+		}
+		normalized := normalizeURL(u)
+		if prefix := matchURL(exclude.urls, normalized); prefix != "" {
+			return fmt.Errorf("%w: %s is excluded by %s", ErrOutOfScope, target, prefix)
+		}
+		if matchURL(include.urls, normalized) != "" {
+			// The host may still be excluded
+			return checkHost(ctx, rules{}, exclude, u.Hostname(), true)
+		}
+		return checkHost(ctx, include, exclude, u.Hostname(), false)
+	}
+
+	host := target
+	if h, _, err := net.SplitHostPort(target); err == nil {
+		host = h
+	}
+	if first, last, ok := parseRange(host); ok {
+		if reason := checkRange(include.prefixes, exclude.prefixes, first, last); reason != "" {
+			return fmt.Errorf("%w: %s %s", ErrOutOfScope, target, reason)
+		}
+		return nil
+	}
+	return checkHost(ctx, include, exclude, host, false)
+}
+
+// checkRange checks the addresses first to last, in scope if one include
+// prefix holds them all and no exclusion overlaps them. It returns why they
+// are out of scope, or "".
+func checkRange(include, exclude []netip.Prefix, first, last netip.Addr) string {
+	for _, prefix := range exclude {
+		if prefix.Addr().Compare(last) <= 0 && first.Compare(lastAddr(prefix)) <= 0 {
+			return "overlaps the excluded " + prefix.String()
+		}
+	}
+	for _, prefix := range include {
+		if prefix.Contains(first) && prefix.Contains(last) {
+			return ""
+		}
+	}
+	return "is not inside an allowed CIDR"
+}
+
+// checkHost checks a host name. allowed means an include rule already covers
+// it and only the exclusions are checked.
+func checkHost(ctx context.Context, include, exclude rules, host string, allowed bool) error {
+	host = normalizeHost(host)
+	if host == "" {
+		return fmt.Errorf("%w: empty target", ErrOutOfScope)
+	}
+	if addr, err := netip.ParseAddr(host); err == nil {
+		if reason := checkRange(allPrefixes(include, allowed), exclude.prefixes, addr, addr); reason != "" {
+			return fmt.Errorf("%w: %s %s", ErrOutOfScope, host, reason)
+		}
+		return nil
+	}
+	if domain := matchDomain(exclude.domains, host); domain != "" {
+		return fmt.Errorf("%w: %s is excluded by %s", ErrOutOfScope, host, domain)
+	}
+	allowed = allowed || matchDomain(include.domains, host) != ""
+
+	addrs, err := Resolve(ctx, host)
+	if err != nil || len(addrs) == 0 {
+		if allowed {
+			// Names in scope need not resolve, e.g. for whois or subdomain enumeration
+			return nil
+		}
+		return fmt.Errorf("%w: %s is not an allowed domain and cannot be resolved", ErrOutOfScope, host)
+	}
+	for _, addr := range addrs {
+		addr = addr.Unmap()
+		if reason := checkRange(allPrefixes(include, allowed), exclude.prefixes, addr, addr); reason != "" {
+			return fmt.Errorf("%w: %s resolves to %s, which %s", ErrOutOfScope, host, addr, reason)
+		}
+	}
+	return nil
+}
+
+// allPrefixes returns the include prefixes, or every address if the target
+// is allowed already
+func allPrefixes(include rules, allowed bool) []netip.Prefix {
+	if allowed {
+		return []netip.Prefix{netip.MustParsePrefix("0.0.0.0/0"), netip.MustParsePrefix("::/0")}
+	}
+	return include.prefixes
+}
+
+func matchDomain(domains []string, host string) string {
+	for _, domain := range domains {
+		if name, ok := strings.CutPrefix(domain, "*."); ok {
+			if strings.HasSuffix(host, "."+name) {
+				return domain
+			}
+		} else if host == domain {
+			return domain
+		}
+	}
+	return ""
+}
+
+func matchURL(prefixes []string, normalized string) string {
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(normalized, prefix) {
+			return prefix
+		}
+	}
+	return ""
+}
+
+// parsePrefix reads a CIDR or a single address
+func parsePrefix(s string) (netip.Prefix, error) {
+	s = strings.TrimSpace(s)
+	if prefix, err := netip.ParsePrefix(s); err == nil {
+		return prefix.Masked(), nil
+	}
+	if addr, err := netip.ParseAddr(s); err == nil {
+		return netip.PrefixFrom(addr, addr.BitLen()), nil
+	}
+	return netip.Prefix{}, fmt.Errorf("invalid CIDR %q", s)
+}
+
+// parseRange reads an address, a CIDR or an IPv4 range of the last octet like
+// 10.0.0.1-20 into its first and last address
+func parseRange(s string) (first, last netip.Addr, ok bool) {
+	if prefix, err := parsePrefix(s); err == nil {
+		return prefix.Addr(), lastAddr(prefix), true
+	}
+	start, end, found := strings.Cut(s, "-")
+	if !found {
+		return first, last, false
+	}
+	first, err := netip.ParseAddr(start)
+	if err != nil || !first.Is4() {
+		return first, last, false
+	}
+	var n int
+	if _, err := fmt.Sscanf(end, "%d", &n); err != nil || fmt.Sprint(n) != end || n > 255 {
+		return first, last, false
+	}
+	b := first.As4()
+	if n < int(b[3]) {
+		return first, last, false
+	}
+	b[3] = byte(n)
+	return first, netip.AddrFrom4(b), true
+}
+
+// lastAddr returns the highest address of prefix
+func lastAddr(prefix netip.Prefix) netip.Addr {
+	b := prefix.Addr().AsSlice()
+	for i := prefix.Bits(); i < len(b)*8; i++ {
+		b[i/8] |= 1 << (7 - i%8)
+	}
+	addr, _ := netip.AddrFromSlice(b)
+	return addr
+}
+
+func isAddressLike(s string) bool {
+	_, _, ok := parseRange(s)
+	return ok
+}
+
+func normalizeHost(host string) string {
+	return strings.TrimSuffix(strings.ToLower(strings.TrimSpace(host)), ".")
+}
+
+// normalizeURL lowercases scheme and host and drops default ports, so that
+// prefixes compare
+func normalizeURL(u *url.URL) string {
+	host := normalizeHost(u.Hostname())
+	if port := u.Port(); port != "" && !(u.Scheme == "http" && port == "80") && !(u.Scheme == "https" && port == "443") {
+		host = net.JoinHostPort(host, port)
+	} else if strings.Contains(host, ":") {
+		host = "[" + host + "]"
+	}
+	path := u.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	return strings.ToLower(u.Scheme) + "://" + host + path
+}
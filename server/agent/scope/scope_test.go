@@ -0,0 +1,126 @@
+package scope
+
+import (
+	"context"
+	"errors"
+	"net/netip"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeDNS answers lookups from a table for the duration of a test
+func fakeDNS(t *testing.T, table map[string][]string) {
+	resolve := Resolve
+	t.Cleanup(func() { Resolve = resolve })
+	Resolve = func(ctx context.Context, host string) ([]netip.Addr, error) {
+		var addrs []netip.Addr
+		for _, a := range table[host] {
+			addrs = append(addrs, netip.MustParseAddr(a))
+		}
+		if len(addrs) == 0 {
+			return nil, errors.New("no such host")
+		}
+		return addrs, nil
+	}
+}
+
+func TestScope_Check(t *testing.T) {
+	fakeDNS(t, map[string][]string{
+		"acme.example":          {"203.0.113.10"},
+		"www.acme.example":      {"203.0.113.11"},
+		"intranet.acme.example": {"10.9.0.1"},
+		"lab.internal":          {"10.0.0.7"},
+		"mixed.internal":        {"10.0.0.8", "192.0.2.1"},
+		"payments.internal":     {"10.0.0.99"},
+	})
+	s := &Scope{
+		CIDRs:   []string{"10.0.0.0/24", "198.51.100.5"},
+		Domains: []string{"acme.example", "*.acme.example"},
+		URLs:    []string{"https://portal.partner.example/acme/"},
+		Exclude: []string{"10.0.0.99", "intranet.acme.example", "https://www.acme.example/admin"},
+	}
+	require.NoError(t, s.Validate())
+
+	inScope := []string{
+		"10.0.0.1", "10.0.0.0/26", "10.0.0.1-20", "10.0.0.5:8080", "198.51.100.5",
+		"acme.example", "www.acme.example", "dev.acme.example", // The last one does not resolve
+		"lab.internal", "http://10.0.0.3/login", "https://www.acme.example/",
+		"https://portal.partner.example/acme/login",
+	}
+	for _, target := range inScope {
+		assert.NoError(t, s.Check(context.Background(), target), target)
+	}
+
+	outOfScope := map[string]string{
+		"10.0.1.1":                              "not inside an allowed CIDR",
+		"10.1.0.0/16":                           "not inside an allowed CIDR",
+		"10.0.0.90-100":                         "overlaps the excluded 10.0.0.99/32",
+		"10.0.0.0/24":                           "overlaps the excluded",
+		"payments.internal":                     "resolves to 10.0.0.99",
+		"mixed.internal":                        "resolves to 192.0.2.1",
+		"unknown.example":                       "cannot be resolved",
+		"intranet.acme.example":                 "excluded by intranet.acme.example",
+		"https://www.acme.example/admin/users":  "excluded by https://www.acme.example/admin",
+		"https://portal.partner.example/other/": "cannot be resolved",
+		"example.org:443":                       "cannot be resolved",
+	}
+	for target, reason := range outOfScope {
+		err := s.Check(context.Background(), target)
+		assert.ErrorIs(t, err, ErrOutOfScope, target)
+		assert.ErrorContains(t, err, reason, target)
+	}
+}
+
+// TestScope_CheckScanTargets covers targets scanners take that look nothing
+// like a host name, they are checked as options of the Kali tools too
+func TestScope_CheckScanTargets(t *testing.T) {
+	fakeDNS(t, map[string][]string{"localhost": {"127.0.0.1"}})
+	s := &Scope{CIDRs: []string{"10.0.0.0/24"}}
+
+	outOfScope := map[string]string{
+		"10.0.0.0/8": "not inside an allowed CIDR",
+		"10.0.0.*":   "cannot be resolved",
+		"localhost":  "resolves to 127.0.0.1",
+		"intranet":   "cannot be resolved",
+		"fe80::1":    "not inside an allowed CIDR",
+		"[fe80::1]":  "cannot be resolved",
+		"RND:5":      "cannot be resolved",
+		"167772161":  "cannot be resolved",
+	}
+	for target, reason := range outOfScope {
+		err := s.Check(context.Background(), target)
+		assert.ErrorIs(t, err, ErrOutOfScope, target)
+		assert.ErrorContains(t, err, reason, target)
+	}
+}
+
+func TestScope_EmptyCoversNothing(t *testing.T) {
+	fakeDNS(t, map[string][]string{"acme.example": {"203.0.113.10"}})
+	s := &Scope{}
+	assert.ErrorIs(t, s.Check(context.Background(), "10.0.0.1"), ErrOutOfScope)
+	assert.ErrorIs(t, s.Check(context.Background(), "acme.example"), ErrOutOfScope)
+}
+
+func TestScope_Validate(t *testing.T) {
+	for _, s := range []*Scope{
+		{CIDRs: []string{"10.0.0.0/33"}},
+		{CIDRs: []string{"acme.example"}},
+		{Domains: []string{"*"}},
+		{Domains: []string{"acme.*.example"}},
+		{URLs: []string{"acme.example/admin"}},
+	} {
+		assert.Error(t, s.Validate(), "%+v", s)
+	}
+}
+
+func TestFromContext(t *testing.T) {
+	_, ok := FromContext(context.Background())
+	assert.False(t, ok)
+
+	s := &Scope{CIDRs: []string{"10.0.0.0/8"}}
+	got, ok := FromContext(WithScope(context.Background(), s))
+	assert.True(t, ok)
+	assert.Same(t, s, got)
+}
@@ -0,0 +1,677 @@
+package tools
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// shellMetacharacters may not appear in the target or options of a Kali
+// tool. Arguments are quoted anyway, this keeps calls that try to reach the
+// shell from running at all.
+const shellMetacharacters = ";&|`$<>(){}\\'\"\n\r!"
+
+// allowedPathPrefixes are the directories file arguments may point into
+var allowedPathPrefixes = []string{"/usr/share/wordlists/", "/usr/share/nmap/"}
+
+// flagPolicy limits the flags of a Kali tool. Flags are matched by name, as
+// "--flag", "--flag=value" or a two letter flag with its value attached like
+// "-oN". A name ending in "-" matches every flag it starts, e.g. "--log-".
+type flagPolicy struct {
+	Allow []string // The only flags allowed, none limits nothing
+	Deny  []string // Flags never allowed
+
+	// Bundled means short flags can be combined, "-tulpn" is checked as
+	// "-t", "-u", "-l", "-p" and "-n"
+	Bundled bool
+
+	// Short flags of a bundled tool that take a value. The rest of the
+	// bundle is the value, like "-dVALUE" or "-sd@file", or the next argument
+	// if the flag ends the bundle.
+	Takes string
+
+	// Checks of flag values, by flag name
+	Values map[string]func(value string) error
+
+	// LongOnly means long options may also start with a single dash, like
+	// with getopt_long_only or Perl's Getopt::Long, and may be abbreviated.
+	// They are checked as "--name" by their full name from LongOptions, and
+	// abbreviations are refused. IgnoreCase matches them in any case.
+	LongOnly    bool
+	LongOptions []string
+	IgnoreCase  bool
+
+	// Flags that take the next argument as a value naming no host, e.g.
+	// "--script" of nmap. The value is not checked against the scope.
+	Valued []string
+
+	// Flags whose value names hosts the call reaches besides its target, by
+	// flag name, with the function that takes the hosts out of the value
+	Hosts map[string]func(value string) []string
+
+	// Positional arguments that are not hosts, like the record types of dig.
+	// A word ending in "*" matches every argument it starts.
+	Words []string
+}
+
+// dnsWords are the record types and options dig takes between its arguments
+var dnsWords = []string{"A", "AAAA", "ANY", "AXFR", "CAA", "CNAME", "DNSKEY", "DS", "HINFO", "IXFR",
+	"MX", "NS", "NSEC", "PTR", "SOA", "SRV", "TXT", "IN", "CH", "+*"}
+
+// nmapScriptCategories are the NSE categories --script may select
+var nmapScriptCategories = []string{"default", "discovery", "safe", "version", "vuln"}
+
+// nmapLongOptions are the long options of nmap, which reads them with
+// getopt_long_only
+var nmapLongOptions = []string{"version", "verbose", "datadir", "servicedb", "versiondb", "debug", "help", "iflist",
+	"release-memory", "max-os-tries", "max-parallelism", "min-parallelism", "timing", "max-rtt-timeout",
+	"min-rtt-timeout", "initial-rtt-timeout", "excludefile", "exclude", "max-hostgroup", "min-hostgroup", "open",
+	"scanflags", "defeat-rst-ratelimit", "defeat-icmp-ratelimit", "host-timeout", "scan-delay", "max-scan-delay",
+	"max-retries", "oA", "oN", "oM", "oG", "oS", "oH", "oX", "iL", "iR", "sI", "source-port", "randomize-hosts",
+	"nsock-engine", "proxies", "proxy", "discovery-ignore-rst", "osscan-limit", "osscan-guess", "fuzzy",
+	"packet-trace", "version-trace", "data", "data-string", "data-length", "send-eth", "send-ip", "stylesheet",
+	"no-stylesheet", "webxml", "rH", "vv", "ff", "privileged", "unprivileged", "mtu", "append-output",
+	"noninteractive", "spoof-mac", "thc", "badsum", "ttl", "traceroute", "reason", "allports", "version-intensity",
+	"version-light", "version-all", "system-dns", "resolve-all", "unique", "log-errors", "deprecated-xml-osclass",
+	"dns-servers", "port-ratio", "exclude-ports", "top-ports", "script", "script-trace", "script-updatedb",
+	"script-args", "script-args-file", "script-help", "script-timeout", "ip-options", "min-rate", "max-rate",
+	"adler32", "stats-every", "disable-arp-ping", "route-dst", "resume"}
+
+// niktoLongOptions are the options of nikto with their one letter aliases,
+// nikto reads them with Getopt::Long
+var niktoLongOptions = []string{"ask", "Cgidirs", "config", "check6", "dbcheck", "Display", "evasion", "Format",
+	"followredirects", "Help", "host", "h", "id", "ipv4", "ipv6", "key", "list-plugins", "maxtime", "mutate",
+	"mutate-options", "nointeractive", "nolookup", "nossl", "no404", "Option", "output", "o", "Pause", "Plugins",
+	"port", "p", "RSAcert", "root", "Save", "ssl", "Tuning", "T", "timeout", "Userdbs", "useragent", "until",
+	"update", "url", "useproxy", "usecookies", "Version", "vhost", "404code", "404string"}
+
+// kaliFlagPolicies keep the Kali tools from writing or reading files, running
+// commands or leaving the engagement, by tool
+var kaliFlagPolicies = map[string]flagPolicy{
+	"nmap": {
+		Deny: []string{"-o", "-i", "--oA", "--oN", "--oM", "--oG", "--oS", "--oH", "--oX", "--iL", "--iR", "--datadir",
+			"--servicedb", "--versiondb", "--resume", "--stylesheet", "--script-updatedb", "--script-args-file",
+			"--excludefile", "--append-output", "--log-errors"},
+		LongOnly:    true,
+		LongOptions: nmapLongOptions,
+		Values: map[string]func(string) error{
+			"--script": nmapScripts,
+		},
+		Valued: []string{"-p", "--script", "--script-args", "--exclude", "--exclude-ports", "-e", "--spoof-mac",
+			"--host-timeout", "--script-timeout", "--scan-delay", "--max-scan-delay", "--min-rtt-timeout",
+			"--max-rtt-timeout", "--initial-rtt-timeout", "--data", "--data-string"},
+		Hosts: map[string]func(string) []string{
+			"-S":            hostList,
+			"-D":            nmapDecoys,
+			"--sI":          hostList,
+			"--proxies":     hostList,
+			"--dns-servers": hostList,
+		},
+	},
+	"masscan": {
+		Deny:   []string{"-o", "--output-filename", "-c", "--conf", "-iL", "--includefile", "--excludefile", "--readscan"},
+		Valued: []string{"-p", "--ports", "--exclude", "-e", "--adapter", "--source-ip", "--router-ip", "--router-mac", "--adapter-mac", "--http-user-agent"},
+	},
+	"curl": {
+		Deny: []string{"-o", "--output", "--output-dir", "-O", "--remote-name", "--remote-name-all", "-K", "--config",
+			"-T", "--upload-file", "-F", "--form", "-D", "--dump-header", "-c", "--cookie-jar", "--trace", "--trace-ascii", "--libcurl",
+			"--variable", "--etag-save", "--hsts", "--alt-svc", "--stderr"},
+		Bundled: true,
+		Takes:   "AbcCdDeEFHKmoPQrtTuUwxXyYz",
+		Values: map[string]func(string) error{
+			"-d":               noFileReference,
+			"--data":           noFileReference,
+			"--data-ascii":     noFileReference,
+			"--data-binary":    noFileReference,
+			"--data-raw":       noFileReference,
+			"--data-urlencode": noFileReference,
+			"--json":           noFileReference,
+			"--url-query":      noFileReference,
+			"-H":               noFileHeader,
+			"--header":         noFileHeader,
+			"--proxy-header":   noFileHeader,
+			"-b":               noCookieFile,
+			"--cookie":         noCookieFile,
+		},
+		Valued: []string{"--request", "--header", "--user-agent", "--referer", "--user", "--cookie", "--data", "--data-ascii",
+			"--data-binary", "--data-raw", "--data-urlencode", "--json", "--url-query", "--range", "--write-out",
+			"--proxy-user", "--proxy-header", "--oauth2-bearer", "--cacert", "--cert", "--key"},
+		Hosts: map[string]func(string) []string{
+			"-x":                hostList,
+			"--proxy":           hostList,
+			"--preproxy":        hostList,
+			"--socks4":          hostList,
+			"--socks4a":         hostList,
+			"--socks5":          hostList,
+			"--socks5-hostname": hostList,
+			"--doh-url":         hostList,
+			"--resolve":         curlResolve,
+			"--connect-to":      curlConnectTo,
+		},
+	},
+	"wget": {
+		Deny: []string{"-O", "--output-document", "-o", "--output-file", "-a", "--append-output", "-P", "--directory-prefix",
+			"-i", "--input-file", "-e", "--execute", "--post-file", "--body-file", "-r", "--recursive", "-m", "--mirror",
+			"--config", "--load-cookies", "--save-cookies", "--warc-file"},
+		Bundled: true,
+		Takes:   "aABdeiIlOoPQRtTUwX",
+		Valued:  []string{"--user-agent", "--header", "--referer", "--user", "--password", "--method", "--post-data", "--body-data"},
+	},
+	"nc": {
+		Deny:    []string{"-e", "-c", "-l", "-k"},
+		Bundled: true,
+		Takes:   "IiMmOPpsTVWwXx",
+		Hosts:   map[string]func(string) []string{"-x": hostList},
+	},
+	"nikto": {
+		Deny:        []string{"--o", "--output", "--config", "--Save", "--update"},
+		LongOnly:    true,
+		LongOptions: niktoLongOptions,
+		IgnoreCase:  true,
+		Valued: []string{"--Tuning", "--T", "--Plugins", "--Display", "--evasion", "--mutate", "--useragent", "--id",
+			"--root", "--vhost", "--maxtime"},
+		Hosts: map[string]func(string) []string{"--h": hostList, "--host": hostList, "--url": hostList, "--useproxy": hostList},
+	},
+	"dirb": {
+		Deny:   []string{"-o"},
+		Valued: []string{"-a", "-c", "-H", "-u", "-X", "-P"},
+		Hosts:  map[string]func(string) []string{"-p": hostList},
+	},
+	"gobuster": {
+		Deny: []string{"-o", "--output"},
+		Valued: []string{"-w", "--wordlist", "-x", "--extensions", "-s", "--status-codes", "-b", "--status-codes-blacklist",
+			"-a", "--useragent", "-c", "--cookies", "-H", "--headers", "-U", "--username", "-P", "--password",
+			"-m", "--method", "--delay", "--timeout"},
+		Hosts: map[string]func(string) []string{"-u": hostList, "--url": hostList, "--domain": hostList, "--proxy": hostList},
+		Words: []string{"dir", "dns", "vhost", "fuzz"},
+	},
+	"sublist3r": {
+		Deny:   []string{"-o", "--output"},
+		Valued: []string{"-e", "--engines"},
+		Hosts:  map[string]func(string) []string{"-d": hostList, "--domain": hostList},
+	},
+	"theharvester": {
+		Deny:   []string{"-f", "--filename"},
+		Valued: []string{"-b", "--source"},
+		Hosts:  map[string]func(string) []string{"-d": hostList, "--domain": hostList},
+	},
+	"whatweb": {
+		Deny:   []string{"--log-", "--output"},
+		Valued: []string{"-U", "--user-agent", "-H", "--header", "-c", "--cookie", "-p", "--plugins"},
+		Hosts:  map[string]func(string) []string{"--proxy": hostList},
+	},
+	"smbclient": {
+		Deny:   []string{"-c", "--command", "-T", "--tar"},
+		Valued: []string{"-U", "--user", "-W", "--workgroup", "-m", "--max-protocol", "-n", "--netbiosname"},
+		Hosts:  map[string]func(string) []string{"-I": hostList, "--ip-address": hostList},
+	},
+	"enum4linux": {Valued: []string{"-u", "-p", "-w"}},
+	"whois":      {Hosts: map[string]func(string) []string{"-h": hostList, "--host": hostList}},
+	"dig":        {Words: dnsWords},
+	"host":       {Valued: []string{"-t"}},
+	"ping": {
+		Allow: []string{"-c", "-W", "-w", "-i", "-s", "-n", "-q", "-4", "-6"},
+	},
+	"traceroute": {
+		Allow: []string{"-n", "-m", "-w", "-q", "-p", "-I", "-T", "-U", "-4", "-6"},
+	},
+	"ss": {
+		Allow:   []string{"-t", "-u", "-l", "-p", "-n", "-a", "-e", "-m", "-o", "-i", "-s", "-x", "-w", "-r", "-H", "-4", "-6"},
+		Bundled: true,
+	},
+	"netstat": {
+		Allow:   []string{"-t", "-u", "-l", "-p", "-n", "-a", "-e", "-r", "-i", "-s", "-W", "-4", "-6"},
+		Bundled: true,
+	},
+}
+
+// kaliArgv builds the argv of a Kali tool call. Options are split at
+// whitespace, and every argument is checked against the tool's flag policy.
+func kaliArgv(params kaliParams) ([]string, []ArgumentError) {
+	var errs []ArgumentError
+	if i := strings.IndexAny(params.Target, shellMetacharacters); i >= 0 {
+		errs = append(errs, ArgumentError{Field: "target", Message: fmt.Sprintf("must not contain the shell metacharacter %q", params.Target[i])})
+	} else if strings.ContainsAny(params.Target, " \t") {
+		errs = append(errs, ArgumentError{Field: "target", Message: "must be a single target without spaces"})
+	} else if strings.HasPrefix(params.Target, "-") {
+		errs = append(errs, ArgumentError{Field: "target", Message: "must not start with -, pass flags in options"})
+	} else if err := checkPath(params.Target); err != nil {
+		errs = append(errs, ArgumentError{Field: "target", Message: err.Error()})
+	}
+	if i := strings.IndexAny(params.Options, shellMetacharacters); i >= 0 {
+		errs = append(errs, ArgumentError{Field: "options", Message: fmt.Sprintf("must not contain the shell metacharacter %q", params.Options[i])})
+	}
+	if len(errs) > 0 {
+		return nil, errs
+	}
+
+	options := strings.Fields(params.Options)
+	for _, err := range checkOptions(kaliFlagPolicies[params.Tool], options) {
+		errs = append(errs, ArgumentError{Field: "options", Message: err.Error()})
+	}
+	if len(errs) > 0 {
+		return nil, errs
+	}
+
+	argv := []string{params.Tool}
+	switch params.Tool {
+	case "gobuster":
+		// Gobuster requires specific syntax: gobuster <mode> -u <url> [options]
+		// Default to 'dir' mode if no mode specified in options
+		switch {
+		case len(options) == 0:
+			// Default gobuster dir scan with common wordlist
+			argv = append(argv, "dir", "-u", params.Target, "-w", "/usr/share/wordlists/dirb/common.txt")
+		case slices.ContainsFunc(options, isGobusterMode):
+			argv = append(append(argv, options...), "-u", params.Target)
+		default:
+			argv = append(append(argv, "dir", "-u", params.Target), options...)
+		}
+	case "nmap":
+		// XML on stdout is parsed into a summary, see nmapResult. -o is denied
+		// to the model, so this is the only output option.
+		argv = append(append(argv, "-oX", "-"), options...)
+		argv = append(argv, params.Target)
+	case "dirb":
+		// DIRB syntax: dirb <url> [wordlist] [options]
+		argv = append(argv, params.Target)
+		if len(options) == 0 {
+			// Default dirb with common wordlist
+			options = []string{"/usr/share/wordlists/dirb/common.txt"}
+		}
+		argv = append(argv, options...)
+	default:
+		argv = append(append(argv, options...), params.Target)
+	}
+	return argv, nil
+}
+
+// kaliLocalTools only look at the sandbox itself, their targets are not checked
+// against the scope
+var kaliLocalTools = map[string]bool{"netstat": true, "ss": true}
+
+// kaliTargets returns what a Kali tool call may reach, to be checked against
+// the scope: the target, every positional option and the values of the flags
+// that name hosts. It fails closed, only options the tool's policy knows not
+// to be hosts are left out, like ports, allowed files and Valued flag values.
+func kaliTargets(params kaliParams) []string {
+	policy := kaliFlagPolicies[params.Tool]
+	targets := []string{params.Target}
+	options := strings.Fields(params.Options)
+	flags := true
+	for i := 0; i < len(options); i++ {
+		arg := options[i]
+		switch {
+		case flags && arg == "--":
+			flags = false
+		case !flags || !strings.HasPrefix(arg, "-") || arg == "-":
+			// dig takes its name server as @server
+			if arg = strings.TrimPrefix(arg, "@"); !notHost(policy, arg) {
+				targets = append(targets, arg)
+			}
+		case policy.Bundled && !strings.HasPrefix(arg, "--"):
+			for j := 1; j < len(arg); j++ {
+				if !strings.ContainsRune(policy.Takes, rune(arg[j])) {
+					continue
+				}
+				value := arg[j+1:]
+				if value == "" && i+1 < len(options) {
+					i++
+					value = options[i]
+				}
+				if hosts := policy.Hosts["-"+arg[j:j+1]]; hosts != nil && value != "" {
+					targets = append(targets, hosts(value)...)
+				}
+				break
+			}
+		default:
+			// Long options of LongOnly tools are looked up as "--name", however
+			// they are spelled. kaliArgv refused the abbreviated ones already.
+			if long, err := longOption(policy, arg); err == nil {
+				arg = long
+			}
+			name, value, attached := strings.Cut(arg, "=")
+			hosts := policy.Hosts[name]
+			if !attached && (hosts != nil || slices.Contains(policy.Valued, name)) && i+1 < len(options) {
+				i++
+				value, attached = options[i], true
+			}
+			if attached && hosts != nil {
+				targets = append(targets, hosts(value)...)
+			}
+		}
+	}
+	return targets
+}
+
+// notHost reports whether a positional option is known not to name a host
+func notHost(policy flagPolicy, arg string) bool {
+	if arg == "" || arg == "-" || strings.HasPrefix(arg, "/") || isPortList(arg) {
+		// Files are confined to allowedPathPrefixes by checkPath already
+		return true
+	}
+	for _, word := range policy.Words {
+		if prefix, ok := strings.CutSuffix(word, "*"); ok && strings.HasPrefix(arg, prefix) || strings.EqualFold(word, arg) {
+			return true
+		}
+	}
+	return false
+}
+
+// isPortList reports whether arg is a port, a port range or a list of them,
+// like "80", "1-1024" or "22,80,443". Larger numbers may be addresses.
+func isPortList(arg string) bool {
+	for _, part := range strings.FieldsFunc(arg, func(r rune) bool { return r == ',' || r == '-' }) {
+		if port, err := strconv.Atoi(part); err != nil || port < 0 || port > 65535 {
+			return false
+		}
+	}
+	return strings.Trim(arg, ",-") != ""
+}
+
+// hostList takes the hosts out of a comma separated list, like the value of
+// nmap -S or --proxies
+func hostList(value string) []string {
+	var hosts []string
+	for _, host := range strings.Split(value, ",") {
+		if host != "" {
+			hosts = append(hosts, host)
+		}
+	}
+	return hosts
+}
+
+// nmapDecoys takes the decoys out of the value of nmap -D. ME is the scanner
+// itself, random decoys like RND:10 are kept and refused by the scope.
+func nmapDecoys(value string) []string {
+	return slices.DeleteFunc(hostList(value), func(host string) bool { return host == "ME" })
+}
+
+// curlResolve takes the addresses out of the value of curl --resolve,
+// "[+]host:port:addr[,addr]...". "-host:port" removes an entry, it reaches nothing.
+func curlResolve(value string) []string {
+	if strings.HasPrefix(value, "-") {
+		return nil
+	}
+	parts := splitHostPort(strings.TrimPrefix(value, "+"))
+	if len(parts) < 3 {
+		return []string{value}
+	}
+	var hosts []string
+	for _, addr := range hostList(strings.Join(parts[2:], ":")) {
+		hosts = append(hosts, strings.Trim(addr, "[]"))
+	}
+	return hosts
+}
+
+// curlConnectTo takes the host connected to out of the value of curl
+// --connect-to, "host1:port1:host2:port2". An empty host2 keeps the host of
+// the URL, which is checked as the target.
+func curlConnectTo(value string) []string {
+	parts := splitHostPort(value)
+	if len(parts) != 4 {
+		return []string{value}
+	}
+	if host := strings.Trim(parts[2], "[]"); host != "" {
+		return []string{host}
+	}
+	return nil
+}
+
+// splitHostPort splits value at the colons outside of brackets, which enclose
+// IPv6 addresses
+func splitHostPort(value string) []string {
+	var parts []string
+	start, inBrackets := 0, false
+	for i, c := range value {
+		switch {
+		case c == '[':
+			inBrackets = true
+		case c == ']':
+			inBrackets = false
+		case c == ':' && !inBrackets:
+			parts = append(parts, value[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, value[start:])
+}
+
+func isGobusterMode(arg string) bool {
+	switch arg {
+	case "dir", "dns", "vhost", "fuzz":
+		return true
+	}
+	return false
+}
+
+// checkOptions checks the options of a tool call against its policy and the
+// file arguments against allowedPathPrefixes
+func checkOptions(policy flagPolicy, options []string) []error {
+	var errs []error
+	for i := 0; i < len(options); i++ {
+		arg := options[i]
+		if err := checkPath(arg); err != nil {
+			errs = append(errs, err)
+		}
+		if !strings.HasPrefix(arg, "-") || arg == "-" || arg == "--" {
+			continue
+		}
+		arg, err := longOption(policy, arg)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+
+		if policy.Bundled && !strings.HasPrefix(arg, "--") {
+			bundleErrs, consumed := checkBundle(policy, arg, options[i+1:])
+			errs = append(errs, bundleErrs...)
+			for _, value := range options[i+1 : i+1+consumed] {
+				if err := checkPath(value); err != nil {
+					errs = append(errs, err)
+				}
+			}
+			i += consumed
+			continue
+		}
+
+		if err := checkFlag(policy, arg); err != nil {
+			errs = append(errs, err)
+		}
+		for name, check := range policy.Values {
+			value, ok := flagValue(name, arg, options[i+1:])
+			if !ok {
+				continue
+			}
+			if err := check(value); err != nil {
+				errs = append(errs, fmt.Errorf("%s %s: %w", name, value, err))
+			}
+		}
+	}
+	return errs
+}
+
+// checkBundle checks the short flags combined in arg one by one, like getopt
+// reads them: a flag that takes a value ends the bundle. It returns how many
+// of the following arguments were used as that value.
+func checkBundle(policy flagPolicy, arg string, rest []string) ([]error, int) {
+	var errs []error
+	for i := 1; i < len(arg); i++ {
+		flag := "-" + arg[i:i+1]
+		if err := checkFlag(policy, flag); err != nil {
+			errs = append(errs, err)
+		}
+		if !strings.Contains(policy.Takes, arg[i:i+1]) {
+			continue
+		}
+
+		value, consumed := arg[i+1:], 0
+		if value == "" && len(rest) > 0 {
+			value, consumed = rest[0], 1
+		}
+		if check, ok := policy.Values[flag]; ok {
+			if err := check(value); err != nil {
+				errs = append(errs, fmt.Errorf("%s %s: %w", flag, value, err))
+			}
+		}
+		return errs, consumed
+	}
+	return errs, 0
+}
+
+// longOption returns arg as "--name" or "--name=value" if it is a long option
+// of a LongOnly tool, and refuses abbreviated long options. Other arguments
+// are returned as they are.
+func longOption(policy flagPolicy, arg string) (string, error) {
+	if !policy.LongOnly {
+		return arg, nil
+	}
+	body, double := strings.CutPrefix(arg, "--")
+	if !double {
+		body = arg[1:]
+	}
+	name, value, attached := strings.Cut(body, "=")
+	var abbreviates []string
+	for _, long := range policy.LongOptions {
+		if long == name || policy.IgnoreCase && strings.EqualFold(long, name) {
+			if attached {
+				return "--" + long + "=" + value, nil
+			}
+			return "--" + long, nil
+		}
+		prefix := strings.HasPrefix(long, name) || policy.IgnoreCase && strings.HasPrefix(strings.ToLower(long), strings.ToLower(name))
+		if prefix && (double || len(name) > 1) {
+			abbreviates = append(abbreviates, "--"+long)
+		}
+	}
+	if len(abbreviates) > 0 {
+		return "", fmt.Errorf("flag %s abbreviates %s, spell it out", arg, strings.Join(abbreviates, " or "))
+	}
+	// Anything else is a short option, with its value attached like "-p80"
+	return arg, nil
+}
+
+// checkFlag checks a single flag against the allow and deny lists
+func checkFlag(policy flagPolicy, flag string) error {
+	if matchFlag(policy.Deny, flag) != "" {
+		return fmt.Errorf("flag %s is not allowed", flag)
+	}
+	// getopt_long and argparse take any unambiguous abbreviation of a long
+	// option, like "--output-doc" for "--output-document"
+	if name, _, _ := strings.Cut(flag, "="); strings.HasPrefix(name, "--") && !policy.knows(name) {
+		for _, denied := range policy.Deny {
+			if strings.HasPrefix(denied, name) {
+				return fmt.Errorf("flag %s abbreviates %s, which is not allowed", name, denied)
+			}
+		}
+	}
+	if len(policy.Allow) > 0 && matchFlag(policy.Allow, flag) == "" {
+		return fmt.Errorf("flag %s is not allowed, allowed are %s", flag, strings.Join(policy.Allow, " "))
+	}
+	return nil
+}
+
+// knows reports whether the policy names the flag anywhere but in Deny
+func (policy flagPolicy) knows(flag string) bool {
+	_, checked := policy.Values[flag]
+	_, hosts := policy.Hosts[flag]
+	return checked || hosts || slices.Contains(policy.Allow, flag) || slices.Contains(policy.Valued, flag) ||
+		slices.Contains(policy.LongOptions, strings.TrimPrefix(flag, "--"))
+}
+
+// matchFlag returns the name of names that arg is, or "" if none
+func matchFlag(names []string, arg string) string {
+	for _, name := range names {
+		switch {
+		case arg == name, strings.HasPrefix(arg, name+"="):
+			return name
+		case strings.HasSuffix(name, "-") && strings.HasPrefix(arg, name):
+			return name
+		case len(name) == 2 && !strings.HasPrefix(arg, "--") && strings.HasPrefix(arg, name):
+			return name
+		}
+	}
+	return ""
+}
+
+// flagValue returns the value given to the flag name by arg, attached with
+// "=", attached to a short flag like "-dVALUE", or as the next argument
+func flagValue(name, arg string, rest []string) (string, bool) {
+	if value, ok := strings.CutPrefix(arg, name+"="); ok {
+		return value, true
+	}
+	if len(name) == 2 && len(arg) > 2 && !strings.HasPrefix(arg, "--") && strings.HasPrefix(arg, name) {
+		return arg[2:], true
+	}
+	if arg == name && len(rest) > 0 {
+		return rest[0], true
+	}
+	return "", false
+}
+
+// checkPath keeps file arguments, alone or as a flag value, inside
+// allowedPathPrefixes
+func checkPath(arg string) error {
+	path := arg
+	if _, value, ok := strings.Cut(arg, "="); ok && strings.HasPrefix(arg, "-") {
+		path = value
+	}
+	if strings.Contains(path, "..") {
+		return fmt.Errorf("argument %s must not contain ..", arg)
+	}
+	if strings.HasPrefix(strings.ToLower(path), "file:") {
+		return fmt.Errorf("argument %s must not be a local file URL", arg)
+	}
+	if !strings.HasPrefix(path, "/") && !strings.HasPrefix(path, "~") {
+		return nil
+	}
+	for _, prefix := range allowedPathPrefixes {
+		if strings.HasPrefix(path, prefix) {
+			return nil
+		}
+	}
+	return fmt.Errorf("file %s is outside %s", path, strings.Join(allowedPathPrefixes, " and "))
+}
+
+func nmapScripts(value string) error {
+	for _, script := range strings.Split(value, ",") {
+		if !slices.Contains(nmapScriptCategories, script) {
+			return fmt.Errorf("only the script categories %s are allowed", strings.Join(nmapScriptCategories, ", "))
+		}
+	}
+	return nil
+}
+
+func noFileReference(value string) error {
+	if strings.Contains(value, "@") {
+		return fmt.Errorf("must not read a file with @")
+	}
+	return nil
+}
+
+// noFileHeader refuses curl's "@file" form of headers
+func noFileHeader(value string) error {
+	if strings.HasPrefix(value, "@") {
+		return fmt.Errorf("must not read headers from a file")
+	}
+	return nil
+}
+
+// noCookieFile refuses curl cookies without "=", which curl reads as a file name
+func noCookieFile(value string) error {
+	if !strings.Contains(value, "=") {
+		return fmt.Errorf("must be cookies like name=value, not a file")
+	}
+	return nil
+}
+
+// shellJoin quotes argv into a command line that runs it without any shell
+// expansion
+func shellJoin(argv []string) string {
+	quoted := make([]string, len(argv))
+	for i, arg := range argv {
+		quoted[i] = shellQuote(arg)
+	}
+	return strings.Join(quoted, " ")
+}
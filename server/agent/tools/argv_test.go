@@ -0,0 +1,212 @@
+package tools
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKaliArgv_RejectsOptions(t *testing.T) {
+	tests := []struct {
+		tool, options, reason string
+	}{
+		// Bundled short flags
+		{"curl", "-sLo /tmp/x", "flag -o is not allowed"},
+		{"curl", "-sK cfg", "flag -K is not allowed"},
+		{"wget", "-qO file", "flag -O is not allowed"},
+		{"wget", "-qi list", "flag -i is not allowed"},
+		{"nc", "-lvp 4444", "flag -l is not allowed"},
+
+		// Values attached to a short flag, alone or at the end of a bundle
+		{"curl", "-d@/etc/passwd", "must not read a file"},
+		{"curl", "-d @secrets", "must not read a file"},
+		{"curl", "-sd @/etc/passwd", "must not read a file"},
+		{"curl", "-sd@secrets", "must not read a file"},
+		{"curl", "-H @headers", "must not read headers from a file"},
+		{"curl", "-b cookies.txt", "must be cookies like name=value"},
+
+		// The data flags, with the value attached or as the next argument
+		{"curl", "--data-ascii @secrets", "must not read a file"},
+		{"curl", "--data-raw=@secrets", "must not read a file"},
+		{"curl", "--data-binary @secrets", "must not read a file"},
+		{"curl", "--json @body.json", "must not read a file"},
+		{"curl", "--url-query @query", "must not read a file"},
+		{"curl", "--variable name@file", "flag --variable is not allowed"},
+
+		// Files and policies of other tools
+		{"nmap", "-oX /tmp/x", "flag --oX is not allowed"},
+		{"nmap", "--script=exploit", "only the script categories"},
+
+		// Long options with a single dash or abbreviated, nmap and nikto take both
+		{"nmap", "-script=dos,brute", "only the script categories"},
+		{"nmap", "-script brute", "only the script categories"},
+		{"nmap", "-datadir=x", "flag --datadir=x is not allowed"},
+		{"nmap", "-resume x", "flag --resume is not allowed"},
+		{"nmap", "--scr=brute", "abbreviates --script"},
+		{"nmap", "-iL targets", "flag --iL is not allowed"},
+		{"nmap", "-oXscan.xml", "flag -oXscan.xml is not allowed"},
+		{"nikto", "--output=x", "flag --output=x is not allowed"},
+		{"nikto", "-OUTPUT x", "flag --output is not allowed"},
+		{"nikto", "-o x", "flag --o is not allowed"},
+		{"nikto", "-upd", "abbreviates --update"},
+		{"wget", "--output-doc=x", "abbreviates --output-document"},
+		{"curl", "--remote-n", "abbreviates --remote-name"},
+		{"gobuster", "-w /etc/passwd", "outside"},
+		{"ping", "-f", "flag -f is not allowed, allowed are"},
+		{"ss", "-tulpnF", "flag -F is not allowed"},
+	}
+	for _, tt := range tests {
+		_, errs := kaliArgv(kaliParams{Tool: tt.tool, Target: "example.com", Options: tt.options})
+		require.NotEmpty(t, errs, "%s %s", tt.tool, tt.options)
+		assert.Contains(t, errs[0].Message, tt.reason, "%s %s", tt.tool, tt.options)
+	}
+}
+
+func TestKaliArgv_AllowsOptions(t *testing.T) {
+	tests := []struct {
+		tool, options string
+		argv          []string
+	}{
+		{"curl", "-sSI", []string{"curl", "-sSI", "example.com"}},
+		{"curl", "-XPOST -d a=b -H Accept:text/html", []string{"curl", "-XPOST", "-d", "a=b", "-H", "Accept:text/html", "example.com"}},
+		{"curl", "-sA Mozilla -b session=1", []string{"curl", "-sA", "Mozilla", "-b", "session=1", "example.com"}},
+		{"curl", "-X -o", []string{"curl", "-X", "-o", "example.com"}}, // -o is the value of -X
+		{"wget", "-qS --spider", []string{"wget", "-qS", "--spider", "example.com"}},
+		{"nc", "-zvw 3", []string{"nc", "-zvw", "3", "example.com"}},
+		{"nmap", "-sV -p 1-1000", []string{"nmap", "-oX", "-", "-sV", "-p", "1-1000", "example.com"}},
+		{"nmap", "-sC -script default -p80 -T4 -open", []string{"nmap", "-oX", "-", "-sC", "-script", "default", "-p80", "-T4", "-open", "example.com"}},
+		{"nikto", "-Tuning 1 -port 443 -ssl", []string{"nikto", "-Tuning", "1", "-port", "443", "-ssl", "example.com"}},
+		{"curl", "--cookie a=b", []string{"curl", "--cookie", "a=b", "example.com"}},
+		{"gobuster", "", []string{"gobuster", "dir", "-u", "example.com", "-w", "/usr/share/wordlists/dirb/common.txt"}},
+	}
+	for _, tt := range tests {
+		argv, errs := kaliArgv(kaliParams{Tool: tt.tool, Target: "example.com", Options: tt.options})
+		assert.Empty(t, errs, "%s %s", tt.tool, tt.options)
+		assert.Equal(t, tt.argv, argv, "%s %s", tt.tool, tt.options)
+	}
+}
+
+func TestKaliArgv_RejectsTarget(t *testing.T) {
+	for _, target := range []string{"example.com;id", "$(id)", "a b", "-oX", "/etc/passwd", "file:///etc/passwd", "../x"} {
+		_, errs := kaliArgv(kaliParams{Tool: "curl", Target: target})
+		require.NotEmpty(t, errs, target)
+		assert.Equal(t, "target", errs[0].Field, target)
+	}
+}
+
+func TestLongOption(t *testing.T) {
+	nmap, nikto := kaliFlagPolicies["nmap"], kaliFlagPolicies["nikto"]
+	tests := []struct {
+		policy    flagPolicy
+		arg, want string
+	}{
+		{nmap, "-script=vuln", "--script=vuln"},
+		{nmap, "--script", "--script"},
+		{nmap, "-oX", "--oX"},
+		{nmap, "-p80", "-p80"},
+		{nmap, "-sV", "-sV"},
+		{nmap, "-v", "-v"},
+		{nikto, "-HOST", "--host"},
+		{nikto, "-h", "--h"},
+		{nikto, "--useproxy=http://p:8080", "--useproxy=http://p:8080"},
+		{kaliFlagPolicies["curl"], "-sS", "-sS"},
+	}
+	for _, tt := range tests {
+		got, err := longOption(tt.policy, tt.arg)
+		require.NoError(t, err, tt.arg)
+		assert.Equal(t, tt.want, got, tt.arg)
+	}
+
+	for _, arg := range []string{"-scri", "--scr=x", "-ver", "-de"} {
+		_, err := longOption(nmap, arg)
+		assert.ErrorContains(t, err, "abbreviates", arg)
+	}
+}
+
+func TestMatchFlag(t *testing.T) {
+	names := []string{"-o", "--output", "--log-"}
+	tests := map[string]string{
+		"-o":              "-o",
+		"-oN":             "-o",
+		"--output":        "--output",
+		"--output=x":      "--output",
+		"--output-dir":    "",
+		"--log-verbose":   "--log-",
+		"--open":          "",
+		"-O":              "",
+		"--outputs=value": "",
+	}
+	for arg, want := range tests {
+		assert.Equal(t, want, matchFlag(names, arg), arg)
+	}
+}
+
+func TestFlagValue(t *testing.T) {
+	tests := []struct {
+		name, arg string
+		rest      []string
+		value     string
+		ok        bool
+	}{
+		{"-d", "-d", []string{"a=b"}, "a=b", true},
+		{"-d", "-d@file", nil, "@file", true},
+		{"--data", "--data=@file", nil, "@file", true},
+		{"--data", "--data", []string{"@file"}, "@file", true},
+		{"--data", "--data-raw", []string{"@file"}, "", false},
+		{"-d", "-d", nil, "", false},
+		{"-d", "--data", nil, "", false},
+	}
+	for _, tt := range tests {
+		value, ok := flagValue(tt.name, tt.arg, tt.rest)
+		assert.Equal(t, tt.ok, ok, tt.arg)
+		assert.Equal(t, tt.value, value, tt.arg)
+	}
+}
+
+func TestKaliTargets(t *testing.T) {
+	tests := []struct {
+		tool, options string
+		targets       []string
+	}{
+		// Every positional option is a target, whatever it looks like
+		{"nmap", "-sV 10.0.0.0/8", []string{"example.com", "10.0.0.0/8"}},
+		{"nmap", "10.0.0.*", []string{"example.com", "10.0.0.*"}},
+		{"nmap", "-sn 167772161", []string{"example.com", "167772161"}},
+		{"nc", "-zv localhost 80", []string{"example.com", "localhost"}},
+		{"curl", "-s intranet", []string{"example.com", "intranet"}},
+		{"ping", "-c 3 fe80::1", []string{"example.com", "fe80::1"}},
+		{"masscan", "-p 80 -- 10.0.0.0/8", []string{"example.com", "10.0.0.0/8"}},
+		{"dig", "@8.8.8.8 MX +short", []string{"example.com", "8.8.8.8"}},
+
+		// Flag values that name hosts
+		{"nmap", "-sS -S 192.0.2.1 -D 10.0.0.2,ME,RND:3", []string{"example.com", "192.0.2.1", "10.0.0.2", "RND:3"}},
+		{"nmap", "--proxies=http://proxy.example:8080", []string{"example.com", "http://proxy.example:8080"}},
+		{"curl", "--resolve example.com:443:192.0.2.7,[2001:db8::7]", []string{"example.com", "192.0.2.7", "2001:db8::7"}},
+		{"curl", "--connect-to example.com:443:[2001:db8::1]:8443", []string{"example.com", "2001:db8::1"}},
+		{"curl", "--connect-to example.com:443::8443", []string{"example.com"}},
+		{"curl", "-sx proxy.example:3128", []string{"example.com", "proxy.example:3128"}},
+		{"curl", "-sxproxy.example:3128", []string{"example.com", "proxy.example:3128"}},
+		{"nc", "-zx 192.0.2.9:1080", []string{"example.com", "192.0.2.9:1080"}},
+
+		// nikto and nmap take long options with one dash or two, in any spelling
+		{"nikto", "--host=out.example", []string{"example.com", "out.example"}},
+		{"nikto", "-host out.example", []string{"example.com", "out.example"}},
+		{"nikto", "-h out.example", []string{"example.com", "out.example"}},
+		{"nikto", "--useproxy=http://proxy.example:8080", []string{"example.com", "http://proxy.example:8080"}},
+		{"nikto", "-HOST=out.example -Tuning 123", []string{"example.com", "out.example"}},
+		{"nmap", "-proxies=http://proxy.example:8080", []string{"example.com", "http://proxy.example:8080"}},
+		{"nmap", "-sI zombie.example", []string{"example.com", "zombie.example"}},
+		{"nmap", "-script default -dns-servers 192.0.2.53", []string{"example.com", "192.0.2.53"}},
+
+		// Ports, allowed files, keywords and values of flags that name no host
+		{"nmap", "-sV -p 1-1000 --script default --top-ports 100 -T4", []string{"example.com"}},
+		{"gobuster", "dir -w /usr/share/wordlists/dirb/common.txt -t 50 -x php,html", []string{"example.com"}},
+		{"curl", "-H Accept:text/html -d a=b --user-agent Mozilla", []string{"example.com"}},
+		{"nc", "-zvw 3 80", []string{"example.com"}},
+	}
+	for _, tt := range tests {
+		targets := kaliTargets(kaliParams{Tool: tt.tool, Target: "example.com", Options: tt.options})
+		assert.Equal(t, tt.targets, targets, "%s %s", tt.tool, tt.options)
+	}
+}
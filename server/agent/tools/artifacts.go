@@ -0,0 +1,55 @@
+package tools
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/cloudwego/eino/compose"
+)
+
+// ArtifactSaver keeps a file a tool produced, e.g. the raw output of a scan,
+// with the session that ran the tool
+type ArtifactSaver func(ctx context.Context, name string, data []byte) error
+
+type artifactSaverKey struct{}
+
+// WithArtifactSaver has the tools of a run keep their files with save.
+// Without a saver in the context, the files are dropped.
+func WithArtifactSaver(ctx context.Context, save ArtifactSaver) context.Context {
+	return context.WithValue(ctx, artifactSaverKey{}, save)
+}
+
+// saveArtifact keeps data under name if the run has an artifact saver, and
+// reports whether it did
+func saveArtifact(ctx context.Context, name string, data []byte) (bool, error) {
+	save, ok := ctx.Value(artifactSaverKey{}).(ArtifactSaver)
+	if !ok || save == nil {
+		return false, nil
+	}
+	if err := save(ctx, name, data); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// artifactCounter tells apart the artifacts of calls without a tool call ID
+var artifactCounter atomic.Int64
+
+// artifactName returns a name for the artifact of a tool call that no other
+// call shares, even in parallel: the time and the tool call ID, or a counter
+// outside of a tool call
+func artifactName(ctx context.Context, prefix string) string {
+	suffix := strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_' {
+			return r
+		}
+		return -1
+	}, compose.GetToolCallID(ctx))
+	if suffix == "" {
+		suffix = fmt.Sprint(artifactCounter.Add(1))
+	}
+	return prefix + "-" + time.Now().UTC().Format("20060102-150405") + "-" + suffix
+}
@@ -2,6 +2,7 @@ package tools
 
 import (
 	"context"
+	"fmt"
 	"log"
 	"os"
 	"time"
@@ -32,13 +33,22 @@ func init() {
 	openaiAPIKey = os.Getenv("OPENAI_API_KEY")
 	openaiModel = os.Getenv("OPENAI_MODEL")
 	openaiBaseURL = os.Getenv("OPENAI_API_BASE")
+}
 
+func NewChatModel(ctx context.Context) model.ToolCallingChatModel {
+	return NewChatModelFor(ctx, "")
+}
+
+// NewChatModelFor creates a chat model running the named model, an empty name
+// uses OPENAI_MODEL. The variables are checked here rather than on import, so
+// the package can be tested without them.
+func NewChatModelFor(ctx context.Context, name string) model.ToolCallingChatModel {
 	if openaiAPIKey == "" || openaiModel == "" || openaiBaseURL == "" {
 		log.Fatal("Error: Required environment variables (OPENAI_API_KEY, OPENAI_MODEL, OPENAI_API_BASE) are not set.")
 	}
-}
-
-func NewChatModel(ctx context.Context) model.ToolCallingChatModel {
+	if name == "" {
+		name = openaiModel
+	}
 	var cm model.ToolCallingChatModel
 	var err error
 	var temp float32 = 0
@@ -46,7 +56,7 @@ func NewChatModel(ctx context.Context) model.ToolCallingChatModel {
 	cm, err = openai.NewChatModel(ctx, &openai.ChatModelConfig{
 		APIKey:      openaiAPIKey,
 		BaseURL:     openaiBaseURL,
-		Model:       openaiModel,
+		Model:       name,
 		Temperature: &temp,
 		ByAzure:     false,
 	})
@@ -109,6 +119,34 @@ func NewCommandLineTool(ctx context.Context, sb commandline.Operator) []tool.Bas
 	return []tool.BaseTool{et, pt}
 }
 
+// NewAgentTools starts the Python and Kali Linux sandboxes and returns the
+// tools of both. The agents share one set, so each sandbox runs only once.
+// Kali tools run as background jobs of jobs, if set.
+func NewAgentTools(ctx context.Context, jobs *JobManager) []tool.BaseTool {
+	// init Python sandbox and tools
+	util.LogMessage("Creating Python sandbox...")
+	pythonSb := NewSandbox(ctx)
+	//defer pythonSb.Cleanup(ctx)
+
+	util.LogMessage("Creating Python command line tools...")
+	pythonTools := NewCommandLineTool(ctx, NewKillableOperator(pythonSb))
+	util.LogMessage(fmt.Sprintf("Created %d Python tools", len(pythonTools)))
+
+	// init Kali Linux sandbox and tools
+	util.LogMessage("Creating Kali Linux sandbox...")
+	kaliSb := NewKaliSandbox(ctx)
+	//defer kaliSb.Cleanup(ctx)
+
+	util.LogMessage("Creating Kali information gathering tools...")
+	kaliTools := NewKaliCommandLineTool(ctx, NewKillableOperator(kaliSb), jobs)
+	util.LogMessage(fmt.Sprintf("Created %d Kali tools", len(kaliTools)))
+
+	// Combine all tools
+	allTools := append(pythonTools, kaliTools...)
+	util.LogMessage(fmt.Sprintf("Total tools available: %d", len(allTools)))
+	return allTools
+}
+
 func BindTools(ctx context.Context, cm model.ToolCallingChatModel, tools []tool.BaseTool) model.ToolCallingChatModel {
 	infos := make([]*schema.ToolInfo, 0, len(tools))
 	for _, t := range tools {
@@ -0,0 +1,318 @@
+package tools
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"gogogajeto/util"
+
+	"github.com/cloudwego/eino-ext/components/tool/commandline"
+)
+
+// JobStatus is the state of a background job
+type JobStatus string
+
+const (
+	JobRunning   JobStatus = "running"
+	JobFinished  JobStatus = "finished"  // The command exited, see ExitCode
+	JobCancelled JobStatus = "cancelled" // Killed by Cancel
+	JobTimedOut  JobStatus = "timed_out" // Killed after JobConfig.MaxDuration
+)
+
+var (
+	ErrJobNotFound   = errors.New("job not found")
+	ErrJobNotRunning = errors.New("job is not running")
+	ErrJobLimit      = errors.New("too many background jobs running")
+)
+
+// jobOutputLimit caps the output read from a job at once, in bytes
+const jobOutputLimit = 64 << 10
+
+// Job is a command running in the background of a sandbox. It outlives the
+// tool call that started it and the sandbox timeout of a single command.
+type Job struct {
+	ID         string     `json:"id"`
+	Owner      string     `json:"sessionId,omitempty"` // The session that started the job
+	Tool       string     `json:"tool"`
+	Target     string     `json:"target,omitempty"`
+	Command    string     `json:"command"`
+	Status     JobStatus  `json:"status"`
+	ExitCode   *int       `json:"exitCode,omitempty"`
+	StartedAt  time.Time  `json:"startedAt"`
+	FinishedAt *time.Time `json:"finishedAt,omitempty"`
+
+	op   commandline.Operator
+	path string        // Prefix of the job's files in the sandbox
+	stop chan struct{} // Closed when the job ends
+}
+
+// JobConfig sets up a JobManager
+type JobConfig struct {
+	MaxRunning   int           // Jobs running at once, 0 for no limit
+	MaxDuration  time.Duration // Jobs running longer are killed, 0 for no limit
+	PollInterval time.Duration // How often running jobs are checked, 5 seconds by default
+	Retention    time.Duration // Ended jobs are dropped this long after they ended, 0 keeps them until Forget
+	OnFinish     func(Job)     // Called once when a job ends, in any way
+}
+
+// JobManager starts and tracks the background jobs of all sessions. Jobs are
+// kept in memory only, ended jobs until JobConfig.Retention passed.
+type JobManager struct {
+	config  JobConfig
+	mu      sync.Mutex
+	jobs    map[string]*Job
+	counter atomic.Int64
+}
+
+func NewJobManager(config JobConfig) *JobManager {
+	if config.PollInterval <= 0 {
+		config.PollInterval = 5 * time.Second
+	}
+	return &JobManager{config: config, jobs: make(map[string]*Job)}
+}
+
+type jobOwnerKey struct{}
+
+// WithJobOwner has the jobs started by a run belong to owner, usually the
+// session ID. The job tools of the run only see the jobs of their owner.
+func WithJobOwner(ctx context.Context, owner string) context.Context {
+	return context.WithValue(ctx, jobOwnerKey{}, owner)
+}
+
+func jobOwner(ctx context.Context) string {
+	owner, _ := ctx.Value(jobOwnerKey{}).(string)
+	return owner
+}
+
+// Start runs command in the background of op. The command is detached in a
+// new session of its own, with its output, PID and exit code in files next to
+// each other, so neither the end of the tool call nor the sandbox timeout
+// stops it.
+func (m *JobManager) Start(ctx context.Context, op commandline.Operator, tool, target, command string) (Job, error) {
+	m.mu.Lock()
+	m.prune()
+	if m.config.MaxRunning > 0 && m.running() >= m.config.MaxRunning {
+		m.mu.Unlock()
+		return Job{}, fmt.Errorf("%w, %d is the limit", ErrJobLimit, m.config.MaxRunning)
+	}
+	id := fmt.Sprintf("job-%d", m.counter.Add(1))
+	job := &Job{
+		ID:        id,
+		Owner:     jobOwner(ctx),
+		Tool:      tool,
+		Target:    target,
+		Command:   command,
+		Status:    JobRunning,
+		StartedAt: time.Now(),
+		op:        op,
+		path:      runPrefix + "-" + id,
+		stop:      make(chan struct{}),
+	}
+	m.jobs[id] = job
+	m.mu.Unlock()
+
+	script := fmt.Sprintf("echo $$ > %s.pid; ( %s ) > %s.out 2>&1; echo $? > %s.exit", job.path, command, job.path, job.path)
+	detached := fmt.Sprintf("setsid sh -c %s > /dev/null 2>&1 < /dev/null &", shellQuote(script))
+	if _, err := op.RunCommand(ctx, detached); err != nil {
+		m.mu.Lock()
+		delete(m.jobs, id)
+		m.mu.Unlock()
+		return Job{}, fmt.Errorf("failed to start job: %w", err)
+	}
+
+	util.LogMessage(fmt.Sprintf("Started background %s %s: %s", tool, id, command))
+	go m.watch(job)
+	return m.snapshot(job), nil
+}
+
+// running counts the running jobs, m.mu must be held
+func (m *JobManager) running() int {
+	n := 0
+	for _, job := range m.jobs {
+		if job.Status == JobRunning {
+			n++
+		}
+	}
+	return n
+}
+
+// prune drops the jobs that ended longer than the retention period ago, m.mu
+// must be held
+func (m *JobManager) prune() {
+	if m.config.Retention <= 0 {
+		return
+	}
+	for id, job := range m.jobs {
+		if job.FinishedAt != nil && time.Since(*job.FinishedAt) > m.config.Retention {
+			delete(m.jobs, id)
+		}
+	}
+}
+
+// watch polls the exit code of a job until it ends
+func (m *JobManager) watch(job *Job) {
+	ticker := time.NewTicker(m.config.PollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-job.stop:
+			return
+		case <-ticker.C:
+		}
+
+		if m.config.MaxDuration > 0 && time.Since(job.StartedAt) > m.config.MaxDuration {
+			util.LogMessage(fmt.Sprintf("Background job %s ran longer than %s, killing it", job.ID, m.config.MaxDuration))
+			m.kill(job)
+			m.finish(job, JobTimedOut, nil)
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		out, err := job.op.RunCommand(ctx, fmt.Sprintf("cat %s.exit 2>/dev/null || true", job.path))
+		cancel()
+		if err != nil {
+			util.LogMessage(fmt.Sprintf("Failed to check background job %s: %v", job.ID, err))
+			continue
+		}
+		if code, err := strconv.Atoi(strings.TrimSpace(out)); err == nil {
+			m.finish(job, JobFinished, &code)
+			return
+		}
+	}
+}
+
+// finish ends a running job, and reports whether it was running
+func (m *JobManager) finish(job *Job, status JobStatus, exitCode *int) bool {
+	m.mu.Lock()
+	if job.Status != JobRunning {
+		m.mu.Unlock()
+		return false
+	}
+	now := time.Now()
+	job.Status = status
+	job.ExitCode = exitCode
+	job.FinishedAt = &now
+	close(job.stop)
+	snapshot := *job
+	m.mu.Unlock()
+
+	util.LogMessage(fmt.Sprintf("Background job %s %s", job.ID, status))
+	if m.config.OnFinish != nil {
+		m.config.OnFinish(snapshot)
+	}
+	return true
+}
+
+// kill kills the process group of a job
+func (m *JobManager) kill(job *Job) {
+	// dash, the sh of the sandboxes, takes no -- before the process group
+	kill := fmt.Sprintf("pid=$(cat %s.pid 2>/dev/null) && kill -9 -$pid 2>/dev/null; true", job.path)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	if _, err := job.op.RunCommand(ctx, kill); err != nil {
+		util.LogMessage(fmt.Sprintf("Failed to kill background job %s: %v", job.ID, err))
+	}
+}
+
+func (m *JobManager) snapshot(job *Job) Job {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return *job
+}
+
+// lookup returns the job with the ID if it belongs to owner. An empty owner
+// may see every job.
+func (m *JobManager) lookup(owner, id string) (*Job, error) {
+	m.mu.Lock()
+	m.prune()
+	job, ok := m.jobs[id]
+	m.mu.Unlock()
+	if !ok || owner != "" && job.Owner != owner {
+		return nil, fmt.Errorf("%w: %s", ErrJobNotFound, id)
+	}
+	return job, nil
+}
+
+// Get returns a job of owner
+func (m *JobManager) Get(owner, id string) (Job, error) {
+	job, err := m.lookup(owner, id)
+	if err != nil {
+		return Job{}, err
+	}
+	return m.snapshot(job), nil
+}
+
+// List returns the jobs of owner, oldest first
+func (m *JobManager) List(owner string) []Job {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.prune()
+	list := []Job{}
+	for _, job := range m.jobs {
+		if owner == "" || job.Owner == owner {
+			list = append(list, *job)
+		}
+	}
+	slices.SortFunc(list, func(a, b Job) int { return a.StartedAt.Compare(b.StartedAt) })
+	return list
+}
+
+// Output returns the last lines of a job's output, or all of it with lines 0.
+// Either way at most jobOutputLimit bytes are returned.
+func (m *JobManager) Output(ctx context.Context, owner, id string, lines int) (string, error) {
+	job, err := m.lookup(owner, id)
+	if err != nil {
+		return "", err
+	}
+	command := fmt.Sprintf("tail -c %d %s.out 2>/dev/null || true", jobOutputLimit, job.path)
+	if lines > 0 {
+		command = fmt.Sprintf("tail -n %d %s.out 2>/dev/null | tail -c %d || true", lines, job.path, jobOutputLimit)
+	}
+	return job.op.RunCommand(ctx, command)
+}
+
+// fullOutput returns the whole output of a job, for output that is parsed
+// as a whole like nmap XML
+func (m *JobManager) fullOutput(ctx context.Context, job *Job) (string, error) {
+	return job.op.RunCommand(ctx, fmt.Sprintf("cat %s.out 2>/dev/null || true", job.path))
+}
+
+// Cancel kills a running job of owner
+func (m *JobManager) Cancel(owner, id string) (Job, error) {
+	job, err := m.lookup(owner, id)
+	if err != nil {
+		return Job{}, err
+	}
+	if m.snapshot(job).Status != JobRunning {
+		return Job{}, fmt.Errorf("%w: %s", ErrJobNotRunning, id)
+	}
+	m.kill(job)
+	if !m.finish(job, JobCancelled, nil) {
+		return Job{}, fmt.Errorf("%w: %s", ErrJobNotRunning, id)
+	}
+	return m.snapshot(job), nil
+}
+
+// Forget cancels the running jobs of owner and drops all of them, e.g. when
+// the session is deleted
+func (m *JobManager) Forget(owner string) {
+	for _, job := range m.List(owner) {
+		if job.Status == JobRunning {
+			m.Cancel(owner, job.ID)
+		}
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for id, job := range m.jobs {
+		if job.Owner == owner {
+			delete(m.jobs, id)
+		}
+	}
+}
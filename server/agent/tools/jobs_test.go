@@ -0,0 +1,241 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cloudwego/eino-ext/components/tool/commandline"
+	"github.com/cloudwego/eino/components/tool"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// shOperator runs the commands of a sandbox with the local sh
+type shOperator struct {
+	commandline.Operator
+}
+
+func (shOperator) RunCommand(ctx context.Context, command string) (string, error) {
+	out, err := exec.CommandContext(ctx, "sh", "-c", command).CombinedOutput()
+	return string(out), err
+}
+
+// newTestJobManager returns a job manager that polls quickly, with the job
+// files in a temporary directory and the ended jobs sent to the channel
+func newTestJobManager(t *testing.T, config JobConfig) (*JobManager, chan Job) {
+	if _, err := exec.LookPath("setsid"); err != nil {
+		t.Skip("setsid is not installed")
+	}
+	prefix := runPrefix
+	t.Cleanup(func() { runPrefix = prefix })
+	runPrefix = filepath.Join(t.TempDir(), "run")
+
+	finished := make(chan Job, 10)
+	config.PollInterval = 10 * time.Millisecond
+	config.OnFinish = func(job Job) { finished <- job }
+	m := NewJobManager(config)
+	t.Cleanup(func() { m.Forget("alice"); m.Forget("bob") })
+	return m, finished
+}
+
+func waitForJob(t *testing.T, finished chan Job) Job {
+	select {
+	case job := <-finished:
+		return job
+	case <-time.After(10 * time.Second):
+		t.Fatal("job did not end")
+		return Job{}
+	}
+}
+
+// processGroupAlive reports whether any process of the job's group still runs
+func processGroupAlive(job *Job) bool {
+	pid, err := os.ReadFile(job.path + ".pid")
+	if err != nil {
+		return false
+	}
+	return exec.Command("sh", "-c", "kill -0 -"+strings.TrimSpace(string(pid))).Run() == nil
+}
+
+func TestJobManager_StartAndFinish(t *testing.T) {
+	m, finished := newTestJobManager(t, JobConfig{})
+	ctx := WithJobOwner(context.Background(), "alice")
+
+	job, err := m.Start(ctx, shOperator{}, "nmap", "10.0.0.1", "echo scanning; echo done; exit 3")
+	require.NoError(t, err)
+	assert.Equal(t, "job-1", job.ID)
+	assert.Equal(t, "alice", job.Owner)
+	assert.Equal(t, JobRunning, job.Status)
+
+	// The exit code is read from the job's .exit file, even after exit
+	ended := waitForJob(t, finished)
+	assert.Equal(t, JobFinished, ended.Status)
+	require.NotNil(t, ended.ExitCode)
+	assert.Equal(t, 3, *ended.ExitCode)
+	assert.NotNil(t, ended.FinishedAt)
+
+	got, err := m.Get("alice", job.ID)
+	require.NoError(t, err)
+	assert.Equal(t, JobFinished, got.Status)
+
+	output, err := m.Output(ctx, "alice", job.ID, 0)
+	require.NoError(t, err)
+	assert.Equal(t, "scanning\ndone\n", output)
+	output, err = m.Output(ctx, "alice", job.ID, 1)
+	require.NoError(t, err)
+	assert.Equal(t, "done\n", output)
+
+	_, err = m.Cancel("alice", job.ID)
+	assert.ErrorIs(t, err, ErrJobNotRunning)
+}
+
+func TestJobManager_Owners(t *testing.T) {
+	m, _ := newTestJobManager(t, JobConfig{})
+	alice, err := m.Start(WithJobOwner(context.Background(), "alice"), shOperator{}, "nmap", "", "sleep 30")
+	require.NoError(t, err)
+	_, err = m.Start(WithJobOwner(context.Background(), "bob"), shOperator{}, "nikto", "", "sleep 30")
+	require.NoError(t, err)
+
+	// The jobs of other sessions cannot be seen, read or cancelled
+	assert.Len(t, m.List("alice"), 1)
+	assert.Len(t, m.List(""), 2)
+	_, err = m.Get("bob", alice.ID)
+	assert.ErrorIs(t, err, ErrJobNotFound)
+	_, err = m.Output(context.Background(), "bob", alice.ID, 0)
+	assert.ErrorIs(t, err, ErrJobNotFound)
+	_, err = m.Cancel("bob", alice.ID)
+	assert.ErrorIs(t, err, ErrJobNotFound)
+
+	m.Forget("alice")
+	assert.Empty(t, m.List("alice"))
+	assert.Len(t, m.List("bob"), 1)
+}
+
+func TestJobManager_CancelKillsProcessGroup(t *testing.T) {
+	m, finished := newTestJobManager(t, JobConfig{})
+	ctx := WithJobOwner(context.Background(), "alice")
+
+	// The job's children run in its process group, detached or not
+	started, err := m.Start(ctx, shOperator{}, "nmap", "", "sleep 30 & sleep 30; wait")
+	require.NoError(t, err)
+	job, err := m.lookup("alice", started.ID)
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		return processGroupAlive(job)
+	}, 5*time.Second, 10*time.Millisecond)
+
+	cancelled, err := m.Cancel("alice", started.ID)
+	require.NoError(t, err)
+	assert.Equal(t, JobCancelled, cancelled.Status)
+	assert.Nil(t, cancelled.ExitCode)
+	assert.Equal(t, JobCancelled, waitForJob(t, finished).Status)
+	assert.Eventually(t, func() bool { return !processGroupAlive(job) }, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestJobManager_Limit(t *testing.T) {
+	m, _ := newTestJobManager(t, JobConfig{MaxRunning: 1})
+	ctx := WithJobOwner(context.Background(), "alice")
+
+	first, err := m.Start(ctx, shOperator{}, "nmap", "", "sleep 30")
+	require.NoError(t, err)
+	_, err = m.Start(ctx, shOperator{}, "nmap", "", "sleep 30")
+	assert.ErrorIs(t, err, ErrJobLimit)
+
+	_, err = m.Cancel("alice", first.ID)
+	require.NoError(t, err)
+	_, err = m.Start(ctx, shOperator{}, "nmap", "", "sleep 30")
+	assert.NoError(t, err)
+}
+
+func TestJobManager_Timeout(t *testing.T) {
+	m, finished := newTestJobManager(t, JobConfig{MaxDuration: 50 * time.Millisecond})
+	_, err := m.Start(WithJobOwner(context.Background(), "alice"), shOperator{}, "nmap", "", "sleep 30")
+	require.NoError(t, err)
+
+	ended := waitForJob(t, finished)
+	assert.Equal(t, JobTimedOut, ended.Status)
+	assert.Nil(t, ended.ExitCode)
+}
+
+func TestJobManager_Retention(t *testing.T) {
+	m, finished := newTestJobManager(t, JobConfig{Retention: 50 * time.Millisecond})
+	ctx := WithJobOwner(context.Background(), "alice")
+
+	done, err := m.Start(ctx, shOperator{}, "nmap", "", "true")
+	require.NoError(t, err)
+	waitForJob(t, finished)
+	running, err := m.Start(ctx, shOperator{}, "nmap", "", "sleep 30")
+	require.NoError(t, err)
+	assert.Len(t, m.List("alice"), 2)
+
+	// Ended jobs are dropped after the retention period, running ones are kept
+	time.Sleep(100 * time.Millisecond)
+	jobs := m.List("alice")
+	require.Len(t, jobs, 1)
+	assert.Equal(t, running.ID, jobs[0].ID)
+	_, err = m.Get("alice", done.ID)
+	assert.ErrorIs(t, err, ErrJobNotFound)
+}
+
+func TestJobTools(t *testing.T) {
+	m, finished := newTestJobManager(t, JobConfig{})
+	ctx := WithJobOwner(context.Background(), "alice")
+	jobTools := map[string]tool.InvokableTool{}
+	for _, jobTool := range NewJobTools(m) {
+		info, err := jobTool.Info(ctx)
+		require.NoError(t, err)
+		jobTools[info.Name] = jobTool.(tool.InvokableTool)
+	}
+
+	done, err := m.Start(ctx, shOperator{}, "nikto", "", "echo one; echo two")
+	require.NoError(t, err)
+	waitForJob(t, finished)
+	running, err := m.Start(ctx, shOperator{}, "nmap", "", "sleep 30")
+	require.NoError(t, err)
+
+	// job_status lists the jobs of the session without a job_id
+	out, err := jobTools[jobStatusToolName].InvokableRun(ctx, `{}`)
+	require.NoError(t, err)
+	var list []Job
+	require.NoError(t, json.Unmarshal([]byte(out), &list))
+	assert.Len(t, list, 2)
+
+	out, err = jobTools[jobStatusToolName].InvokableRun(ctx, `{"job_id": "`+done.ID+`"}`)
+	require.NoError(t, err)
+	var status Job
+	require.NoError(t, json.Unmarshal([]byte(out), &status))
+	assert.Equal(t, JobFinished, status.Status)
+
+	out, err = jobTools[jobOutputToolName].InvokableRun(ctx, `{"job_id": "`+done.ID+`", "lines": 1}`)
+	require.NoError(t, err)
+	var output JobOutput
+	require.NoError(t, json.Unmarshal([]byte(out), &output))
+	assert.Equal(t, "two\n", output.Output)
+	assert.Equal(t, done.ID, output.Job.ID)
+
+	out, err = jobTools[jobCancelToolName].InvokableRun(ctx, `{"job_id": "`+running.ID+`"}`)
+	require.NoError(t, err)
+	require.NoError(t, json.Unmarshal([]byte(out), &status))
+	assert.Equal(t, JobCancelled, status.Status)
+
+	// Unknown jobs, jobs of other sessions and ended jobs are invalid arguments
+	for name, args := range map[string]string{
+		jobStatusToolName: `{"job_id": "job-99"}`,
+		jobOutputToolName: `{"job_id": "job-99"}`,
+		jobCancelToolName: `{"job_id": "` + done.ID + `"}`,
+	} {
+		out, err := jobTools[name].InvokableRun(ctx, args)
+		require.NoError(t, err, name)
+		assert.Contains(t, out, "invalid arguments for "+name, name)
+		assert.Contains(t, out, `"field":"job_id"`, name)
+	}
+	out, err = jobTools[jobStatusToolName].InvokableRun(WithJobOwner(context.Background(), "bob"), `{"job_id": "`+done.ID+`"}`)
+	require.NoError(t, err)
+	assert.Contains(t, out, "job not found")
+}
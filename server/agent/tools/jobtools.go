@@ -0,0 +1,159 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+
+	"github.com/cloudwego/eino/components/tool"
+	"github.com/cloudwego/eino/schema"
+)
+
+const (
+	jobStatusToolName = "job_status"
+	jobOutputToolName = "job_output"
+	jobCancelToolName = "job_cancel"
+)
+
+// jobParams are the arguments of the job tools
+type jobParams struct {
+	JobID string `json:"job_id"`
+	Lines int    `json:"lines,omitempty"`
+}
+
+var jobIDParam = &schema.ParameterInfo{
+	Type:     schema.String,
+	Desc:     "The ID of the job, as returned when it was started, e.g. \"job-3\"",
+	Required: true,
+}
+
+// NewJobTools returns the tools that check, read and cancel the background
+// jobs of a session
+func NewJobTools(jobs *JobManager) []tool.BaseTool {
+	return []tool.BaseTool{&jobStatusTool{jobs}, &jobOutputTool{jobs}, &jobCancelTool{jobs}}
+}
+
+// jobResult marshals a job tool result, errors about the job go back to the
+// model as invalid arguments
+func jobResult(toolName string, v any, err error) (string, error) {
+	if errors.Is(err, ErrJobNotFound) || errors.Is(err, ErrJobNotRunning) {
+		return InvalidArguments(toolName, []ArgumentError{{Field: "job_id", Message: err.Error()}}), nil
+	}
+	if err != nil {
+		return "", err
+	}
+	out, err := json.Marshal(v)
+	return string(out), err
+}
+
+type jobStatusTool struct {
+	jobs *JobManager
+}
+
+func jobStatusParamsInfo() map[string]*schema.ParameterInfo {
+	return map[string]*schema.ParameterInfo{
+		"job_id": {
+			Type: schema.String,
+			Desc: "The ID of the job, omit it to list all jobs of the session",
+		},
+	}
+}
+
+func (t *jobStatusTool) Info(ctx context.Context) (*schema.ToolInfo, error) {
+	return &schema.ToolInfo{
+		Name: jobStatusToolName,
+		Desc: "Show the status of a background job started with kali_info_gathering and \"background\": true: " +
+			"running, finished with its exit code, cancelled or timed_out. Without a job_id it lists all jobs of the session.",
+		ParamsOneOf: schema.NewParamsOneOfByParams(jobStatusParamsInfo()),
+	}, nil
+}
+
+func (t *jobStatusTool) InvokableRun(ctx context.Context, argumentsInJSON string, opts ...tool.Option) (string, error) {
+	var params jobParams
+	if errs := ValidateArguments(jobStatusParamsInfo(), argumentsInJSON, &params); len(errs) > 0 {
+		return InvalidArguments(jobStatusToolName, errs), nil
+	}
+	if params.JobID == "" {
+		return jobResult(jobStatusToolName, t.jobs.List(jobOwner(ctx)), nil)
+	}
+	job, err := t.jobs.Get(jobOwner(ctx), params.JobID)
+	return jobResult(jobStatusToolName, job, err)
+}
+
+type jobOutputTool struct {
+	jobs *JobManager
+}
+
+func jobOutputParamsInfo() map[string]*schema.ParameterInfo {
+	return map[string]*schema.ParameterInfo{
+		"job_id": jobIDParam,
+		"lines": {
+			Type: schema.Integer,
+			Desc: "Only return the last lines of the output, e.g. 50 to follow a running job. All output up to 64 KB by default",
+		},
+	}
+}
+
+func (t *jobOutputTool) Info(ctx context.Context) (*schema.ToolInfo, error) {
+	return &schema.ToolInfo{
+		Name: jobOutputToolName,
+		Desc: "Read the output of a background job, while it runs or after it ended. " +
+			"The output of a finished nmap job comes back as a JSON summary like a foreground scan.",
+		ParamsOneOf: schema.NewParamsOneOfByParams(jobOutputParamsInfo()),
+	}, nil
+}
+
+// JobOutput is the output of a job with the job's state when it was read
+type JobOutput struct {
+	Job    Job    `json:"job"`
+	Output string `json:"output"`
+}
+
+func (t *jobOutputTool) InvokableRun(ctx context.Context, argumentsInJSON string, opts ...tool.Option) (string, error) {
+	var params jobParams
+	if errs := ValidateArguments(jobOutputParamsInfo(), argumentsInJSON, &params); len(errs) > 0 {
+		return InvalidArguments(jobOutputToolName, errs), nil
+	}
+	job, err := t.jobs.lookup(jobOwner(ctx), params.JobID)
+	if err != nil {
+		return jobResult(jobOutputToolName, nil, err)
+	}
+
+	// Finished nmap jobs are summarized like nmap calls in the foreground
+	snapshot := t.jobs.snapshot(job)
+	if snapshot.Tool == "nmap" && snapshot.Status == JobFinished && params.Lines <= 0 {
+		output, err := t.jobs.fullOutput(ctx, job)
+		if err != nil {
+			return "", err
+		}
+		return nmapResult(ctx, output), nil
+	}
+
+	output, err := t.jobs.Output(ctx, jobOwner(ctx), params.JobID, params.Lines)
+	return jobResult(jobOutputToolName, JobOutput{Job: snapshot, Output: output}, err)
+}
+
+type jobCancelTool struct {
+	jobs *JobManager
+}
+
+func jobCancelParamsInfo() map[string]*schema.ParameterInfo {
+	return map[string]*schema.ParameterInfo{"job_id": jobIDParam}
+}
+
+func (t *jobCancelTool) Info(ctx context.Context) (*schema.ToolInfo, error) {
+	return &schema.ToolInfo{
+		Name:        jobCancelToolName,
+		Desc:        "Cancel a running background job. Its output so far can still be read with job_output.",
+		ParamsOneOf: schema.NewParamsOneOfByParams(jobCancelParamsInfo()),
+	}, nil
+}
+
+func (t *jobCancelTool) InvokableRun(ctx context.Context, argumentsInJSON string, opts ...tool.Option) (string, error) {
+	var params jobParams
+	if errs := ValidateArguments(jobCancelParamsInfo(), argumentsInJSON, &params); len(errs) > 0 {
+		return InvalidArguments(jobCancelToolName, errs), nil
+	}
+	job, err := t.jobs.Cancel(jobOwner(ctx), params.JobID)
+	return jobResult(jobCancelToolName, job, err)
+}
@@ -3,7 +3,9 @@ package tools
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"gogogajeto/agent/scope"
 	"gogogajeto/util"
 	"log"
 	"strings"
@@ -77,125 +79,124 @@ var AvailableWordlists = map[string]string{
 // KaliInfoGatheringTool implements a Kali Linux information gathering tool
 type KaliInfoGatheringTool struct {
 	sandbox commandline.Operator
+	jobs    *JobManager // Runs the calls with background set, nil to run all calls in the foreground
 }
 
-func NewKaliInfoGatheringTool(ctx context.Context, sb commandline.Operator) *KaliInfoGatheringTool {
+func NewKaliInfoGatheringTool(ctx context.Context, sb commandline.Operator, jobs *JobManager) *KaliInfoGatheringTool {
 	return &KaliInfoGatheringTool{
 		sandbox: sb,
+		jobs:    jobs,
 	}
 }
 
-func (k *KaliInfoGatheringTool) Info(ctx context.Context) (*schema.ToolInfo, error) {
-	// Create description with available tools
-	var toolsList []string
-	for tool, desc := range KaliInfoGatheringTools {
-		toolsList = append(toolsList, fmt.Sprintf("%s: %s", tool, desc))
-	}
+// kaliToolName is the name the model calls the tool by
+const kaliToolName = "kali_info_gathering"
 
-	// Create wordlists information
-	var wordlistsList []string
-	for wordlist, desc := range AvailableWordlists {
-		wordlistsList = append(wordlistsList, fmt.Sprintf("%s: %s", wordlist, desc))
-	}
+// kaliParams are the arguments of a kali_info_gathering call
+type kaliParams struct {
+	Tool    string `json:"tool"`
+	Target  string `json:"target"`
+	Options string `json:"options,omitempty"`
+
+	Background bool `json:"background,omitempty"`
+}
 
-	description := fmt.Sprintf(`Kali Linux Information Gathering Tool
+// kaliParamsInfo describes kaliParams to the model, the tool is one of
+// KaliInfoGatheringTools
+func kaliParamsInfo() map[string]*schema.ParameterInfo {
+	names := sortedKeys(KaliInfoGatheringTools)
+	toolsList := make([]string, 0, len(names))
+	for _, name := range names {
+		toolsList = append(toolsList, fmt.Sprintf("%s: %s", name, KaliInfoGatheringTools[name]))
+	}
 
-This tool provides access to information gathering and reconnaissance tools from Kali Linux.
+	wordlists := sortedKeys(AvailableWordlists)
+	wordlistsList := make([]string, 0, len(wordlists))
+	for _, wordlist := range wordlists {
+		wordlistsList = append(wordlistsList, fmt.Sprintf("/usr/share/wordlists/%s: %s", wordlist, AvailableWordlists[wordlist]))
+	}
 
-Available tools:
-%s
+	return map[string]*schema.ParameterInfo{
+		"tool": {
+			Type:     schema.String,
+			Desc:     "The information gathering tool to run:\n" + strings.Join(toolsList, "\n"),
+			Enum:     names,
+			Required: true,
+		},
+		"target": {
+			Type:     schema.String,
+			Desc:     "The target to investigate: IP address, CIDR range, domain or URL, e.g. \"192.168.1.1\", \"example.com\" or \"http://example.com\"",
+			Required: true,
+		},
+		"options": {
+			Type: schema.String,
+			Desc: "Additional command line options for the tool, e.g. \"-sV -sC\" for nmap or \"MX\" for dig. " +
+				"gobuster defaults to dir mode with the common wordlist, dirb to the common wordlist. " +
+				"Options are passed as arguments without a shell, so they must not contain shell metacharacters, " +
+				"and flags that write output files or run commands are refused. Available wordlists:\n" +
+				strings.Join(wordlistsList, "\n"),
+		},
+		"background": {
+			Type: schema.Boolean,
+			Desc: "Run the tool as a background job and return its job ID at once, instead of waiting up to 2 minutes for the result. " +
+				"Use it for long scans like full port nmap, large wordlists or nikto, then check the job with job_status and read it with job_output.",
+		},
+	}
+}
 
-Available wordlists:
-%s
+func (k *KaliInfoGatheringTool) Info(ctx context.Context) (*schema.ToolInfo, error) {
+	description := `Kali Linux Information Gathering Tool
 
-Usage:
-- tool: The information gathering tool to use (e.g., "nmap", "whois", "dig", "gobuster")
-- target: The target to investigate (IP address, domain, URL, etc.)
-- options: Additional command line options for the tool (optional)
+This tool provides access to information gathering and reconnaissance tools from Kali Linux.
 
 Examples:
 - {"tool": "nmap", "target": "192.168.1.1", "options": "-sV -sC"}
 - {"tool": "whois", "target": "example.com"}
 - {"tool": "dig", "target": "example.com", "options": "MX"}
 - {"tool": "nikto", "target": "http://example.com"}
-- {"tool": "gobuster", "target": "http://example.com"} (uses default dir mode + common wordlist)
 - {"tool": "gobuster", "target": "http://example.com", "options": "-w /usr/share/wordlists/dirb/big.txt"}
-- {"tool": "gobuster", "target": "http://example.com", "options": "dir -w /usr/share/wordlists/seclists/Discovery/Web-Content/common.txt"}
 - {"tool": "gobuster", "target": "example.com", "options": "dns -w /usr/share/wordlists/seclists/Discovery/DNS/bitquark-subdomains-top100000.txt"}
-- {"tool": "dirb", "target": "http://example.com"} (uses default common wordlist)
 - {"tool": "dirb", "target": "http://example.com", "options": "/usr/share/wordlists/dirb/big.txt"}
+- {"tool": "nmap", "target": "192.168.1.1", "options": "-p- -sV", "background": true}
 
-Security Notice: This tool is for authorized security testing only. Ensure you have permission before scanning any targets.`, strings.Join(toolsList, "\n"), strings.Join(wordlistsList, "\n"))
+nmap results come back as a JSON summary of the hosts with their ports, services, script output and OS guesses.
+
+Scope: This tool is for authorized security testing only. The target, and any host named in the options, is checked against the engagement scope of the session. Calls outside the scope are refused, do not retry them.`
 
 	return &schema.ToolInfo{
-		Name: "kali_info_gathering",
-		Desc: description,
+		Name:        kaliToolName,
+		Desc:        description,
+		ParamsOneOf: schema.NewParamsOneOfByParams(kaliParamsInfo()),
 	}, nil
 }
 
 func (k *KaliInfoGatheringTool) InvokableRun(ctx context.Context, argumentsInJSON string, opts ...tool.Option) (string, error) {
-	// Parse JSON arguments
-	var params struct {
-		Tool    string `json:"tool"`
-		Target  string `json:"target"`
-		Options string `json:"options,omitempty"`
-	}
-
-	if err := json.Unmarshal([]byte(argumentsInJSON), &params); err != nil {
-		return "", fmt.Errorf("failed to parse input parameters: %v", err)
+	// Arguments that break the schema go back to the model to be fixed
+	var params kaliParams
+	if errs := ValidateArguments(kaliParamsInfo(), argumentsInJSON, &params); len(errs) > 0 {
+		util.LogMessage("Invalid " + kaliToolName + " arguments: " + argumentsInJSON)
+		return InvalidArguments(kaliToolName, errs), nil
 	}
 
-	// Validate tool
-	if params.Tool == "" {
-		return "", fmt.Errorf("tool parameter is required")
+	// The command is built as argv and quoted, nothing in it reaches the shell
+	argv, errs := kaliArgv(params)
+	if len(errs) > 0 {
+		util.LogMessage("Rejected " + kaliToolName + " arguments: " + argumentsInJSON)
+		return InvalidArguments(kaliToolName, errs), nil
 	}
+	command := shellJoin(argv)
 
-	if params.Target == "" {
-		return "", fmt.Errorf("target parameter is required")
-	}
-
-	// Check if tool is in allowed list
-	if _, exists := KaliInfoGatheringTools[params.Tool]; !exists {
-		availableTools := make([]string, 0, len(KaliInfoGatheringTools))
-		for tool := range KaliInfoGatheringTools {
-			availableTools = append(availableTools, tool)
-		}
-		return "", fmt.Errorf("tool '%s' is not available. Available tools: %s", params.Tool, strings.Join(availableTools, ", "))
-	}
-
-	// Build command with special handling for different tools
-	var command string
-
-	switch params.Tool {
-	case "gobuster":
-		// Gobuster requires specific syntax: gobuster <mode> -u <url> [options]
-		// Default to 'dir' mode if no mode specified in options
-		if params.Options != "" {
-			// Check if mode is already specified in options
-			if strings.Contains(params.Options, "dir") || strings.Contains(params.Options, "dns") || strings.Contains(params.Options, "vhost") || strings.Contains(params.Options, "fuzz") {
-				command = fmt.Sprintf("%s %s -u %s", params.Tool, params.Options, params.Target)
-			} else {
-				// Default to dir mode and append options
-				command = fmt.Sprintf("%s dir -u %s %s", params.Tool, params.Target, params.Options)
+	// Targets outside the engagement scope of the session are refused
+	if s, ok := scope.FromContext(ctx); ok && !kaliLocalTools[params.Tool] {
+		var refused []string
+		for _, target := range kaliTargets(params) {
+			if err := s.Check(ctx, target); err != nil {
+				refused = append(refused, err.Error())
 			}
-		} else {
-			// Default gobuster dir scan with common wordlist
-			command = fmt.Sprintf("%s dir -u %s -w /usr/share/wordlists/dirb/common.txt", params.Tool, params.Target)
 		}
-	case "dirb":
-		// DIRB syntax: dirb <url> [wordlist] [options]
-		if params.Options != "" {
-			command = fmt.Sprintf("%s %s %s", params.Tool, params.Target, params.Options)
-		} else {
-			// Default dirb with common wordlist
-			command = fmt.Sprintf("%s %s /usr/share/wordlists/dirb/common.txt", params.Tool, params.Target)
-		}
-	default:
-		// Standard command format for other tools
-		if params.Options != "" {
-			command = fmt.Sprintf("%s %s %s", params.Tool, params.Options, params.Target)
-		} else {
-			command = fmt.Sprintf("%s %s", params.Tool, params.Target)
+		if len(refused) > 0 {
+			util.LogMessage("Refused out of scope " + kaliToolName + " call: " + argumentsInJSON)
+			return OutOfScope(kaliToolName, s, refused), nil
 		}
 	}
 
@@ -218,17 +219,53 @@ func (k *KaliInfoGatheringTool) InvokableRun(ctx context.Context, argumentsInJSO
 		util.LogMessage(fmt.Sprintf("Appending '|| true' to command for tool: %s", params.Tool))
 	}
 
+	// Long scans run as a job, the model polls it with the job tools
+	if params.Background {
+		return k.startJob(ctx, params, command)
+	}
+
 	// Execute command in Kali sandbox using RunCommand
 	result, err := k.sandbox.RunCommand(ctx, command)
 	if err != nil {
 		return "", fmt.Errorf("failed to execute %s: %v", params.Tool, err)
 	}
 
+	if params.Tool == "nmap" {
+		return nmapResult(ctx, result), nil
+	}
 	return fmt.Sprintf("Kali %s Results:\n%s", params.Tool, result), nil
 }
 
-// NewKaliCommandLineTool creates Kali Linux information gathering tools
-func NewKaliCommandLineTool(ctx context.Context, kaliSb commandline.Operator) []tool.BaseTool {
-	kaliTool := NewKaliInfoGatheringTool(ctx, kaliSb)
-	return []tool.BaseTool{kaliTool}
+// startJob runs the command of a call in the background and tells the model
+// the job ID
+func (k *KaliInfoGatheringTool) startJob(ctx context.Context, params kaliParams, command string) (string, error) {
+	if k.jobs == nil {
+		return InvalidArguments(kaliToolName, []ArgumentError{{Field: "background", Message: "background jobs are not available, run the tool without it"}}), nil
+	}
+	job, err := k.jobs.Start(ctx, k.sandbox, params.Tool, params.Target, command)
+	if errors.Is(err, ErrJobLimit) {
+		return InvalidArguments(kaliToolName, []ArgumentError{{Field: "background", Message: err.Error() + ", wait for a job to finish or cancel one"}}), nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to start %s: %v", params.Tool, err)
+	}
+
+	out, err := json.Marshal(struct {
+		Job     Job    `json:"job"`
+		Message string `json:"message"`
+	}{
+		Job:     job,
+		Message: "The job runs in the background. Check it with job_status and read its output with job_output.",
+	})
+	return string(out), err
+}
+
+// NewKaliCommandLineTool creates Kali Linux information gathering tools, and
+// the job tools if jobs is set
+func NewKaliCommandLineTool(ctx context.Context, kaliSb commandline.Operator, jobs *JobManager) []tool.BaseTool {
+	kaliTool := NewKaliInfoGatheringTool(ctx, kaliSb, jobs)
+	if jobs == nil {
+		return []tool.BaseTool{kaliTool}
+	}
+	return append([]tool.BaseTool{kaliTool}, NewJobTools(jobs)...)
 }
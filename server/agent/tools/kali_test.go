@@ -0,0 +1,72 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/netip"
+	"testing"
+
+	"gogogajeto/agent/scope"
+
+	"github.com/cloudwego/eino-ext/components/tool/commandline"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// recordingOperator records the commands run in the sandbox instead of
+// running them
+type recordingOperator struct {
+	commandline.Operator
+	commands []string
+}
+
+func (o *recordingOperator) RunCommand(ctx context.Context, command string) (string, error) {
+	o.commands = append(o.commands, command)
+	return "", nil
+}
+
+func TestKaliInfoGathering_RefusesOutOfScopeOptions(t *testing.T) {
+	resolve := scope.Resolve
+	t.Cleanup(func() { scope.Resolve = resolve })
+	scope.Resolve = func(ctx context.Context, host string) ([]netip.Addr, error) {
+		switch host {
+		case "localhost":
+			return []netip.Addr{netip.MustParseAddr("127.0.0.1")}, nil
+		case "lab.internal":
+			return []netip.Addr{netip.MustParseAddr("10.0.0.7")}, nil
+		}
+		return nil, errors.New("no such host")
+	}
+	ctx := scope.WithScope(context.Background(), &scope.Scope{CIDRs: []string{"10.0.0.0/24"}})
+
+	refused := []struct{ tool, options string }{
+		{"nmap", "-sn 10.0.0.0/8"},
+		{"nmap", "-sn 10.0.0.*"},
+		{"masscan", "-p 80 localhost"},
+		{"nc", "-zv intranet 80"},
+		{"ping", "-c 1 fe80::1"},
+		{"nmap", "-sS -S 192.0.2.1"},
+		{"nmap", "-sS -D 10.0.0.2,RND:5"},
+		{"curl", "--resolve lab.internal:80:192.0.2.7"},
+		{"curl", "--connect-to lab.internal:80:192.0.2.7:80"},
+		{"curl", "-sx 192.0.2.8:3128"},
+	}
+	for _, tt := range refused {
+		op := &recordingOperator{}
+		tool := NewKaliInfoGatheringTool(ctx, op, nil)
+		args, _ := json.Marshal(kaliParams{Tool: tt.tool, Target: "10.0.0.5", Options: tt.options})
+		out, err := tool.InvokableRun(ctx, string(args))
+		require.NoError(t, err)
+		assert.Contains(t, out, "targets outside the engagement scope", "%s %s", tt.tool, tt.options)
+		assert.Empty(t, op.commands, "%s %s", tt.tool, tt.options)
+	}
+
+	op := &recordingOperator{}
+	tool := NewKaliInfoGatheringTool(ctx, op, nil)
+	args, _ := json.Marshal(kaliParams{Tool: "curl", Target: "http://lab.internal/", Options: "-s --resolve lab.internal:80:10.0.0.7"})
+	out, err := tool.InvokableRun(ctx, string(args))
+	require.NoError(t, err)
+	assert.NotContains(t, out, "outside the engagement scope")
+	assert.Len(t, op.commands, 1)
+}
@@ -0,0 +1,238 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"strings"
+
+	"gogogajeto/util"
+)
+
+// nmapScriptOutputLimit caps the output of one NSE script in the summary, the
+// full output stays in the XML artifact
+const nmapScriptOutputLimit = 500
+
+// nmapRun is the part of the nmap XML output the summary is built from
+type nmapRun struct {
+	XMLName xml.Name   `xml:"nmaprun"`
+	Args    string     `xml:"args,attr"`
+	Hosts   []nmapHost `xml:"host"`
+	Stats   struct {
+		Finished struct {
+			Summary  string `xml:"summary,attr"`
+			ErrorMsg string `xml:"errormsg,attr"`
+		} `xml:"finished"`
+	} `xml:"runstats"`
+}
+
+type nmapHost struct {
+	Status struct {
+		State string `xml:"state,attr"`
+	} `xml:"status"`
+	Addresses []struct {
+		Addr     string `xml:"addr,attr"`
+		AddrType string `xml:"addrtype,attr"`
+		Vendor   string `xml:"vendor,attr"`
+	} `xml:"address"`
+	Hostnames []struct {
+		Name string `xml:"name,attr"`
+	} `xml:"hostnames>hostname"`
+	ExtraPorts []struct {
+		State string `xml:"state,attr"`
+		Count int    `xml:"count,attr"`
+	} `xml:"ports>extraports"`
+	Ports []struct {
+		Protocol string `xml:"protocol,attr"`
+		PortID   int    `xml:"portid,attr"`
+		State    struct {
+			State  string `xml:"state,attr"`
+			Reason string `xml:"reason,attr"`
+		} `xml:"state"`
+		Service struct {
+			Name      string `xml:"name,attr"`
+			Product   string `xml:"product,attr"`
+			Version   string `xml:"version,attr"`
+			ExtraInfo string `xml:"extrainfo,attr"`
+			Tunnel    string `xml:"tunnel,attr"`
+		} `xml:"service"`
+		Scripts []nmapScript `xml:"script"`
+	} `xml:"ports>port"`
+	HostScripts []nmapScript `xml:"hostscript>script"`
+	OSMatches   []struct {
+		Name     string `xml:"name,attr"`
+		Accuracy int    `xml:"accuracy,attr"`
+	} `xml:"os>osmatch"`
+}
+
+type nmapScript struct {
+	ID     string `xml:"id,attr"`
+	Output string `xml:"output,attr"`
+}
+
+// NmapSummary is the compact result of an nmap scan the model gets instead of
+// the raw output
+type NmapSummary struct {
+	Command  string            `json:"command"`
+	Hosts    []NmapHostSummary `json:"hosts"`
+	Summary  string            `json:"summary,omitempty"`  // nmap's own one line summary
+	Error    string            `json:"error,omitempty"`    // Why nmap stopped early
+	Artifact string            `json:"artifact,omitempty"` // Name of the raw XML artifact
+}
+
+type NmapHostSummary struct {
+	Address    string           `json:"address"`
+	MAC        string           `json:"mac,omitempty"`
+	Hostnames  []string         `json:"hostnames,omitempty"`
+	Status     string           `json:"status"`
+	Ports      []NmapPort       `json:"ports,omitempty"`
+	OtherPorts map[string]int   `json:"otherPorts,omitempty"` // Ports not listed, counted by state
+	Scripts    []NmapScriptItem `json:"scripts,omitempty"`    // Host scripts
+	OS         []NmapOSGuess    `json:"os,omitempty"`
+}
+
+type NmapPort struct {
+	Port     int              `json:"port"`
+	Protocol string           `json:"protocol"`
+	State    string           `json:"state"`
+	Reason   string           `json:"reason,omitempty"`
+	Service  string           `json:"service,omitempty"`
+	Version  string           `json:"version,omitempty"` // Product, version and extra info
+	Scripts  []NmapScriptItem `json:"scripts,omitempty"`
+}
+
+type NmapScriptItem struct {
+	ID     string `json:"id"`
+	Output string `json:"output"`
+}
+
+type NmapOSGuess struct {
+	Name     string `json:"name"`
+	Accuracy int    `json:"accuracy"`
+}
+
+// errNoNmapXML is returned by parseNmapXML for output without an nmaprun document
+var errNoNmapXML = errors.New("no nmap XML in the output")
+
+// parseNmapXML reads the XML nmap writes with -oX -. Anything before the
+// document, like warnings on stderr, is skipped.
+func parseNmapXML(output string) (*NmapSummary, string, error) {
+	start := strings.Index(output, "<?xml")
+	if start < 0 {
+		start = strings.Index(output, "<nmaprun")
+	}
+	if start < 0 {
+		return nil, "", errNoNmapXML
+	}
+	raw := output[start:]
+	if end := strings.LastIndex(raw, "</nmaprun>"); end >= 0 {
+		raw = raw[:end+len("</nmaprun>")]
+	}
+
+	var run nmapRun
+	if err := xml.Unmarshal([]byte(raw), &run); err != nil {
+		return nil, "", fmt.Errorf("failed to parse nmap XML: %w", err)
+	}
+
+	summary := &NmapSummary{
+		Command: run.Args,
+		Hosts:   []NmapHostSummary{},
+		Summary: run.Stats.Finished.Summary,
+		Error:   run.Stats.Finished.ErrorMsg,
+	}
+	for _, host := range run.Hosts {
+		summary.Hosts = append(summary.Hosts, summarizeNmapHost(host))
+	}
+	return summary, raw, nil
+}
+
+func summarizeNmapHost(host nmapHost) NmapHostSummary {
+	h := NmapHostSummary{Status: host.Status.State}
+	for _, address := range host.Addresses {
+		if address.AddrType == "mac" {
+			h.MAC = strings.TrimSpace(address.Addr + " " + address.Vendor)
+		} else if h.Address == "" {
+			h.Address = address.Addr
+		}
+	}
+	for _, hostname := range host.Hostnames {
+		h.Hostnames = append(h.Hostnames, hostname.Name)
+	}
+	for _, extra := range host.ExtraPorts {
+		if h.OtherPorts == nil {
+			h.OtherPorts = make(map[string]int)
+		}
+		h.OtherPorts[extra.State] += extra.Count
+	}
+	for _, port := range host.Ports {
+		service := port.Service.Name
+		if port.Service.Tunnel != "" && service != "" {
+			service = port.Service.Tunnel + "/" + service
+		}
+		version := strings.TrimSpace(port.Service.Product + " " + port.Service.Version)
+		if port.Service.ExtraInfo != "" {
+			version = strings.TrimSpace(version + " (" + port.Service.ExtraInfo + ")")
+		}
+		h.Ports = append(h.Ports, NmapPort{
+			Port:     port.PortID,
+			Protocol: port.Protocol,
+			State:    port.State.State,
+			Reason:   port.State.Reason,
+			Service:  service,
+			Version:  version,
+			Scripts:  summarizeNmapScripts(port.Scripts),
+		})
+	}
+	h.Scripts = summarizeNmapScripts(host.HostScripts)
+	for _, match := range host.OSMatches {
+		h.OS = append(h.OS, NmapOSGuess{Name: match.Name, Accuracy: match.Accuracy})
+	}
+	return h
+}
+
+func summarizeNmapScripts(scripts []nmapScript) []NmapScriptItem {
+	var items []NmapScriptItem
+	for _, script := range scripts {
+		output := strings.TrimSpace(script.Output)
+		if len(output) > nmapScriptOutputLimit {
+			output = output[:nmapScriptOutputLimit] + "... (truncated, see the XML artifact)"
+		}
+		items = append(items, NmapScriptItem{ID: script.ID, Output: output})
+	}
+	return items
+}
+
+// nmapResult turns the output of an nmap run into the summary the model gets
+// and keeps the raw XML and the summary as artifacts of the session. Output that is not
+// nmap XML, e.g. a usage error, is returned as it is.
+func nmapResult(ctx context.Context, output string) string {
+	summary, raw, err := parseNmapXML(output)
+	if err != nil {
+		util.LogMessage("Returning raw nmap output: " + err.Error())
+		return "Kali nmap Results:\n" + output
+	}
+
+	// The summary is kept next to the XML, for the API
+	name := artifactName(ctx, "nmap")
+	saved, err := saveArtifact(ctx, name+".xml", []byte(raw))
+	if err != nil {
+		util.LogMessage("Failed to save nmap artifact " + name + ".xml: " + err.Error())
+	}
+	if saved {
+		summary.Artifact = name + ".xml"
+	}
+
+	out, err := json.Marshal(summary)
+	if err != nil {
+		return "Kali nmap Results:\n" + output
+	}
+	if saved {
+		if _, err := saveArtifact(ctx, name+".json", out); err != nil {
+			util.LogMessage("Failed to save nmap artifact " + name + ".json: " + err.Error())
+		}
+	}
+	util.LogMessage(fmt.Sprintf("Parsed nmap XML: %d hosts", len(summary.Hosts)))
+	return "Kali nmap Results:\n" + string(out)
+}
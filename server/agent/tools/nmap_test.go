@@ -0,0 +1,166 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// nmapMultipleHosts is the output of a scan of two hosts that are up and one
+// that is down, with a warning on stderr before the XML
+const nmapMultipleHosts = `Warning: 10.0.0.3 giving up on port because retransmission cap hit (10).
+<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE nmaprun>
+<nmaprun scanner="nmap" args="nmap -oX - -sV 10.0.0.1-3" start="1760000000" version="7.94">
+<host><status state="up" reason="arp-response"/>
+<address addr="10.0.0.1" addrtype="ipv4"/>
+<address addr="00:11:22:33:44:55" addrtype="mac" vendor="Acme"/>
+<hostnames><hostname name="gw.lab.internal" type="PTR"/></hostnames>
+<ports><extraports state="closed" count="997"/>
+<port protocol="tcp" portid="22"><state state="open" reason="syn-ack"/><service name="ssh" product="OpenSSH" version="9.6p1" extrainfo="Ubuntu Linux; protocol 2.0"/></port>
+<port protocol="tcp" portid="443"><state state="open" reason="syn-ack"/><service name="http" product="nginx" tunnel="ssl"/></port>
+</ports>
+<os><osmatch name="Linux 5.0 - 5.14" accuracy="96"/></os>
+</host>
+<host><status state="up" reason="echo-reply"/>
+<address addr="10.0.0.2" addrtype="ipv4"/>
+<ports><extraports state="filtered" count="999"/>
+<port protocol="udp" portid="53"><state state="open|filtered" reason="no-response"/><service name="domain"/></port>
+</ports>
+</host>
+<host><status state="down" reason="no-response"/>
+<address addr="10.0.0.3" addrtype="ipv4"/>
+</host>
+<runstats><finished time="1760000042" summary="Nmap done; 3 IP addresses (2 hosts up) scanned in 42.00 seconds" exit="success"/></runstats>
+</nmaprun>
+`
+
+// nmapScriptOutput has NSE output on a port and on the host
+const nmapScriptOutput = `<?xml version="1.0" encoding="UTF-8"?>
+<nmaprun args="nmap -oX - -sC 10.0.0.5">
+<host><status state="up"/>
+<address addr="10.0.0.5" addrtype="ipv4"/>
+<ports>
+<port protocol="tcp" portid="80"><state state="open" reason="syn-ack"/><service name="http"/>
+<script id="http-title" output="  Acme Portal  "/>
+<script id="http-headers" output="` + "LONG" + `"/>
+</port>
+</ports>
+<hostscript><script id="smb-os-discovery" output="OS: Windows Server 2019"/></hostscript>
+</host>
+<runstats><finished summary="Nmap done; 1 IP address (1 host up) scanned in 5.00 seconds"/></runstats>
+</nmaprun>
+`
+
+// nmapHostDown is a scan of a single host that did not answer
+const nmapHostDown = `<?xml version="1.0" encoding="UTF-8"?>
+<nmaprun args="nmap -oX - 10.0.0.9">
+<host><status state="down" reason="no-response"/><address addr="10.0.0.9" addrtype="ipv4"/></host>
+<runstats><finished summary="Nmap done; 1 IP address (0 hosts up) scanned in 3.05 seconds"/></runstats>
+</nmaprun>
+`
+
+func TestParseNmapXML_MultipleHosts(t *testing.T) {
+	summary, raw, err := parseNmapXML(nmapMultipleHosts)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(raw, "<?xml"), "the warning before the XML is dropped")
+	assert.True(t, strings.HasSuffix(raw, "</nmaprun>"))
+	assert.Equal(t, "nmap -oX - -sV 10.0.0.1-3", summary.Command)
+	assert.Equal(t, "Nmap done; 3 IP addresses (2 hosts up) scanned in 42.00 seconds", summary.Summary)
+	require.Len(t, summary.Hosts, 3)
+
+	gateway := summary.Hosts[0]
+	assert.Equal(t, "10.0.0.1", gateway.Address)
+	assert.Equal(t, "00:11:22:33:44:55 Acme", gateway.MAC)
+	assert.Equal(t, []string{"gw.lab.internal"}, gateway.Hostnames)
+	assert.Equal(t, "up", gateway.Status)
+	assert.Equal(t, map[string]int{"closed": 997}, gateway.OtherPorts)
+	assert.Equal(t, []NmapPort{
+		{Port: 22, Protocol: "tcp", State: "open", Reason: "syn-ack", Service: "ssh", Version: "OpenSSH 9.6p1 (Ubuntu Linux; protocol 2.0)"},
+		{Port: 443, Protocol: "tcp", State: "open", Reason: "syn-ack", Service: "ssl/http", Version: "nginx"},
+	}, gateway.Ports)
+	assert.Equal(t, []NmapOSGuess{{Name: "Linux 5.0 - 5.14", Accuracy: 96}}, gateway.OS)
+
+	assert.Equal(t, "open|filtered", summary.Hosts[1].Ports[0].State)
+	assert.Equal(t, "udp", summary.Hosts[1].Ports[0].Protocol)
+	assert.Equal(t, NmapHostSummary{Address: "10.0.0.3", Status: "down"}, summary.Hosts[2])
+}
+
+func TestParseNmapXML_HostDown(t *testing.T) {
+	summary, _, err := parseNmapXML(nmapHostDown)
+	require.NoError(t, err)
+	require.Len(t, summary.Hosts, 1)
+	assert.Equal(t, "down", summary.Hosts[0].Status)
+	assert.Empty(t, summary.Hosts[0].Ports)
+	assert.Contains(t, summary.Summary, "0 hosts up")
+}
+
+func TestParseNmapXML_Scripts(t *testing.T) {
+	long := strings.Repeat("x", nmapScriptOutputLimit+100)
+	summary, _, err := parseNmapXML(strings.Replace(nmapScriptOutput, "LONG", long, 1))
+	require.NoError(t, err)
+	require.Len(t, summary.Hosts, 1)
+	host := summary.Hosts[0]
+
+	require.Len(t, host.Ports, 1)
+	scripts := host.Ports[0].Scripts
+	require.Len(t, scripts, 2)
+	assert.Equal(t, NmapScriptItem{ID: "http-title", Output: "Acme Portal"}, scripts[0])
+	assert.Equal(t, "http-headers", scripts[1].ID)
+	assert.True(t, strings.HasPrefix(scripts[1].Output, long[:nmapScriptOutputLimit]))
+	assert.Contains(t, scripts[1].Output, "truncated, see the XML artifact")
+	assert.Equal(t, []NmapScriptItem{{ID: "smb-os-discovery", Output: "OS: Windows Server 2019"}}, host.Scripts)
+}
+
+func TestNmapResult_RawOutput(t *testing.T) {
+	truncated := nmapMultipleHosts[:strings.Index(nmapMultipleHosts, "<host><status state=\"down\"")]
+	for name, output := range map[string]string{
+		"usage error": "nmap: unrecognized option '--bogus'\nSee the output of nmap -h for a summary of options.\n",
+		"truncated":   truncated,
+		"malformed":   strings.Replace(nmapHostDown, "</host>", "</hots>", 1),
+	} {
+		saved := false
+		ctx := WithArtifactSaver(context.Background(), func(ctx context.Context, name string, data []byte) error {
+			saved = true
+			return nil
+		})
+		assert.Equal(t, "Kali nmap Results:\n"+output, nmapResult(ctx, output), name)
+		assert.False(t, saved, name)
+	}
+}
+
+func TestNmapResult_Artifacts(t *testing.T) {
+	var mu sync.Mutex
+	artifacts := map[string][]byte{}
+	ctx := WithArtifactSaver(context.Background(), func(ctx context.Context, name string, data []byte) error {
+		mu.Lock()
+		defer mu.Unlock()
+		artifacts[name] = data
+		return nil
+	})
+
+	// Parallel scans in the same second keep their own artifacts
+	const scans = 8
+	results := make([]string, scans)
+	var wg sync.WaitGroup
+	for i := range scans {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i] = nmapResult(ctx, nmapHostDown)
+		}()
+	}
+	wg.Wait()
+	assert.Len(t, artifacts, 2*scans)
+
+	var summary NmapSummary
+	require.NoError(t, json.Unmarshal([]byte(strings.TrimPrefix(results[0], "Kali nmap Results:\n")), &summary))
+	require.NotEmpty(t, summary.Artifact)
+	assert.Equal(t, strings.TrimSpace(nmapHostDown[strings.Index(nmapHostDown, "<?xml"):]), string(artifacts[summary.Artifact]))
+	assert.Contains(t, artifacts, strings.TrimSuffix(summary.Artifact, ".xml")+".json")
+}
@@ -0,0 +1,63 @@
+package tools
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"gogogajeto/util"
+
+	"github.com/cloudwego/eino-ext/components/tool/commandline"
+)
+
+// KillableOperator runs every command of a sandbox in its own process group
+// and kills that group when the context of RunCommand is cancelled. Without
+// it a cancelled docker exec leaves the command running in the container
+// until it finishes or the sandbox timeout hits.
+type KillableOperator struct {
+	commandline.Operator
+}
+
+func NewKillableOperator(op commandline.Operator) *KillableOperator {
+	return &KillableOperator{Operator: op}
+}
+
+// runPrefix keeps the PID files of different server runs apart
+var runPrefix = fmt.Sprintf("/tmp/gadget-run-%d", time.Now().UnixNano())
+var runCounter atomic.Int64
+
+func (o *KillableOperator) RunCommand(ctx context.Context, command string) (string, error) {
+	pidFile := fmt.Sprintf("%s-%d.pid", runPrefix, runCounter.Add(1))
+	// setsid makes the inner shell lead a new process group, its PID is the group ID
+	wrapped := fmt.Sprintf("setsid -w sh -c %s; status=$?; rm -f %s; exit $status",
+		shellQuote(fmt.Sprintf("echo $$ > %s; %s", pidFile, command)), pidFile)
+
+	type result struct {
+		out string
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		out, err := o.Operator.RunCommand(ctx, wrapped)
+		done <- result{out, err}
+	}()
+
+	select {
+	case r := <-done:
+		return r.out, r.err
+	case <-ctx.Done():
+		util.LogMessage("Command cancelled, killing it: " + command)
+		kill := fmt.Sprintf("pid=$(cat %s 2>/dev/null) && kill -9 -- -$pid; rm -f %s", pidFile, pidFile)
+		if _, err := o.Operator.RunCommand(context.WithoutCancel(ctx), kill); err != nil {
+			util.LogMessage("Failed to kill cancelled command: " + err.Error())
+		}
+		return "", ctx.Err()
+	}
+}
+
+// shellQuote quotes s as a single sh argument
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
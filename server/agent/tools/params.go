@@ -0,0 +1,146 @@
+package tools
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+
+	"gogogajeto/agent/scope"
+
+	"github.com/cloudwego/eino/schema"
+)
+
+// ArgumentError is one way the arguments of a tool call break the tool's
+// parameter schema
+type ArgumentError struct {
+	Field   string `json:"field,omitempty"` // Empty when the arguments as a whole are wrong
+	Message string `json:"message"`
+}
+
+// ValidateArguments checks the JSON arguments of a tool call against the
+// tool's parameters and decodes them into v if they fit. Required strings
+// must not be empty.
+func ValidateArguments(params map[string]*schema.ParameterInfo, arguments string, v any) []ArgumentError {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(arguments), &fields); err != nil || fields == nil {
+		return []ArgumentError{{Message: "arguments must be a JSON object"}}
+	}
+
+	var errs []ArgumentError
+	for _, name := range sortedKeys(params) {
+		param := params[name]
+		raw, ok := fields[name]
+		if !ok || string(raw) == "null" {
+			if param.Required {
+				errs = append(errs, ArgumentError{Field: name, Message: "is required"})
+			}
+			continue
+		}
+		errs = append(errs, validateValue(name, param, raw)...)
+	}
+	for _, name := range sortedKeys(fields) {
+		if _, ok := params[name]; !ok {
+			errs = append(errs, ArgumentError{Field: name, Message: "is not a parameter of this tool"})
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+
+	if err := json.Unmarshal([]byte(arguments), v); err != nil {
+		return []ArgumentError{{Message: err.Error()}}
+	}
+	return nil
+}
+
+func validateValue(field string, param *schema.ParameterInfo, raw json.RawMessage) []ArgumentError {
+	invalid := func(format string, args ...any) []ArgumentError {
+		return []ArgumentError{{Field: field, Message: fmt.Sprintf(format, args...)}}
+	}
+
+	switch param.Type {
+	case schema.String:
+		var s string
+		if json.Unmarshal(raw, &s) != nil {
+			return invalid("must be a string")
+		}
+		if param.Required && strings.TrimSpace(s) == "" {
+			return invalid("must not be empty")
+		}
+		if len(param.Enum) > 0 && !slices.Contains(param.Enum, s) {
+			return invalid("must be one of %s, got %q", strings.Join(param.Enum, ", "), s)
+		}
+	case schema.Integer:
+		var n int64
+		if json.Unmarshal(raw, &n) != nil {
+			return invalid("must be an integer")
+		}
+	case schema.Number:
+		var n float64
+		if json.Unmarshal(raw, &n) != nil {
+			return invalid("must be a number")
+		}
+	case schema.Boolean:
+		var b bool
+		if json.Unmarshal(raw, &b) != nil {
+			return invalid("must be true or false")
+		}
+	case schema.Array:
+		var items []json.RawMessage
+		if json.Unmarshal(raw, &items) != nil {
+			return invalid("must be an array")
+		}
+		if param.ElemInfo != nil {
+			var errs []ArgumentError
+			for i, item := range items {
+				errs = append(errs, validateValue(fmt.Sprintf("%s[%d]", field, i), param.ElemInfo, item)...)
+			}
+			return errs
+		}
+	case schema.Object:
+		var fields map[string]json.RawMessage
+		if json.Unmarshal(raw, &fields) != nil || fields == nil {
+			return invalid("must be an object")
+		}
+	}
+	return nil
+}
+
+// InvalidArguments is the result of a tool call whose arguments break the
+// tool's schema. It goes back to the model instead of failing the run, so the
+// model can correct the call.
+func InvalidArguments(toolName string, errs []ArgumentError) string {
+	out, _ := json.Marshal(struct {
+		Error  string          `json:"error"`
+		Errors []ArgumentError `json:"errors"`
+	}{
+		Error:  "invalid arguments for " + toolName + ", fix them and call the tool again",
+		Errors: errs,
+	})
+	return string(out)
+}
+
+// OutOfScope is the result of a tool call with targets outside the engagement
+// scope. It tells the model why and what the scope is.
+func OutOfScope(toolName string, s *scope.Scope, refused []string) string {
+	out, _ := json.Marshal(struct {
+		Error   string       `json:"error"`
+		Refused []string     `json:"refused"`
+		Scope   *scope.Scope `json:"scope"`
+	}{
+		Error:   "refused to run " + toolName + ": targets outside the engagement scope, do not retry them",
+		Refused: refused,
+		Scope:   s,
+	})
+	return string(out)
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	return keys
+}
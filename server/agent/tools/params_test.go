@@ -0,0 +1,110 @@
+package tools
+
+import (
+	"encoding/json"
+	"testing"
+
+	"gogogajeto/agent/scope"
+
+	"github.com/cloudwego/eino/schema"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testParams covers every parameter type ValidateArguments checks
+func testParams() map[string]*schema.ParameterInfo {
+	return map[string]*schema.ParameterInfo{
+		"tool":    {Type: schema.String, Required: true, Enum: []string{"nmap", "dig"}},
+		"target":  {Type: schema.String, Required: true},
+		"lines":   {Type: schema.Integer},
+		"ratio":   {Type: schema.Number},
+		"verbose": {Type: schema.Boolean},
+		"ports":   {Type: schema.Array, ElemInfo: &schema.ParameterInfo{Type: schema.Integer}},
+		"extra":   {Type: schema.Object},
+	}
+}
+
+type testArgs struct {
+	Tool    string         `json:"tool"`
+	Target  string         `json:"target"`
+	Lines   int            `json:"lines"`
+	Ratio   float64        `json:"ratio"`
+	Verbose bool           `json:"verbose"`
+	Ports   []int          `json:"ports"`
+	Extra   map[string]any `json:"extra"`
+}
+
+func TestValidateArguments_Rejects(t *testing.T) {
+	tests := []struct {
+		name, arguments string
+		errs            []ArgumentError
+	}{
+		{"malformed JSON", `{"tool": "nmap",`, []ArgumentError{{Message: "arguments must be a JSON object"}}},
+		{"not an object", `["nmap"]`, []ArgumentError{{Message: "arguments must be a JSON object"}}},
+		{"null", `null`, []ArgumentError{{Message: "arguments must be a JSON object"}}},
+		{"missing required", `{"tool": "nmap"}`, []ArgumentError{{Field: "target", Message: "is required"}}},
+		{"null required", `{"tool": "nmap", "target": null}`, []ArgumentError{{Field: "target", Message: "is required"}}},
+		{"empty required", `{"tool": "nmap", "target": "  "}`, []ArgumentError{{Field: "target", Message: "must not be empty"}}},
+		{"wrong type string", `{"tool": "nmap", "target": 10}`, []ArgumentError{{Field: "target", Message: "must be a string"}}},
+		{"wrong type integer", `{"tool": "nmap", "target": "a", "lines": "50"}`, []ArgumentError{{Field: "lines", Message: "must be an integer"}}},
+		{"fraction for integer", `{"tool": "nmap", "target": "a", "lines": 1.5}`, []ArgumentError{{Field: "lines", Message: "must be an integer"}}},
+		{"wrong type number", `{"tool": "nmap", "target": "a", "ratio": "half"}`, []ArgumentError{{Field: "ratio", Message: "must be a number"}}},
+		{"wrong type boolean", `{"tool": "nmap", "target": "a", "verbose": "yes"}`, []ArgumentError{{Field: "verbose", Message: "must be true or false"}}},
+		{"wrong type array", `{"tool": "nmap", "target": "a", "ports": 80}`, []ArgumentError{{Field: "ports", Message: "must be an array"}}},
+		{"wrong item type", `{"tool": "nmap", "target": "a", "ports": [80, "443"]}`, []ArgumentError{{Field: "ports[1]", Message: "must be an integer"}}},
+		{"wrong type object", `{"tool": "nmap", "target": "a", "extra": []}`, []ArgumentError{{Field: "extra", Message: "must be an object"}}},
+		{"outside the enum", `{"tool": "nikto", "target": "a"}`, []ArgumentError{{Field: "tool", Message: `must be one of nmap, dig, got "nikto"`}}},
+		{"unknown field", `{"tool": "nmap", "target": "a", "flags": "-sV"}`, []ArgumentError{{Field: "flags", Message: "is not a parameter of this tool"}}},
+		{"several errors sorted by field", `{"tool": "nikto", "lines": "x", "zzz": 1}`, []ArgumentError{
+			{Field: "lines", Message: "must be an integer"},
+			{Field: "target", Message: "is required"},
+			{Field: "tool", Message: `must be one of nmap, dig, got "nikto"`},
+			{Field: "zzz", Message: "is not a parameter of this tool"},
+		}},
+	}
+	for _, tt := range tests {
+		var args testArgs
+		errs := ValidateArguments(testParams(), tt.arguments, &args)
+		assert.Equal(t, tt.errs, errs, tt.name)
+		assert.Equal(t, testArgs{}, args, "%s: nothing is decoded", tt.name)
+	}
+}
+
+func TestValidateArguments_Decodes(t *testing.T) {
+	var args testArgs
+	errs := ValidateArguments(testParams(), `{"tool": "dig", "target": "example.com", "lines": 5, "ratio": 0.5,
+		"verbose": true, "ports": [80, 443], "extra": {"a": 1}}`, &args)
+	require.Empty(t, errs)
+	assert.Equal(t, testArgs{
+		Tool: "dig", Target: "example.com", Lines: 5, Ratio: 0.5, Verbose: true, Ports: []int{80, 443}, Extra: map[string]any{"a": 1.0},
+	}, args)
+}
+
+func TestInvalidArguments(t *testing.T) {
+	out := InvalidArguments("kali_info_gathering", []ArgumentError{
+		{Field: "tool", Message: `must be one of nmap, dig, got "nikto"`},
+		{Message: "arguments must be a JSON object"},
+	})
+	assert.JSONEq(t, `{
+		"error": "invalid arguments for kali_info_gathering, fix them and call the tool again",
+		"errors": [
+			{"field": "tool", "message": "must be one of nmap, dig, got \"nikto\""},
+			{"message": "arguments must be a JSON object"}
+		]
+	}`, out)
+}
+
+func TestOutOfScope(t *testing.T) {
+	s := &scope.Scope{CIDRs: []string{"10.0.0.0/24"}}
+	out := OutOfScope("kali_info_gathering", s, []string{"out of scope: 10.1.0.1 is not inside an allowed CIDR"})
+
+	var result struct {
+		Error   string       `json:"error"`
+		Refused []string     `json:"refused"`
+		Scope   *scope.Scope `json:"scope"`
+	}
+	require.NoError(t, json.Unmarshal([]byte(out), &result))
+	assert.Equal(t, "refused to run kali_info_gathering: targets outside the engagement scope, do not retry them", result.Error)
+	assert.Equal(t, []string{"out of scope: 10.1.0.1 is not inside an allowed CIDR"}, result.Refused)
+	assert.Equal(t, s, result.Scope)
+}
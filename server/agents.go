@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	manus "gogogajeto/agent/manus"
+	react "gogogajeto/agent/react"
+	"gogogajeto/agent/tools"
+
+	"github.com/cloudwego/eino/components/tool"
+	"github.com/cloudwego/eino/compose"
+)
+
+// Agents a session can run on. The kind is fixed when the session is created,
+// because a checkpoint can only be continued by the graph that wrote it.
+const (
+	AgentManus   = "manus"   // Chat model and tools wired by hand, with tool approval
+	AgentReAct   = "react"   // eino's ReAct loop
+	AgentPlanner = "planner" // manus with a task list that is planned and updated along the way
+)
+
+var agents = make(map[string]compose.Runnable[string, string]) // Agents by agentKey
+
+// toolConcurrency caps the tool calls running at once across all sessions,
+// set by TOOL_CONCURRENCY and TOOL_CONCURRENCY_LIMITS
+var toolConcurrency = manus.ConcurrencyLimits{
+	Global:  4,
+	PerTool: map[string]int{"masscan": 1},
+}
+
+// agentKey names the agent of a kind for a profile
+func agentKey(kind, profile string) string {
+	return kind + "/" + profile
+}
+
+// createAgents builds every agent kind for every profile on one shared set of
+// tools, which also share the concurrency limits and the background jobs.
+// Each profile gets the subset of tools it enables.
+func createAgents(store compose.CheckPointStore) error {
+	allTools := manus.LimitConcurrency(tools.NewAgentTools(context.Background(), jobs), toolConcurrency)
+	for name, profile := range profiles {
+		profileTools, err := selectTools(allTools, profile.Tools)
+		if err != nil {
+			return fmt.Errorf("profile %s: %w", name, err)
+		}
+		agents[agentKey(AgentManus, name)] = manus.CreateAgent(store, profileTools, profile.Model)
+		agents[agentKey(AgentReAct, name)] = react.CreateAgent(store, profileTools, profile.Model)
+		agents[agentKey(AgentPlanner, name)] = manus.CreatePlannerAgent(store, profileTools, profile.Model)
+	}
+	return nil
+}
+
+// selectTools returns the tools with the given names in the order of all,
+// or all of them without names
+func selectTools(all []tool.BaseTool, names []string) ([]tool.BaseTool, error) {
+	if len(names) == 0 {
+		return all, nil
+	}
+	wanted := make(map[string]bool, len(names))
+	for _, name := range names {
+		wanted[name] = true
+	}
+
+	var selected []tool.BaseTool
+	for _, t := range all {
+		info, err := t.Info(context.Background())
+		if err != nil {
+			return nil, err
+		}
+		if wanted[info.Name] {
+			selected = append(selected, t)
+			delete(wanted, info.Name)
+		}
+	}
+	for name := range wanted {
+		return nil, fmt.Errorf("unknown tool %s", name)
+	}
+	return selected, nil
+}
+
+// validAgentKind reports whether kind names an agent, empty means manus
+func validAgentKind(kind string) bool {
+	switch kind {
+	case "", AgentManus, AgentReAct, AgentPlanner:
+		return true
+	}
+	return false
+}
+
+// agentKind returns the kind of agent the session runs on. Sessions from
+// before agents were selectable have no kind and run on manus.
+func agentKind(session *SessionInfo) string {
+	sessionMutex.RLock()
+	defer sessionMutex.RUnlock()
+	if session.Agent == "" {
+		return AgentManus
+	}
+	return session.Agent
+}
+
+// agentFor returns the agent the session runs on
+func agentFor(session *SessionInfo) compose.Runnable[string, string] {
+	return agentOf(agentKind(session), profileName(session))
+}
+
+// agentForID is agentFor by session ID, without touching the session's last access
+func agentForID(sessionID string) compose.Runnable[string, string] {
+	sessionMutex.RLock()
+	var kind, profile string
+	if session, ok := sessions[sessionID]; ok {
+		kind, profile = session.Agent, session.Profile
+	}
+	sessionMutex.RUnlock()
+	return agentOf(kind, profile)
+}
+
+// agentOf returns the agent of a kind for a profile, falling back to manus
+// and the default profile
+func agentOf(kind, profile string) compose.Runnable[string, string] {
+	if !validAgentKind(kind) || kind == "" {
+		kind = AgentManus
+	}
+	if _, ok := profiles[profile]; !ok {
+		profile = DefaultProfile
+	}
+	return agents[agentKey(kind, profile)]
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	manus "gogogajeto/agent/manus"
+	"gogogajeto/util"
+
+	"github.com/cloudwego/eino/compose"
+	"github.com/cloudwego/eino/schema"
+)
+
+const EventToolApprovalRequired = "tool.approval_required"
+
+var errApprovalPending = errors.New("session is waiting for tool call approval")
+var errNoApprovalPending = errors.New("session has no pending tool calls")
+var errApprovalUnsupported = errors.New("the react agent does not support tool approval")
+
+// toolApprovalDefault is the ToolApproval setting of new sessions, set by TOOL_APPROVAL=true
+var toolApprovalDefault bool
+
+// PendingApproval lists the tool calls the agent wants to run once approved
+type PendingApproval struct {
+	ToolCalls   []ToolCallInfo `json:"toolCalls"`
+	RequestedAt time.Time      `json:"requestedAt"`
+}
+
+// pendingApproval returns the tool calls the session is waiting on, if any
+func pendingApproval(session *SessionInfo) *PendingApproval {
+	sessionMutex.RLock()
+	defer sessionMutex.RUnlock()
+	return session.PendingApproval
+}
+
+// updatePendingApproval records whether the run stopped at the approval gate
+// and notifies the clients about new tool calls waiting for a decision
+func updatePendingApproval(ctx context.Context, session *SessionInfo, info *compose.InterruptInfo, history []*schema.Message) *PendingApproval {
+	var pending *PendingApproval
+	if manus.IsAwaitingApproval(info) && len(history) > 0 {
+		items := toHistoryItems(history[len(history)-1:])
+		pending = &PendingApproval{ToolCalls: items[0].ToolCalls, RequestedAt: time.Now()}
+	}
+
+	sessionMutex.Lock()
+	changed := (session.PendingApproval == nil) != (pending == nil)
+	session.PendingApproval = pending
+	sessionMutex.Unlock()
+	if changed {
+		saveSession(ctx, session)
+	}
+
+	if pending != nil {
+		util.LogMessage(fmt.Sprintf("Session %s: %d tool calls waiting for approval", session.SessionID, len(pending.ToolCalls)))
+		emitEvent(Event{Event: EventToolApprovalRequired, SessionID: session.SessionID, Data: pending})
+	}
+	return pending
+}
+
+// sessionApprovalHandler resolves the pending tool calls and continues the run, e.g.
+// POST /api/session/{id}/approval {"action": "deny", "reason": "production is out of scope"}
+func sessionApprovalHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	sessionID, _ := parseSessionPath(r.URL.Path)
+	session, exists := getSession(sessionID)
+	if !exists {
+		http.Error(w, "Session not found", http.StatusNotFound)
+		return
+	}
+
+	var decision manus.ApprovalDecision
+	if err := json.NewDecoder(r.Body).Decode(&decision); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	// The agent runs detached from the request like regular messages do
+	response, err := resolveApproval(context.Background(), session, &decision)
+	switch {
+	case errors.Is(err, errNoApprovalPending), errors.Is(err, errSessionBusy):
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// resolveApproval continues a run waiting at the approval gate with the
+// operator's decision
+func resolveApproval(ctx context.Context, session *SessionInfo, decision *manus.ApprovalDecision) (SessionResponse, error) {
+	ctx, release, err := beginSessionRun(ctx, session.SessionID)
+	if err != nil {
+		return SessionResponse{}, err
+	}
+	defer release()
+
+	pending := pendingApproval(session)
+	if pending == nil {
+		return SessionResponse{}, errNoApprovalPending
+	}
+	calls := make([]schema.ToolCall, len(pending.ToolCalls))
+	for i, tc := range pending.ToolCalls {
+		calls[i] = schema.ToolCall{ID: tc.ID}
+	}
+	if err := decision.Validate(calls); err != nil {
+		return SessionResponse{}, err
+	}
+
+	util.LogMessage(fmt.Sprintf("Session %s: tool calls %s", session.SessionID, decision.Action))
+
+	sessionMutex.RLock()
+	title := session.Title
+	sessionMutex.RUnlock()
+	return invokeAgent(manus.WithApprovalDecision(ctx, decision), session, "", title, 0, nil), nil
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+
+	"gogogajeto/util"
+)
+
+var artifactMutex = &sync.Mutex{} // Serializes read-modify-write of a session's artifacts
+
+// saveArtifact stores a file produced for the session under name, replacing an
+// existing artifact with the same name
+func saveArtifact(ctx context.Context, sessionID, name string, data []byte) error {
+	artifactMutex.Lock()
+	defer artifactMutex.Unlock()
+
+	artifacts, err := loadArtifacts(ctx, sessionID)
+	if err != nil {
+		return err
+	}
+	if artifacts == nil {
+		artifacts = make(map[string][]byte)
+	}
+	artifacts[name] = data
+	return storeArtifacts(ctx, sessionID, artifacts)
+}
+
+// loadArtifacts returns all artifacts of the session by name
+func loadArtifacts(ctx context.Context, sessionID string) (map[string][]byte, error) {
+	data, ok, err := artifactStore.Get(ctx, sessionID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read artifacts of session %s: %w", sessionID, err)
+	}
+	if !ok {
+		return nil, nil
+	}
+
+	var artifacts map[string][]byte
+	if err := json.Unmarshal(data, &artifacts); err != nil {
+		return nil, fmt.Errorf("failed to decode artifacts of session %s: %w", sessionID, err)
+	}
+	return artifacts, nil
+}
+
+// storeArtifacts replaces all artifacts of the session
+func storeArtifacts(ctx context.Context, sessionID string, artifacts map[string][]byte) error {
+	if len(artifacts) == 0 {
+		return artifactStore.Delete(ctx, sessionID)
+	}
+
+	data, err := json.Marshal(artifacts)
+	if err != nil {
+		return err
+	}
+	return artifactStore.Set(ctx, sessionID, data)
+}
+
+// removeArtifacts deletes all artifacts of the session
+func removeArtifacts(ctx context.Context, sessionID string) {
+	if err := artifactStore.Delete(ctx, sessionID); err != nil {
+		util.LogMessage(fmt.Sprintf("Failed to delete artifacts of session %s: %v", sessionID, err))
+	}
+}
+
+// ArtifactInfo describes an artifact of a session without its content
+type ArtifactInfo struct {
+	Name string `json:"name"`
+	Size int    `json:"size"`
+}
+
+// sessionArtifactsHandler lists the artifacts of a session, e.g.
+// GET /api/session/{id}/artifacts, or returns one with ?name=
+func sessionArtifactsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	sessionID, _ := parseSessionPath(r.URL.Path)
+	if _, exists := getSession(sessionID); !exists {
+		http.Error(w, "Session not found", http.StatusNotFound)
+		return
+	}
+
+	artifacts, err := loadArtifacts(r.Context(), sessionID)
+	if err != nil {
+		util.LogMessage(err.Error())
+		http.Error(w, "Failed to load artifacts", http.StatusInternalServerError)
+		return
+	}
+
+	if name := r.URL.Query().Get("name"); name != "" {
+		data, ok := artifacts[name]
+		if !ok {
+			http.Error(w, "Artifact not found", http.StatusNotFound)
+			return
+		}
+		switch path.Ext(name) {
+		case ".json":
+			w.Header().Set("Content-Type", "application/json")
+		case ".xml":
+			w.Header().Set("Content-Type", "application/xml")
+		default:
+			w.Header().Set("Content-Type", "application/octet-stream")
+		}
+		w.Write(data)
+		return
+	}
+
+	list := make([]ArtifactInfo, 0, len(artifacts))
+	for name, data := range artifacts {
+		list = append(list, ArtifactInfo{Name: name, Size: len(data)})
+	}
+	slices.SortFunc(list, func(a, b ArtifactInfo) int { return strings.Compare(a.Name, b.Name) })
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
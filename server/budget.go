@@ -0,0 +1,35 @@
+package main
+
+import (
+	manus "gogogajeto/agent/manus"
+)
+
+// maxStepBudget caps the step budget a session or request may ask for
+const maxStepBudget = 200
+
+// stepBudgetDefault is the step budget of sessions without their own, set by STEP_BUDGET
+var stepBudgetDefault = manus.DefaultStepBudget
+
+// validStepBudget checks a requested budget, 0 means the default
+func validStepBudget(budget int) bool {
+	return budget >= 0 && budget <= maxStepBudget
+}
+
+// resolveStepBudget picks the budget of a request: the requested one, else the
+// session's, else the profile's, else the server default
+func resolveStepBudget(session *SessionInfo, requested int) int {
+	sessionMutex.RLock()
+	budget := session.StepBudget
+	sessionMutex.RUnlock()
+
+	if requested > 0 {
+		budget = requested
+	}
+	if budget <= 0 {
+		budget = profileOf(session).StepBudget
+	}
+	if budget <= 0 {
+		budget = stepBudgetDefault
+	}
+	return min(budget, maxStepBudget)
+}
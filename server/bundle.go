@@ -0,0 +1,246 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	manus "gogogajeto/agent/manus"
+	"gogogajeto/util"
+
+	"github.com/google/uuid"
+)
+
+const (
+	bundleVersion = 1
+	maxBundleSize = 256 << 20 // Upper limit for imported bundles
+)
+
+var (
+	errSessionExists = errors.New("session already exists")
+	errInvalidBundle = errors.New("invalid session bundle")
+)
+
+// SessionBundle is a portable copy of a session. The checkpoint holds the raw
+// graph state and is what gets restored on import; the decoded history is
+// included so the bundle can be read without this server.
+type SessionBundle struct {
+	Version    int               `json:"version"`
+	ExportedAt time.Time         `json:"exportedAt"`
+	Session    SessionInfo       `json:"session"`
+	History    []HistoryItem     `json:"history"`
+	Checkpoint []byte            `json:"checkpoint,omitempty"` // base64 encoded in JSON
+	Artifacts  map[string][]byte `json:"artifacts,omitempty"`  // Files produced by tools, by name
+
+	RevisionCheckpoints map[int][]byte `json:"revisionCheckpoints,omitempty"` // Archived branches by revision
+}
+
+// sessionExportHandler returns the session as a bundle, e.g.
+// GET /api/session/{id}/export
+func sessionExportHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	sessionID, _ := parseSessionPath(r.URL.Path)
+	if _, exists := getSession(sessionID); !exists {
+		http.Error(w, "Session not found", http.StatusNotFound)
+		return
+	}
+
+	bundle, err := exportSession(r.Context(), sessionID)
+	if err != nil {
+		util.LogMessage("Export failed: " + err.Error())
+		http.Error(w, "Failed to export session", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="session-%s.json"`, sessionID))
+	json.NewEncoder(w).Encode(bundle)
+}
+
+// sessionImportHandler restores a bundle under a new session ID, or under the
+// ID it was exported with if keepId=true, e.g.
+// POST /api/session/import?keepId=true
+func sessionImportHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var bundle SessionBundle
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBundleSize)).Decode(&bundle); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	keepID := r.URL.Query().Get("keepId") == "true"
+	session, err := importSession(r.Context(), &bundle, keepID)
+	switch {
+	case errors.Is(err, errSessionExists):
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case errors.Is(err, errInvalidBundle):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case err != nil:
+		util.LogMessage("Import failed: " + err.Error())
+		http.Error(w, "Failed to import session", http.StatusInternalServerError)
+		return
+	}
+
+	sessionMutex.RLock()
+	defer sessionMutex.RUnlock()
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(session)
+}
+
+// exportSession collects everything stored for the session into a bundle
+func exportSession(ctx context.Context, sessionID string) (*SessionBundle, error) {
+	sessionMutex.RLock()
+	session, exists := sessions[sessionID]
+	var info SessionInfo
+	if exists {
+		info = *session
+	}
+	sessionMutex.RUnlock()
+	if !exists {
+		return nil, fmt.Errorf("session %s not found", sessionID)
+	}
+
+	bundle := &SessionBundle{
+		Version:    bundleVersion,
+		ExportedAt: time.Now(),
+		Session:    info,
+		History:    []HistoryItem{},
+	}
+
+	checkpoint, ok, err := checkpointStore.Get(ctx, sessionID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read checkpoint: %w", err)
+	}
+	if ok {
+		bundle.Checkpoint = checkpoint
+
+		state, _, err := manus.LoadState(ctx, agentForID(sessionID), checkpointStore, sessionID)
+		if err != nil {
+			return nil, err
+		}
+		if state != nil {
+			bundle.History = toHistoryItems(state.History)
+		}
+	}
+
+	for _, revision := range info.Revisions {
+		checkpoint, ok, err := checkpointStore.Get(ctx, revisionCheckpointID(sessionID, revision.Revision))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read revision %d: %w", revision.Revision, err)
+		}
+		if !ok {
+			continue
+		}
+		if bundle.RevisionCheckpoints == nil {
+			bundle.RevisionCheckpoints = make(map[int][]byte)
+		}
+		bundle.RevisionCheckpoints[revision.Revision] = checkpoint
+	}
+
+	if bundle.Artifacts, err = loadArtifacts(ctx, sessionID); err != nil {
+		return nil, err
+	}
+	return bundle, nil
+}
+
+// importSession restores the bundle as a session. The checkpoint is decoded
+// before the session is registered, so a bundle that does not fit the current
+// graph is rejected instead of failing on the next message.
+func importSession(ctx context.Context, bundle *SessionBundle, keepID bool) (*SessionInfo, error) {
+	if bundle.Version != bundleVersion {
+		return nil, fmt.Errorf("%w: unsupported version %d", errInvalidBundle, bundle.Version)
+	}
+
+	session := bundle.Session
+	if !validAgentKind(session.Agent) {
+		return nil, fmt.Errorf("%w: unknown agent %q", errInvalidBundle, session.Agent)
+	}
+	if !validProfile(session.Profile) {
+		return nil, fmt.Errorf("%w: unknown profile %q", errInvalidBundle, session.Profile)
+	}
+	if session.Scope != nil {
+		engagement, err := normalizeScope(session.Scope)
+		if err != nil {
+			return nil, fmt.Errorf("%w: invalid scope: %v", errInvalidBundle, err)
+		}
+		session.Scope = engagement
+	}
+	// Only the listed revisions are restored and removed with the session
+	listed := make(map[int]bool, len(session.Revisions))
+	for _, revision := range session.Revisions {
+		listed[revision.Revision] = true
+	}
+	for revision := range bundle.RevisionCheckpoints {
+		if !listed[revision] {
+			return nil, fmt.Errorf("%w: checkpoint of revision %d is not a revision of the session", errInvalidBundle, revision)
+		}
+	}
+	if keepID {
+		// Other IDs could name the checkpoints of another session, like its
+		// revisions, or not be routable
+		if id, err := uuid.Parse(session.SessionID); err != nil || id.String() != session.SessionID {
+			return nil, fmt.Errorf("%w: session ID %q is not a UUID", errInvalidBundle, session.SessionID)
+		}
+	} else {
+		session.SessionID = uuid.New().String()
+	}
+
+	// Reserve the ID so concurrent imports cannot claim it twice
+	sessionMutex.Lock()
+	if _, exists := sessions[session.SessionID]; exists {
+		sessionMutex.Unlock()
+		return nil, fmt.Errorf("%w: %s", errSessionExists, session.SessionID)
+	}
+	session.LastAccess = time.Now()
+	if session.CreatedAt.IsZero() {
+		session.CreatedAt = session.LastAccess
+	}
+	sessions[session.SessionID] = &session
+	sessionMutex.Unlock()
+
+	if err := restoreBundleData(ctx, session.SessionID, bundle); err != nil {
+		purgeSession(ctx, session.SessionID)
+		return nil, err
+	}
+	saveSession(ctx, &session)
+
+	util.LogMessage(fmt.Sprintf("Imported session %s (exported as %s)", session.SessionID, bundle.Session.SessionID))
+	return &session, nil
+}
+
+// restoreBundleData writes the checkpoint and artifacts of the bundle
+func restoreBundleData(ctx context.Context, sessionID string, bundle *SessionBundle) error {
+	if len(bundle.Checkpoint) > 0 {
+		if err := checkpointStore.Set(ctx, sessionID, bundle.Checkpoint); err != nil {
+			return fmt.Errorf("failed to write checkpoint: %w", err)
+		}
+		if _, _, err := manus.LoadState(ctx, agentForID(sessionID), checkpointStore, sessionID); err != nil {
+			return fmt.Errorf("%w: %v", errInvalidBundle, err)
+		}
+	}
+
+	for revision, checkpoint := range bundle.RevisionCheckpoints {
+		if err := checkpointStore.Set(ctx, revisionCheckpointID(sessionID, revision), checkpoint); err != nil {
+			return fmt.Errorf("failed to write revision %d: %w", revision, err)
+		}
+	}
+
+	if err := storeArtifacts(ctx, sessionID, bundle.Artifacts); err != nil {
+		return fmt.Errorf("failed to write artifacts: %w", err)
+	}
+	return nil
+}
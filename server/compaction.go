@@ -0,0 +1,25 @@
+package main
+
+import (
+	"gogogajeto/agent/common"
+	manus "gogogajeto/agent/manus"
+)
+
+// compactionThreshold is the estimated token count of the model input above
+// which older messages are summarized, set by COMPACTION_TOKENS. 0 disables it.
+var compactionThreshold = manus.DefaultCompactionThreshold
+
+// HistoryCompaction describes the summary that stands in for the first
+// Messages messages of a session's history when talking to the model. The
+// messages themselves stay in the history.
+type HistoryCompaction struct {
+	Messages int    `json:"messages"`
+	Summary  string `json:"summary"`
+}
+
+func historyCompaction(state *common.State) *HistoryCompaction {
+	if state.Compacted == 0 {
+		return nil
+	}
+	return &HistoryCompaction{Messages: state.Compacted, Summary: state.Summary}
+}
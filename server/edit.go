@@ -0,0 +1,228 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"gogogajeto/agent/common"
+	manus "gogogajeto/agent/manus"
+	"gogogajeto/util"
+
+	"github.com/cloudwego/eino/schema"
+)
+
+const (
+	EditModeInPlace = "inplace" // Continue the session with the edited branch
+	EditModeNew     = "new"     // Leave the session untouched and branch into a new one
+)
+
+// Revision is a branch of the conversation that was replaced by editing a
+// message in place. Its checkpoint is kept so the old history stays readable.
+type Revision struct {
+	Revision  int       `json:"revision"`
+	CreatedAt time.Time `json:"createdAt"` // When the branch was replaced
+	EditedAt  int       `json:"editedAt"`  // OrderID of the edited user message
+	Messages  int       `json:"messages"`  // Length of the replaced history
+}
+
+type EditRequest struct {
+	OrderID  *int   `json:"orderId"`
+	Message  string `json:"message"`
+	Mode     string `json:"mode,omitempty"`     // inplace (default) or new
+	MaxSteps int    `json:"maxSteps,omitempty"` // Step budget of the regenerated answer
+}
+
+type EditResponse struct {
+	SessionResponse
+	Revision *Revision `json:"revision,omitempty"` // The archived branch when editing in place
+}
+
+// revisionCheckpointID is the checkpoint ID an archived branch is stored under
+func revisionCheckpointID(sessionID string, revision int) string {
+	return fmt.Sprintf("%s@rev%d", sessionID, revision)
+}
+
+// hasRevision reports whether the session archived the given revision
+func hasRevision(session *SessionInfo, revision int) bool {
+	sessionMutex.RLock()
+	defer sessionMutex.RUnlock()
+	for _, r := range session.Revisions {
+		if r.Revision == revision {
+			return true
+		}
+	}
+	return false
+}
+
+// sessionEditHandler replaces a user message and lets the agent answer again, e.g.
+// POST /api/session/{id}/edit {"orderId": 3, "message": "scan 10.0.0.5 instead", "mode": "inplace"}
+func sessionEditHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	sessionID, _ := parseSessionPath(r.URL.Path)
+	session, exists := getSession(sessionID)
+	if !exists {
+		http.Error(w, "Session not found", http.StatusNotFound)
+		return
+	}
+
+	var req EditRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if req.OrderID == nil {
+		http.Error(w, "orderId is required", http.StatusBadRequest)
+		return
+	}
+	if req.Message == "" {
+		http.Error(w, "Message is required", http.StatusBadRequest)
+		return
+	}
+	if !validStepBudget(req.MaxSteps) {
+		http.Error(w, fmt.Sprintf("maxSteps must be between 0 and %d", maxStepBudget), http.StatusBadRequest)
+		return
+	}
+
+	// The agent runs detached from the request like regular messages do
+	ctx := context.Background()
+	var response EditResponse
+	var err error
+	switch req.Mode {
+	case "", EditModeInPlace:
+		response, err = editSessionInPlace(ctx, session, *req.OrderID, req.Message, req.MaxSteps)
+	case EditModeNew:
+		response, err = editSessionAsNew(ctx, sessionID, *req.OrderID, req.Message, req.MaxSteps)
+	default:
+		http.Error(w, "Invalid mode, use inplace or new", http.StatusBadRequest)
+		return
+	}
+
+	switch {
+	case errors.Is(err, manus.ErrInvalidHistoryPoint):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case errors.Is(err, errSessionBusy), errors.Is(err, errApprovalPending):
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case err != nil:
+		util.LogMessage("Edit failed: " + err.Error())
+		http.Error(w, "Failed to edit session", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// checkEditPoint verifies that the message at orderID can be replaced
+func checkEditPoint(history []*schema.Message, orderID int) error {
+	if orderID < 0 || orderID >= len(history) || history[orderID].Role != schema.User {
+		return fmt.Errorf("%w: message %d is not a user message", manus.ErrInvalidHistoryPoint, orderID)
+	}
+	return nil
+}
+
+// editSessionInPlace archives the current branch as a revision, cuts the
+// history before the user message at orderID and runs the agent with the
+// replacement message
+func editSessionInPlace(ctx context.Context, session *SessionInfo, orderID int, message string, maxSteps int) (EditResponse, error) {
+	sessionID := session.SessionID
+
+	ctx, release, err := beginSessionRun(ctx, sessionID)
+	if err != nil {
+		return EditResponse{}, err
+	}
+	defer release()
+
+	if pendingApproval(session) != nil {
+		return EditResponse{}, errApprovalPending
+	}
+
+	checkpoint, ok, err := checkpointStore.Get(ctx, sessionID)
+	if err != nil {
+		return EditResponse{}, err
+	}
+	if !ok {
+		return EditResponse{}, fmt.Errorf("%w: session has no history yet", manus.ErrInvalidHistoryPoint)
+	}
+
+	sessionMutex.RLock()
+	revision := Revision{Revision: 1, CreatedAt: time.Now(), EditedAt: orderID}
+	if n := len(session.Revisions); n > 0 {
+		revision.Revision = session.Revisions[n-1].Revision + 1
+	}
+	sessionMutex.RUnlock()
+
+	// Archive first, so the old branch survives even if the rewrite fails halfway
+	revisionID := revisionCheckpointID(sessionID, revision.Revision)
+	if err := checkpointStore.Set(ctx, revisionID, checkpoint); err != nil {
+		return EditResponse{}, fmt.Errorf("failed to archive revision: %w", err)
+	}
+
+	var messageCount int
+	err = manus.RewriteState(ctx, agentFor(session), checkpointStore, sessionID, sessionID, func(state *common.State) error {
+		if err := checkEditPoint(state.History, orderID); err != nil {
+			return err
+		}
+		history, err := manus.TruncateHistory(state.History, orderID-1)
+		if err != nil {
+			return err
+		}
+		revision.Messages = len(state.History)
+		state.History = history
+		messageCount = countUserMessages(history)
+		return nil
+	})
+	if err != nil {
+		checkpointStore.Delete(ctx, revisionID)
+		return EditResponse{}, err
+	}
+
+	sessionMutex.Lock()
+	session.Revisions = append(session.Revisions, revision)
+	session.MessageCount = messageCount
+	sessionMutex.Unlock()
+	saveSession(ctx, session)
+
+	util.LogMessage(fmt.Sprintf("Session %s: archived revision %d, regenerating from message %d", sessionID, revision.Revision, orderID))
+	return EditResponse{
+		SessionResponse: runAgentTurn(ctx, session, message, maxSteps, nil),
+		Revision:        &revision,
+	}, nil
+}
+
+// editSessionAsNew forks the session right before the user message at orderID
+// and runs the agent with the replacement message in the fork
+func editSessionAsNew(ctx context.Context, sessionID string, orderID int, message string, maxSteps int) (EditResponse, error) {
+	state, ok, err := manus.LoadState(ctx, agentForID(sessionID), checkpointStore, sessionID)
+	if err != nil {
+		return EditResponse{}, err
+	}
+	if !ok {
+		return EditResponse{}, fmt.Errorf("%w: session has no history yet", manus.ErrInvalidHistoryPoint)
+	}
+	if err := checkEditPoint(state.History, orderID); err != nil {
+		return EditResponse{}, err
+	}
+
+	child, err := forkSession(ctx, sessionID, orderID-1)
+	if err != nil {
+		return EditResponse{}, err
+	}
+
+	ctx, release, err := beginSessionRun(ctx, child.SessionID)
+	if err != nil {
+		return EditResponse{}, err
+	}
+	defer release()
+
+	return EditResponse{SessionResponse: runAgentTurn(ctx, child, message, maxSteps, nil)}, nil
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/json"
+	"time"
+
+	manus "gogogajeto/agent/manus"
+
+	"github.com/cloudwego/eino/schema"
+	"github.com/gorilla/websocket"
+)
+
+const (
+	EventSessionEvicted = "session.evicted"
+	EventMessageDelta   = "message.delta"
+	EventPlanUpdated    = "plan.updated"
+	EventRunCancelled   = "run.cancelled"
+	EventJobFinished    = "job.finished"
+)
+
+// Event is a server side notification pushed to the connected WebSocket
+// clients. Clients tell events apart from agent responses by the event field.
+type Event struct {
+	Event     string    `json:"event"`
+	SessionID string    `json:"sessionId,omitempty"`
+	Time      time.Time `json:"time"`
+	Data      any       `json:"data,omitempty"`
+}
+
+// MessageDelta is a piece of the assistant message currently generated. Step
+// counts the model calls of one request, a new step starts after tool calls.
+type MessageDelta struct {
+	Step    int    `json:"step"`
+	Content string `json:"content"`
+}
+
+// sessionClients are the WebSocket connections bound to each session. A
+// connection is bound to the sessions it sends messages or a cancel for, or
+// subscribes to, and only receives the events of those. Guarded by mutex.
+var sessionClients = make(map[string]map[*websocket.Conn]bool)
+
+// bindClient has the connection receive the events of the session
+func bindClient(conn *websocket.Conn, sessionID string) {
+	if sessionID == "" {
+		return
+	}
+	mutex.Lock()
+	defer mutex.Unlock()
+	if sessionClients[sessionID] == nil {
+		sessionClients[sessionID] = make(map[*websocket.Conn]bool)
+	}
+	sessionClients[sessionID][conn] = true
+}
+
+// unbindClient drops the bindings of a closed connection, mutex must be held
+func unbindClient(conn *websocket.Conn) {
+	for sessionID, conns := range sessionClients {
+		delete(conns, conn)
+		if len(conns) == 0 {
+			delete(sessionClients, sessionID)
+		}
+	}
+}
+
+// unbindSession drops the bindings of a removed session
+func unbindSession(sessionID string) {
+	mutex.Lock()
+	delete(sessionClients, sessionID)
+	mutex.Unlock()
+}
+
+// emitEvent sends the event to the WebSocket connections bound to its session
+func emitEvent(event Event) {
+	event.Time = time.Now()
+	b, err := json.Marshal(event)
+	if err != nil {
+		return
+	}
+
+	mutex.Lock()
+	for client := range sessionClients[event.SessionID] {
+		err := client.WriteMessage(websocket.TextMessage, b)
+		if err != nil {
+			client.Close()
+			delete(clients, client)
+			unbindClient(client)
+		}
+	}
+	mutex.Unlock()
+}
+
+// sendEvent sends the event to a single WebSocket client
+func sendEvent(conn *websocket.Conn, event Event) {
+	event.Time = time.Now()
+	b, err := json.Marshal(event)
+	if err != nil {
+		return
+	}
+
+	mutex.Lock()
+	conn.WriteMessage(websocket.TextMessage, b)
+	mutex.Unlock()
+}
+
+// sendDelta streams the assistant output of a session to one client
+func sendDelta(conn *websocket.Conn, sessionID string) manus.ChunkHandler {
+	return func(step int, chunk *schema.Message) {
+		if chunk.Content == "" {
+			return
+		}
+		sendEvent(conn, Event{
+			Event:     EventMessageDelta,
+			SessionID: sessionID,
+			Data:      MessageDelta{Step: step, Content: chunk.Content},
+		})
+	}
+}
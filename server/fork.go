@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"gogogajeto/agent/common"
+	manus "gogogajeto/agent/manus"
+	"gogogajeto/util"
+
+	"github.com/cloudwego/eino/schema"
+)
+
+// sessionForkHandler creates a new session from the history of an existing one,
+// e.g. POST /api/session/{id}/fork?at=12 keeps the messages up to OrderID 12.
+// Without at the whole history is copied.
+func sessionForkHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	parentID, _ := parseSessionPath(r.URL.Path)
+	if _, exists := getSession(parentID); !exists {
+		http.Error(w, "Session not found", http.StatusNotFound)
+		return
+	}
+
+	at := -1
+	if value := r.URL.Query().Get("at"); value != "" {
+		var err error
+		at, err = strconv.Atoi(value)
+		if err != nil || at < 0 {
+			http.Error(w, "Invalid fork point", http.StatusBadRequest)
+			return
+		}
+	}
+
+	child, err := forkSession(r.Context(), parentID, at)
+	if errors.Is(err, manus.ErrInvalidHistoryPoint) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if errors.Is(err, errApprovalPending) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		util.LogMessage("Fork failed: " + err.Error())
+		http.Error(w, "Failed to fork session", http.StatusInternalServerError)
+		return
+	}
+
+	sessionMutex.RLock()
+	defer sessionMutex.RUnlock()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(child)
+}
+
+// forkSession copies the parent's checkpoint into a new session, keeping the
+// history up to and including the message at OrderID at. A negative at keeps
+// the whole history.
+func forkSession(ctx context.Context, parentID string, at int) (*SessionInfo, error) {
+	// The checkpoint stops at the approval gate, a fork would inherit the calls
+	if parent, ok := getSession(parentID); ok && pendingApproval(parent) != nil {
+		return nil, errApprovalPending
+	}
+	if _, exists, err := checkpointStore.Get(ctx, parentID); err != nil {
+		return nil, err
+	} else if !exists {
+		return nil, fmt.Errorf("%w: session has no history yet", manus.ErrInvalidHistoryPoint)
+	}
+
+	child := createSession()
+
+	var messageCount int
+	// The child continues on the parent's agent, only that graph can read the checkpoint
+	err := manus.RewriteState(ctx, agentForID(parentID), checkpointStore, parentID, child.SessionID, func(state *common.State) error {
+		point := at
+		if point < 0 {
+			point = len(state.History) - 1
+		}
+		history, err := manus.TruncateHistory(state.History, point)
+		if err != nil {
+			return err
+		}
+		state.History = history
+		at = point
+		messageCount = countUserMessages(history)
+		return nil
+	})
+	if err != nil {
+		purgeSession(ctx, child.SessionID)
+		return nil, err
+	}
+
+	sessionMutex.Lock()
+	child.ParentSessionID = parentID
+	child.ForkedAt = &at
+	child.MessageCount = messageCount
+	if parent, ok := sessions[parentID]; ok {
+		child.Title = parent.Title
+		child.Notes = parent.Notes
+		child.Tags = append([]string{}, parent.Tags...)
+		child.Target = parent.Target
+		child.Scope = parent.Scope
+		child.Agent = parent.Agent
+		child.Profile = parent.Profile
+		child.ToolApproval = parent.ToolApproval
+		child.StepBudget = parent.StepBudget
+		child.VerifyClaims = parent.VerifyClaims
+	}
+	sessionMutex.Unlock()
+	saveSession(ctx, child)
+
+	util.LogMessage(fmt.Sprintf("Forked session %s from %s at message %d", child.SessionID, parentID, at))
+	return child, nil
+}
+
+// countUserMessages returns the number of messages the user sent
+func countUserMessages(history []*schema.Message) int {
+	count := 0
+	for _, msg := range history {
+		if msg.Role == schema.User {
+			count++
+		}
+	}
+	return count
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"gogogajeto/agent/tools"
+	"gogogajeto/util"
+)
+
+// jobs runs the background scans of all sessions, set up from JOB_LIMIT,
+// JOB_TIMEOUT and JOB_RETENTION
+var jobs *tools.JobManager
+
+// newJobManager creates the job manager, it pushes a job.finished event to the
+// connections of the job's session when a job ends
+func newJobManager(config tools.JobConfig) *tools.JobManager {
+	config.OnFinish = func(job tools.Job) {
+		emitEvent(Event{Event: EventJobFinished, SessionID: job.Owner, Data: job})
+	}
+	return tools.NewJobManager(config)
+}
+
+// sessionJobsHandler serves the background jobs of a session:
+//
+//	GET  /api/session/{id}/jobs                     list the jobs
+//	GET  /api/session/{id}/jobs/{jobId}             status of one job
+//	GET  /api/session/{id}/jobs/{jobId}/output      output, ?lines= for the last lines
+//	POST /api/session/{id}/jobs/{jobId}/cancel      cancel a running job, 409 if it ended
+func sessionJobsHandler(w http.ResponseWriter, r *http.Request) {
+	sessionID, action := parseSessionPath(r.URL.Path)
+	if _, exists := getSession(sessionID); !exists {
+		http.Error(w, "Session not found", http.StatusNotFound)
+		return
+	}
+	_, rest, _ := strings.Cut(action, "/")
+	jobID, operation, _ := strings.Cut(rest, "/")
+
+	var result any
+	var err error
+	switch {
+	case jobID == "" && r.Method == "GET":
+		result = jobs.List(sessionID)
+	case operation == "" && r.Method == "GET":
+		result, err = jobs.Get(sessionID, jobID)
+	case operation == "output" && r.Method == "GET":
+		lines, _ := strconv.Atoi(r.URL.Query().Get("lines"))
+		var job tools.Job
+		if job, err = jobs.Get(sessionID, jobID); err == nil {
+			var output string
+			output, err = jobs.Output(r.Context(), sessionID, jobID, lines)
+			result = tools.JobOutput{Job: job, Output: output}
+		}
+	case operation == "cancel" && r.Method == "POST":
+		result, err = jobs.Cancel(sessionID, jobID)
+	case operation == "" || operation == "output" || operation == "cancel":
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	default:
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+
+	switch {
+	case errors.Is(err, tools.ErrJobNotFound):
+		http.Error(w, "Job not found", http.StatusNotFound)
+		return
+	case errors.Is(err, tools.ErrJobNotRunning):
+		http.Error(w, "Job is not running", http.StatusConflict)
+		return
+	case err != nil:
+		util.LogMessage("Failed to read job " + jobID + ": " + err.Error())
+		http.Error(w, "Failed to read job", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
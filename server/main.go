@@ -3,7 +3,9 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
 	"os"
@@ -14,6 +16,8 @@ import (
 
 	"gogogajeto/agent/common"
 	manus "gogogajeto/agent/manus"
+	"gogogajeto/agent/scope"
+	"gogogajeto/agent/tools"
 	"gogogajeto/util"
 
 	"github.com/cloudwego/eino/compose"
@@ -30,15 +34,16 @@ var upgrader = websocket.Upgrader{
 }
 
 var clients = make(map[*websocket.Conn]bool) // Connected clients
-var broadcast = make(chan []byte)            // Broadcast channel
-var mutex = &sync.Mutex{}                    // Protect clients map
-
-var agent compose.Runnable[string, string] // The chat agent
+var broadcast = make(chan legacyMessage)     // Plain text messages for the default session
+var mutex = &sync.Mutex{}                    // Protect clients and sessionClients
 
 // Session management
 var sessions = make(map[string]*SessionInfo) // Active sessions
 var sessionMutex = &sync.RWMutex{}           // Protect sessions map
 
+// verifyClaimsDefault is the VerifyClaims setting of new sessions, set by VERIFY_CLAIMS=true
+var verifyClaimsDefault bool
+
 var registerTypesOnce sync.Once
 
 type AgentResult struct {
@@ -84,17 +89,71 @@ type SessionInfo struct {
 	CreatedAt    time.Time `json:"createdAt"`
 	LastAccess   time.Time `json:"lastAccess"`
 	MessageCount int       `json:"messageCount"`
+
+	// Descriptive metadata, set with PATCH /api/session/{id}
+	Title  string   `json:"title,omitempty"` // Generated from the first user message unless set
+	Notes  string   `json:"notes,omitempty"`
+	Tags   []string `json:"tags,omitempty"`
+	Target string   `json:"target,omitempty"` // Primary engagement target, e.g. a domain or CIDR
+
+	// Hosts the tools may touch, checked before every Kali tool call
+	Scope *scope.Scope `json:"scope,omitempty"`
+
+	// Lineage of forked sessions
+	ParentSessionID string `json:"parentSessionId,omitempty"`
+	ForkedAt        *int   `json:"forkedAt,omitempty"` // OrderID of the last message copied from the parent
+
+	// Branches replaced by editing a message in place, oldest first
+	Revisions []Revision `json:"revisions,omitempty"`
+
+	Agent   string `json:"agent,omitempty"`   // manus (default), react or planner, fixed at creation
+	Profile string `json:"profile,omitempty"` // Prompt, tools, model and step budget, see GET /api/profiles, fixed at creation
+
+	// Tool calls wait for the operator before they run
+	ToolApproval    bool             `json:"toolApproval"`
+	PendingApproval *PendingApproval `json:"pendingApproval,omitempty"`
+
+	// Final answers are checked against the tool outputs, unsupported claims labelled [Unverified]
+	VerifyClaims bool `json:"verifyClaims"`
+
+	StepBudget int `json:"stepBudget,omitempty"` // Chat model calls per request, 0 uses STEP_BUDGET
+}
+
+// SessionNewRequest is the optional body of POST /api/session/new
+type SessionNewRequest struct {
+	Agent   string       `json:"agent,omitempty"`   // manus (default), react or planner
+	Profile string       `json:"profile,omitempty"` // Agent profile, empty for the default one
+	Scope   *scope.Scope `json:"scope,omitempty"`   // Engagement scope
 }
 
 type SessionRequest struct {
 	SessionID string `json:"sessionId,omitempty"`
 	Message   string `json:"message"`
+	Stream    bool   `json:"stream,omitempty"`   // WebSocket only: send message.delta events while the agent writes
+	MaxSteps  int    `json:"maxSteps,omitempty"` // Step budget of this request, overrides the session's
+	Action    string `json:"action,omitempty"`   // WebSocket only: "cancel" stops the running request of the session, "subscribe" receives its events
 }
 
 type SessionResponse struct {
-	SessionID string        `json:"sessionId"`
-	Response  string        `json:"response"`
-	History   []HistoryItem `json:"history,omitempty"`
+	SessionID       string           `json:"sessionId"`
+	Response        string           `json:"response"`
+	History         []HistoryItem    `json:"history,omitempty"`
+	PendingApproval *PendingApproval `json:"pendingApproval,omitempty"` // Tool calls waiting for POST /api/session/{id}/approval
+	BudgetExhausted bool             `json:"budgetExhausted,omitempty"` // The step budget ran out, the response summarizes what remains
+	Plan            []common.Task    `json:"plan,omitempty"`            // Task list of planner sessions
+	Cancelled       bool             `json:"cancelled,omitempty"`       // The user cancelled the request, the response says so
+	Claims          []common.Claim   `json:"claims,omitempty"`          // Claims of the response as checked by the verifier
+}
+
+// SessionHistoryResponse is a page of the transcript stored in a session's checkpoint
+type SessionHistoryResponse struct {
+	Session    *SessionInfo  `json:"session"`
+	Total      int           `json:"total"`
+	NextOffset int           `json:"nextOffset"`
+	History    []HistoryItem `json:"history"`
+
+	// Summary the model sees instead of the first messages of the history
+	Compaction *HistoryCompaction `json:"compaction,omitempty"`
 }
 
 func init() {
@@ -106,6 +165,12 @@ func registerSerializableTypes() {
 		if err := compose.RegisterSerializableType[common.State]("my state"); err != nil {
 			log.Fatal(err)
 		}
+		if err := compose.RegisterSerializableType[common.Task]("plan task"); err != nil {
+			log.Fatal(err)
+		}
+		if err := compose.RegisterSerializableType[common.Claim]("verified claim"); err != nil {
+			log.Fatal(err)
+		}
 	})
 }
 
@@ -173,7 +238,21 @@ func formatAsJsonForLLMOutputWindow(response string, info *compose.InterruptInfo
 
 	reasoning := mapInterruptInfoToReasoning(info)
 
-	// Convert history to structured format
+	out := Output{
+		Response:  response,
+		Reasoning: reasoning,
+		History:   toHistoryItems(history),
+	}
+
+	b, err := json.Marshal(out)
+	if err != nil {
+		return `{"response":"JSON error","reasoning":{"beforeNode":[],"afterNode":[],"rerunNode":[],"rerunNodesExtra":{},"subGraphs":{}},"history":[]}`
+	}
+	return string(b)
+}
+
+// toHistoryItems converts the agent's message history to its structured API format
+func toHistoryItems(history []*schema.Message) []HistoryItem {
 	historyItems := make([]HistoryItem, len(history))
 	for i, msg := range history {
 		historyItem := HistoryItem{
@@ -205,18 +284,28 @@ func formatAsJsonForLLMOutputWindow(response string, info *compose.InterruptInfo
 
 		historyItems[i] = historyItem
 	}
+	return historyItems
+}
 
-	out := Output{
-		Response:  response,
-		Reasoning: reasoning,
-		History:   historyItems,
-	}
-
-	b, err := json.Marshal(out)
-	if err != nil {
-		return `{"response":"JSON error","reasoning":{"beforeNode":[],"afterNode":[],"rerunNode":[],"rerunNodesExtra":{},"subGraphs":{}},"history":[]}`
+// filterHistory returns at most limit items with an OrderID of at least offset
+// whose role is contained in roles. An empty roles set matches every role and
+// a limit of 0 returns all remaining items. next is the offset of the following
+// page, or -1 if there is none.
+func filterHistory(items []HistoryItem, offset, limit int, roles map[string]bool) (page []HistoryItem, next int) {
+	page = []HistoryItem{}
+	for _, item := range items {
+		if item.OrderID < offset {
+			continue
+		}
+		if len(roles) > 0 && !roles[item.Role] {
+			continue
+		}
+		if limit > 0 && len(page) == limit {
+			return page, item.OrderID
+		}
+		page = append(page, item)
 	}
-	return string(b)
+	return page, -1
 }
 
 // Session management functions
@@ -227,11 +316,14 @@ func createSession() *SessionInfo {
 		CreatedAt:    time.Now(),
 		LastAccess:   time.Now(),
 		MessageCount: 0,
+		ToolApproval: toolApprovalDefault,
+		VerifyClaims: verifyClaimsDefault,
 	}
 
 	sessionMutex.Lock()
 	sessions[sessionID] = session
 	sessionMutex.Unlock()
+	saveSession(context.Background(), session)
 
 	util.LogMessage(fmt.Sprintf("Created new session: %s", sessionID))
 	return session
@@ -253,15 +345,42 @@ func getSession(sessionID string) (*SessionInfo, bool) {
 }
 
 func deleteSession(sessionID string) {
+	purgeSession(context.Background(), sessionID)
+	unbindSession(sessionID)
+
+	util.LogMessage(fmt.Sprintf("Deleted session: %s", sessionID))
+}
+
+// purgeSession removes the session metadata together with its checkpoints and
+// artifacts, and cancels its background jobs
+func purgeSession(ctx context.Context, sessionID string) {
 	sessionMutex.Lock()
+	var revisions []Revision
+	if session, ok := sessions[sessionID]; ok {
+		revisions = session.Revisions
+	}
 	delete(sessions, sessionID)
 	sessionMutex.Unlock()
+	forgetSessionRun(sessionID)
+	removeStoredSession(ctx, sessionID)
+	removeArtifacts(ctx, sessionID)
+	jobs.Forget(sessionID)
 
-	util.LogMessage(fmt.Sprintf("Deleted session: %s", sessionID))
+	if err := checkpointStore.Delete(ctx, sessionID); err != nil {
+		util.LogMessage(fmt.Sprintf("Failed to delete checkpoint of session %s: %v", sessionID, err))
+	}
+	for _, revision := range revisions {
+		if err := checkpointStore.Delete(ctx, revisionCheckpointID(sessionID, revision.Revision)); err != nil {
+			util.LogMessage(fmt.Sprintf("Failed to delete revision %d of session %s: %v", revision.Revision, sessionID, err))
+		}
+	}
 }
 
-// Handles a single user message using the agent with session management
-func handleUserMessageWithSession(ctx context.Context, sessionID, userInput string) SessionResponse {
+// Handles a single user message using the agent with session management. The
+// error is only set if the session lock could not be acquired, the response
+// then carries the reason. With onChunk set the model output is streamed to it
+// while it is generated.
+func handleUserMessageWithSession(ctx context.Context, sessionID, userInput string, maxSteps int, onChunk manus.ChunkHandler) (SessionResponse, error) {
 	util.LogMessage("=== CONVERSATION START ===")
 	util.LogMessage(fmt.Sprintf("Session ID: %s", sessionID))
 	util.LogMessage("User input: " + userInput)
@@ -274,19 +393,98 @@ func handleUserMessageWithSession(ctx context.Context, sessionID, userInput stri
 		sessionID = session.SessionID
 	}
 
+	// Only one request at a time may continue the session's checkpoint
+	ctx, release, err := beginSessionRun(ctx, sessionID)
+	if err != nil {
+		util.LogMessage("Session lock not acquired: " + err.Error())
+		return SessionResponse{
+			SessionID: sessionID,
+			Response:  formatAsJsonForLLMOutputWindow("[Session busy]: "+err.Error(), nil, nil),
+		}, err
+	}
+	defer release()
+
+	// The graph waits at the approval gate, a message would be lost there
+	if pending := pendingApproval(session); pending != nil {
+		return SessionResponse{
+			SessionID:       sessionID,
+			Response:        formatAsJsonForLLMOutputWindow("[Approval pending]: "+errApprovalPending.Error(), nil, nil),
+			PendingApproval: pending,
+		}, errApprovalPending
+	}
+
+	return runAgentTurn(ctx, session, userInput, maxSteps, onChunk), nil
+}
+
+// runAgentTurn continues the session's checkpoint with one user message,
+// streaming the model output to onChunk if set. The caller must hold the
+// session's run lock.
+func runAgentTurn(ctx context.Context, session *SessionInfo, userInput string, maxSteps int, onChunk manus.ChunkHandler) SessionResponse {
+	sessionMutex.Lock()
 	session.MessageCount++
+	if session.Title == "" && session.MessageCount == 1 {
+		session.Title = autoTitle(userInput)
+	}
+	title := session.Title
+	sessionMutex.Unlock()
+	saveSession(ctx, session)
+
+	return invokeAgent(ctx, session, userInput, title, maxSteps, onChunk)
+}
+
+// invokeAgent resumes the session's checkpoint with userInput as the pending
+// user message and builds the response from where the graph stopped. maxSteps
+// overrides the session's step budget if positive.
+func invokeAgent(ctx context.Context, session *SessionInfo, userInput, title string, maxSteps int, onChunk manus.ChunkHandler) SessionResponse {
+	sessionID := session.SessionID
+	// Cancelling the request ends the run with a checkpoint, see cancelSession
+	ctx = manus.Cancellable(ctx)
+
+	sessionMutex.RLock()
+	requireApproval := session.ToolApproval
+	verifyClaims := session.VerifyClaims
+	sessionMutex.RUnlock()
+	if requireApproval {
+		ctx = manus.WithToolApproval(ctx)
+	}
+	if verifyClaims {
+		ctx = manus.WithVerification(ctx)
+	}
+	ctx = manus.WithSystemPrompt(ctx, profileOf(session).SystemPrompt)
+	if engagement := engagementScope(session); engagement != nil {
+		ctx = scope.WithScope(ctx, engagement)
+	}
+	ctx = tools.WithJobOwner(ctx, sessionID)
+	ctx = tools.WithArtifactSaver(ctx, func(ctx context.Context, name string, data []byte) error {
+		return saveArtifact(ctx, sessionID, name, data)
+	})
+	budget := resolveStepBudget(session, maxSteps)
+	ctx = manus.WithStepBudget(ctx, budget)
+	ctx = manus.WithCompactionThreshold(ctx, compactionThreshold)
+	ctx = manus.WithPlanHandler(ctx, func(plan []common.Task) {
+		emitEvent(Event{Event: EventPlanUpdated, SessionID: sessionID, Data: plan})
+	})
 
 	// Use sessionID as checkpoint ID (this is the key fix!)
-	result, err := agent.Invoke(ctx, userInput,
+	opts := []compose.Option{
 		compose.WithCheckPointID(sessionID), // Use sessionID instead of timestamp
 		compose.WithStateModifier(func(ctx context.Context, path compose.NodePath, s any) error {
-			s.(*common.State).UserInput = userInput
+			state := s.(*common.State)
+			state.UserInput = userInput
+			state.Name = title
 			return nil
 		}),
-		compose.WithRuntimeMaxSteps(20),
-	)
-
-	util.LogMessage("Agent.Invoke completed")
+		compose.WithRuntimeMaxSteps(manus.MaxGraphSteps(budget)),
+	}
+	var result string
+	var err error
+	if onChunk != nil {
+		result, err = manus.StreamRun(ctx, agentFor(session), userInput, onChunk, opts...)
+		util.LogMessage("Agent stream completed")
+	} else {
+		result, err = agentFor(session).Invoke(ctx, userInput, opts...)
+		util.LogMessage("Agent.Invoke completed")
+	}
 
 	response := SessionResponse{
 		SessionID: sessionID,
@@ -308,42 +506,38 @@ func handleUserMessageWithSession(ctx context.Context, sessionID, userInput stri
 		response.Response = formatAsJsonForLLMOutputWindow(responseText, info, s.History)
 
 		// Convert history for response
-		response.History = make([]HistoryItem, len(s.History))
-		for i, msg := range s.History {
-			historyItem := HistoryItem{
-				OrderID: i,
-				Role:    string(msg.Role),
-				Content: msg.Content,
-			}
-
-			// Add tool calls if present
-			if len(msg.ToolCalls) > 0 {
-				historyItem.ToolCalls = make([]ToolCallInfo, len(msg.ToolCalls))
-				for j, toolCall := range msg.ToolCalls {
-					historyItem.ToolCalls[j] = ToolCallInfo{
-						ID:   toolCall.ID,
-						Type: toolCall.Type,
-						Function: FunctionInfo{
-							Name:      toolCall.Function.Name,
-							Arguments: toolCall.Function.Arguments,
-						},
-					}
-				}
-			}
-
-			// Add tool call ID if this is a tool response
-			if msg.Role == schema.Tool {
-				historyItem.ToolCallID = msg.ToolCallID
-				historyItem.Name = msg.Name
-			}
-
-			response.History[i] = historyItem
+		response.History = toHistoryItems(s.History)
+
+		response.PendingApproval = updatePendingApproval(ctx, session, info, s.History)
+		response.BudgetExhausted = s.BudgetExhausted
+		response.Plan = s.Plan
+		response.Claims = s.Claims
+		response.Cancelled = manus.Cancelled(ctx)
+		if response.Cancelled {
+			util.LogMessage("=== CONVERSATION CANCELLED BY USER ===")
+		}
+		if s.BudgetExhausted {
+			util.LogMessage(fmt.Sprintf("Step budget of %d exhausted", budget))
 		}
 
 		util.LogMessage("=== CONVERSATION SUCCESS ===")
 		return response
 	}
 
+	if errors.Is(err, context.Canceled) {
+		util.LogMessage("=== CONVERSATION CANCELLED ===")
+		response.Response = formatAsJsonForLLMOutputWindow("[Request cancelled]: "+err.Error(), info, nil)
+		return response
+	}
+
+	// The budget normally ends the run first, this only trips on a loop in the graph
+	if errors.Is(err, compose.ErrExceedMaxSteps) {
+		util.LogMessage("=== CONVERSATION STEP LIMIT ===")
+		response.Response = formatAsJsonForLLMOutputWindow("[Step limit reached]: "+err.Error(), info, nil)
+		response.BudgetExhausted = true
+		return response
+	}
+
 	if err != nil {
 		util.LogMessage("=== CONVERSATION ERROR ===")
 		util.LogMessage("Error: " + err.Error())
@@ -366,13 +560,14 @@ func handleUserMessage(ctx context.Context, userInput string) string {
 	// Generate a unique checkpoint ID for each conversation
 	checkpointID := strconv.FormatInt(time.Now().UnixNano(), 10)
 
-	result, err := agent.Invoke(ctx, userInput,
+	ctx = manus.WithCompactionThreshold(manus.WithStepBudget(ctx, stepBudgetDefault), compactionThreshold)
+	result, err := agentOf(AgentManus, DefaultProfile).Invoke(ctx, userInput,
 		compose.WithCheckPointID(checkpointID),
 		compose.WithStateModifier(func(ctx context.Context, path compose.NodePath, s any) error {
 			s.(*common.State).UserInput = userInput
 			return nil
 		}),
-		compose.WithRuntimeMaxSteps(20),
+		compose.WithRuntimeMaxSteps(manus.MaxGraphSteps(stepBudgetDefault)),
 	)
 
 	util.LogMessage("Agent.Invoke completed")
@@ -409,18 +604,33 @@ func handleUserMessage(ctx context.Context, userInput string) string {
 	return formatAsJsonForLLMOutputWindow(result, info, nil)
 }
 
+// legacyMessage is a plain text WebSocket message and the connection it came from
+type legacyMessage struct {
+	conn    *websocket.Conn
+	message []byte
+}
+
 func handleMessages() {
 	ctx := context.Background()
 	// Create a default session for legacy WebSocket messages that don't specify a session
-	defaultSession := createSession()
+	defaultSessionID := createSession().SessionID
 
 	for {
-		message := <-broadcast
-		userInput := string(message)
+		legacy := <-broadcast
+		userInput := string(legacy.message)
 
-		// Use session-based handling even for legacy messages
-		sessionResponse := handleUserMessageWithSession(ctx, defaultSession.SessionID, userInput)
+		// The events of the default session go to the clients using it
+		if _, exists := getSession(defaultSessionID); !exists {
+			defaultSessionID = createSession().SessionID
+		}
+		bindClient(legacy.conn, defaultSessionID)
+
+		// Use session-based handling even for legacy messages. The output is
+		// streamed to the sender only, the final response goes to all clients.
+		sessionResponse, _ := handleUserMessageWithSession(ctx, defaultSessionID, userInput, 0, sendDelta(legacy.conn, defaultSessionID))
 		response := sessionResponse.Response
+		// The default session is replaced if it was evicted in the meantime
+		defaultSessionID = sessionResponse.SessionID
 
 		mutex.Lock()
 		for client := range clients {
@@ -428,6 +638,7 @@ func handleMessages() {
 			if err != nil {
 				client.Close()
 				delete(clients, client)
+				unbindClient(client)
 			}
 		}
 		mutex.Unlock()
@@ -441,8 +652,45 @@ func sessionNewHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// The body is optional, without one the session runs on the default agent
+	var req SessionNewRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if !validAgentKind(req.Agent) {
+		http.Error(w, "Unknown agent, use manus, react or planner", http.StatusBadRequest)
+		return
+	}
+	if !validProfile(req.Profile) {
+		http.Error(w, "Unknown profile, see GET /api/profiles", http.StatusBadRequest)
+		return
+	}
+	if req.Agent == AgentReAct && toolApprovalDefault {
+		http.Error(w, errApprovalUnsupported.Error(), http.StatusBadRequest)
+		return
+	}
+	var engagement *scope.Scope
+	if req.Scope != nil {
+		var err error
+		if engagement, err = normalizeScope(req.Scope); err != nil {
+			http.Error(w, "Invalid scope: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
 	session := createSession()
+	if req.Agent != "" || req.Profile != "" || engagement != nil {
+		sessionMutex.Lock()
+		session.Agent = req.Agent
+		session.Profile = req.Profile
+		session.Scope = engagement
+		sessionMutex.Unlock()
+		saveSession(r.Context(), session)
+	}
 
+	sessionMutex.RLock()
+	defer sessionMutex.RUnlock()
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(session)
 }
@@ -463,11 +711,18 @@ func sessionMessageHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Message is required", http.StatusBadRequest)
 		return
 	}
+	if !validStepBudget(req.MaxSteps) {
+		http.Error(w, fmt.Sprintf("maxSteps must be between 0 and %d", maxStepBudget), http.StatusBadRequest)
+		return
+	}
 
 	ctx := context.Background()
-	response := handleUserMessageWithSession(ctx, req.SessionID, req.Message)
+	response, err := handleUserMessageWithSession(ctx, req.SessionID, req.Message, req.MaxSteps, nil)
 
 	w.Header().Set("Content-Type", "application/json")
+	if errors.Is(err, errSessionBusy) || errors.Is(err, errApprovalPending) {
+		w.WriteHeader(http.StatusConflict)
+	}
 	json.NewEncoder(w).Encode(response)
 }
 
@@ -492,8 +747,71 @@ func sessionHistoryHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Pagination by OrderID and optional role filter, e.g. ?offset=10&limit=20&role=user,assistant
+	query := r.URL.Query()
+	offset, err := queryInt(query.Get("offset"))
+	if err != nil || offset < 0 {
+		http.Error(w, "Invalid offset", http.StatusBadRequest)
+		return
+	}
+	limit, err := queryInt(query.Get("limit"))
+	if err != nil || limit < 0 {
+		http.Error(w, "Invalid limit", http.StatusBadRequest)
+		return
+	}
+	roles := make(map[string]bool)
+	for _, value := range query["role"] {
+		for _, role := range strings.Split(value, ",") {
+			if role = strings.TrimSpace(role); role != "" {
+				roles[role] = true
+			}
+		}
+	}
+
+	// Archived branches are read with ?revision=n
+	checkPointID := sessionID
+	if value := query.Get("revision"); value != "" {
+		revision, err := strconv.Atoi(value)
+		if err != nil || !hasRevision(session, revision) {
+			http.Error(w, "Revision not found", http.StatusNotFound)
+			return
+		}
+		checkPointID = revisionCheckpointID(sessionID, revision)
+	}
+
+	var history []HistoryItem
+	var compaction *HistoryCompaction
+	state, ok, err := manus.LoadState(r.Context(), agentFor(session), checkpointStore, checkPointID)
+	if err != nil {
+		util.LogMessage("Failed to load session history: " + err.Error())
+		http.Error(w, "Failed to load session history", http.StatusInternalServerError)
+		return
+	}
+	if ok {
+		history = toHistoryItems(state.History)
+		compaction = historyCompaction(state)
+	}
+
+	page, next := filterHistory(history, offset, limit, roles)
+
+	sessionMutex.RLock()
+	defer sessionMutex.RUnlock()
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(session)
+	json.NewEncoder(w).Encode(SessionHistoryResponse{
+		Session:    session,
+		Total:      len(history),
+		NextOffset: next,
+		History:    page,
+		Compaction: compaction,
+	})
+}
+
+// queryInt parses an optional integer query parameter
+func queryInt(value string) (int, error) {
+	if value == "" {
+		return 0, nil
+	}
+	return strconv.Atoi(value)
 }
 
 func sessionDeleteHandler(w http.ResponseWriter, r *http.Request) {
@@ -516,6 +834,46 @@ func sessionDeleteHandler(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(map[string]string{"status": "deleted"})
 }
 
+// parseSessionPath splits /api/session/{id}/{action} into its parts
+func parseSessionPath(path string) (sessionID, action string) {
+	path = strings.TrimPrefix(path, "/api/session/")
+	sessionID, action, _ = strings.Cut(path, "/")
+	return sessionID, action
+}
+
+// sessionRouter dispatches the /api/session/{id}/... endpoints
+func sessionRouter(w http.ResponseWriter, r *http.Request) {
+	_, action := parseSessionPath(r.URL.Path)
+	switch {
+	case action == "history":
+		sessionHistoryHandler(w, r)
+	case action == "fork":
+		sessionForkHandler(w, r)
+	case action == "edit":
+		sessionEditHandler(w, r)
+	case action == "approval":
+		sessionApprovalHandler(w, r)
+	case action == "plan":
+		sessionPlanHandler(w, r)
+	case action == "jobs" || strings.HasPrefix(action, "jobs/"):
+		sessionJobsHandler(w, r)
+	case action == "artifacts":
+		sessionArtifactsHandler(w, r)
+	case action == "export":
+		sessionExportHandler(w, r)
+	case action == "status":
+		sessionStatusHandler(w, r)
+	case action == "cancel":
+		sessionCancelHandler(w, r)
+	case action == "" && r.Method == "PATCH":
+		sessionPatchHandler(w, r)
+	case action == "" && r.Method == "DELETE":
+		sessionDeleteHandler(w, r)
+	default:
+		http.Error(w, "Not found", http.StatusNotFound)
+	}
+}
+
 // Enhanced WebSocket handler that supports session-based messaging
 func wsHandler(w http.ResponseWriter, r *http.Request) {
 	conn, err := upgrader.Upgrade(w, r, nil)
@@ -534,22 +892,50 @@ func wsHandler(w http.ResponseWriter, r *http.Request) {
 		if err != nil {
 			mutex.Lock()
 			delete(clients, conn)
+			unbindClient(conn)
 			mutex.Unlock()
 			break
 		}
 
 		// Try to parse as SessionRequest first
 		var sessionReq SessionRequest
-		if err := json.Unmarshal(message, &sessionReq); err == nil && sessionReq.Message != "" {
-			// Handle as session-based message
-			ctx := context.Background()
-			response := handleUserMessageWithSession(ctx, sessionReq.SessionID, sessionReq.Message)
+		err = json.Unmarshal(message, &sessionReq)
+		if err == nil && sessionReq.Action == "subscribe" {
+			if _, exists := getSession(sessionReq.SessionID); exists {
+				bindClient(conn, sessionReq.SessionID)
+			}
+		} else if err == nil && sessionReq.Action == "cancel" {
+			bindClient(conn, sessionReq.SessionID)
+			// The clients learn about a cancelled run from the event, only a
+			// failed attempt is answered directly
+			if response := cancelSession(sessionReq.SessionID); !response.Cancelled {
+				sendEvent(conn, Event{Event: EventRunCancelled, SessionID: sessionReq.SessionID, Data: response})
+			}
+		} else if err == nil && sessionReq.Message != "" {
+			// Handle as session-based message. The run happens in the background,
+			// so a cancel message can still be read meanwhile.
+			// A new session is created up front, so the connection is bound to
+			// it before the run emits events
+			if _, exists := getSession(sessionReq.SessionID); !exists {
+				sessionReq.SessionID = createSession().SessionID
+			}
+			bindClient(conn, sessionReq.SessionID)
+			go func(sessionReq SessionRequest) {
+				ctx := context.Background()
+				var onChunk manus.ChunkHandler
+				if sessionReq.Stream {
+					onChunk = sendDelta(conn, sessionReq.SessionID)
+				}
+				response, _ := handleUserMessageWithSession(ctx, sessionReq.SessionID, sessionReq.Message, sessionReq.MaxSteps, onChunk)
 
-			responseBytes, _ := json.Marshal(response)
-			conn.WriteMessage(websocket.TextMessage, responseBytes)
+				responseBytes, _ := json.Marshal(response)
+				mutex.Lock()
+				conn.WriteMessage(websocket.TextMessage, responseBytes)
+				mutex.Unlock()
+			}(sessionReq)
 		} else {
 			// Fall back to legacy message handling for backward compatibility
-			broadcast <- message
+			broadcast <- legacyMessage{conn: conn, message: message}
 		}
 	}
 }
@@ -571,27 +957,81 @@ func main() {
 
 	var err error
 
-	agent = manus.CreateAgent()
+	sessionLockPolicy = parseLockPolicy(os.Getenv("SESSION_LOCK_POLICY"))
+	toolApprovalDefault = os.Getenv("TOOL_APPROVAL") == "true"
+	verifyClaimsDefault = os.Getenv("VERIFY_CLAIMS") == "true"
+	scopeRequired = os.Getenv("SCOPE_REQUIRED") == "true"
+	stepBudgetDefault = envInt("STEP_BUDGET", manus.DefaultStepBudget)
+	if !validStepBudget(stepBudgetDefault) || stepBudgetDefault == 0 {
+		fmt.Printf("Warning: STEP_BUDGET must be between 1 and %d, using default %d\n", maxStepBudget, manus.DefaultStepBudget)
+		stepBudgetDefault = manus.DefaultStepBudget
+	}
+	compactionThreshold = envInt("COMPACTION_TOKENS", manus.DefaultCompactionThreshold)
+	historySearchLimit = envInt("SEARCH_HISTORY_LIMIT", historySearchLimit)
+	toolConcurrency.Global = envInt("TOOL_CONCURRENCY", toolConcurrency.Global)
+	if value := os.Getenv("TOOL_CONCURRENCY_LIMITS"); value != "" {
+		limits, err := manus.ParseToolLimits(value)
+		if err != nil {
+			fmt.Println("Warning: TOOL_CONCURRENCY_LIMITS: " + err.Error())
+		} else {
+			toolConcurrency.PerTool = limits
+		}
+	}
+
+	if err = openStores(); err != nil {
+		fmt.Println("Error: " + err.Error())
+		return
+	}
+	if err = loadSessions(context.Background()); err != nil {
+		fmt.Println("Warning: " + err.Error())
+	}
+
+	if path := os.Getenv("PROFILES_FILE"); path != "" {
+		if err = loadProfiles(path); err != nil {
+			fmt.Println("Error: " + err.Error())
+			return
+		}
+	}
+	jobs = newJobManager(tools.JobConfig{
+		MaxRunning:  envInt("JOB_LIMIT", 4),
+		MaxDuration: envDuration("JOB_TIMEOUT", time.Hour),
+		Retention:   envDuration("JOB_RETENTION", 24*time.Hour),
+	})
+	if err = createAgents(checkpointStore); err != nil {
+		fmt.Println("Error: " + err.Error())
+		return
+	}
+
+	startSessionReaper(context.Background(),
+		envDuration("SESSION_IDLE_TTL", 7*24*time.Hour),
+		envInt("SESSION_MAX_COUNT", 0),
+	)
 
 	// Register HTTP endpoints
+	http.HandleFunc("/api/sessions", sessionListHandler)
+	http.HandleFunc("/api/profiles", profilesHandler)
 	http.HandleFunc("/api/session/new", sessionNewHandler)
 	http.HandleFunc("/api/session/message", sessionMessageHandler)
-	http.HandleFunc("/api/session/", func(w http.ResponseWriter, r *http.Request) {
-		if strings.HasSuffix(r.URL.Path, "/history") {
-			sessionHistoryHandler(w, r)
-		} else if r.Method == "DELETE" {
-			sessionDeleteHandler(w, r)
-		} else {
-			http.Error(w, "Not found", http.StatusNotFound)
-		}
-	})
+	http.HandleFunc("/api/session/import", sessionImportHandler)
+	http.HandleFunc("/api/session/", sessionRouter)
 
 	http.HandleFunc("/ws", wsHandler)
 	go handleMessages() // optional, falls Broadcast benötigt
 	fmt.Println("Server started on :8080 with session management endpoints:")
+	fmt.Println("  GET /api/sessions - List and search sessions (?sort=&order=&tag=&from=&to=&q=&offset=&limit=)")
+	fmt.Println("  GET /api/profiles - List agent profiles")
 	fmt.Println("  POST /api/session/new - Create new session")
 	fmt.Println("  POST /api/session/message - Send message to session")
-	fmt.Println("  GET /api/session/{id}/history - Get session history")
+	fmt.Println("  GET /api/session/{id}/history - Get session transcript (?offset=&limit=&role=&revision=)")
+	fmt.Println("  POST /api/session/{id}/approval - Approve, deny or edit pending tool calls")
+	fmt.Println("  POST /api/session/{id}/edit - Replace a user message and regenerate")
+	fmt.Println("  POST /api/session/{id}/fork?at={orderId} - Fork session from a message")
+	fmt.Println("  GET /api/session/{id}/plan - Get the task list of a planner session")
+	fmt.Println("  GET /api/session/{id}/status - Get session execution state")
+	fmt.Println("  GET /api/session/{id}/jobs - List background jobs, /jobs/{jobId}/output and /jobs/{jobId}/cancel")
+	fmt.Println("  GET /api/session/{id}/export - Export session bundle")
+	fmt.Println("  POST /api/session/import?keepId={true|false} - Import session bundle")
+	fmt.Println("  PATCH /api/session/{id} - Update title, notes, tags and target")
 	fmt.Println("  DELETE /api/session/{id} - Delete session")
 	fmt.Println("  WebSocket /ws - Enhanced WebSocket with session support")
 
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+
+	"gogogajeto/agent/scope"
+)
+
+const (
+	maxTitleLength  = 200   // Characters
+	maxNotesLength  = 20000 // Characters
+	maxTargetLength = 500   // Characters
+	autoTitleLength = 60    // Characters taken from the first user message
+)
+
+// SessionPatchRequest updates the metadata of a session. Fields that are
+// omitted stay unchanged, empty values clear them.
+type SessionPatchRequest struct {
+	Title  *string   `json:"title"`
+	Notes  *string   `json:"notes"`
+	Tags   *[]string `json:"tags"`
+	Target *string   `json:"target"`
+
+	ToolApproval *bool `json:"toolApproval"` // Hold tool calls for approval
+	StepBudget   *int  `json:"stepBudget"`   // Chat model calls per request, 0 uses the server default
+	VerifyClaims *bool `json:"verifyClaims"` // Check final answers against the tool outputs
+
+	Scope *scope.Scope `json:"scope"` // Engagement scope, {} clears it
+}
+
+// sessionPatchHandler updates the session metadata, e.g.
+// PATCH /api/session/{id} {"title": "ACME external", "tags": ["recon"], "target": "acme.example", "toolApproval": true}
+func sessionPatchHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "PATCH" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	sessionID, _ := parseSessionPath(r.URL.Path)
+	session, exists := getSession(sessionID)
+	if !exists {
+		http.Error(w, "Session not found", http.StatusNotFound)
+		return
+	}
+
+	var req SessionPatchRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	switch {
+	case req.Title != nil && utf8.RuneCountInString(*req.Title) > maxTitleLength:
+		http.Error(w, "Title is too long", http.StatusBadRequest)
+		return
+	case req.Notes != nil && utf8.RuneCountInString(*req.Notes) > maxNotesLength:
+		http.Error(w, "Notes are too long", http.StatusBadRequest)
+		return
+	case req.Target != nil && utf8.RuneCountInString(*req.Target) > maxTargetLength:
+		http.Error(w, "Target is too long", http.StatusBadRequest)
+		return
+	case req.ToolApproval != nil && *req.ToolApproval && agentKind(session) == AgentReAct:
+		http.Error(w, errApprovalUnsupported.Error(), http.StatusBadRequest)
+		return
+	case req.StepBudget != nil && !validStepBudget(*req.StepBudget):
+		http.Error(w, fmt.Sprintf("Step budget must be between 0 and %d", maxStepBudget), http.StatusBadRequest)
+		return
+	}
+	var engagement *scope.Scope
+	if req.Scope != nil {
+		var err error
+		if engagement, err = normalizeScope(req.Scope); err != nil {
+			http.Error(w, "Invalid scope: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	sessionMutex.Lock()
+	if req.Title != nil {
+		session.Title = strings.TrimSpace(*req.Title)
+	}
+	if req.Notes != nil {
+		session.Notes = *req.Notes
+	}
+	if req.Tags != nil {
+		session.Tags = normalizeTags(*req.Tags)
+	}
+	if req.Target != nil {
+		session.Target = strings.TrimSpace(*req.Target)
+	}
+	if req.ToolApproval != nil {
+		session.ToolApproval = *req.ToolApproval
+	}
+	if req.StepBudget != nil {
+		session.StepBudget = *req.StepBudget
+	}
+	if req.VerifyClaims != nil {
+		session.VerifyClaims = *req.VerifyClaims
+	}
+	if req.Scope != nil {
+		session.Scope = engagement
+	}
+	sessionMutex.Unlock()
+	saveSession(r.Context(), session)
+
+	sessionMutex.RLock()
+	defer sessionMutex.RUnlock()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(session)
+}
+
+// normalizeTags trims the tags and drops empty ones and duplicates, ignoring
+// case
+func normalizeTags(tags []string) []string {
+	normalized := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		tag = strings.TrimSpace(tag)
+		if tag != "" && !containsFold(normalized, tag) {
+			normalized = append(normalized, tag)
+		}
+	}
+	if len(normalized) == 0 {
+		return nil
+	}
+	return normalized
+}
+
+// autoTitle derives a session title from the first line of a user message
+func autoTitle(message string) string {
+	var line string
+	for _, l := range strings.Split(message, "\n") {
+		if line = strings.Join(strings.Fields(l), " "); line != "" {
+			break
+		}
+	}
+
+	runes := []rune(line)
+	if len(runes) <= autoTitleLength {
+		return line
+	}
+	cut := string(runes[:autoTitleLength])
+	// Prefer cutting at a word boundary
+	if i := strings.LastIndex(cut, " "); i > autoTitleLength/2 {
+		cut = cut[:i]
+	}
+	return cut + "…"
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"gogogajeto/agent/common"
+	manus "gogogajeto/agent/manus"
+	"gogogajeto/util"
+)
+
+// PlanResponse is the task list of a planner session and how far it got
+type PlanResponse struct {
+	SessionID string        `json:"sessionId"`
+	Plan      []common.Task `json:"plan"`
+	Finished  int           `json:"finished"` // Tasks done or skipped
+	Total     int           `json:"total"`
+}
+
+// sessionPlanHandler returns the checkpointed plan, e.g. GET /api/session/{id}/plan.
+// Sessions on other agents have an empty plan.
+func sessionPlanHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	sessionID, _ := parseSessionPath(r.URL.Path)
+	session, exists := getSession(sessionID)
+	if !exists {
+		http.Error(w, "Session not found", http.StatusNotFound)
+		return
+	}
+
+	response := PlanResponse{SessionID: sessionID, Plan: []common.Task{}}
+	state, ok, err := manus.LoadState(r.Context(), agentFor(session), checkpointStore, sessionID)
+	if err != nil {
+		util.LogMessage("Failed to load session plan: " + err.Error())
+		http.Error(w, "Failed to load session plan", http.StatusInternalServerError)
+		return
+	}
+	if ok && state.Plan != nil {
+		response.Plan = state.Plan
+	}
+	for _, task := range response.Plan {
+		if task.Status == common.TaskDone || task.Status == common.TaskSkipped {
+			response.Finished++
+		}
+	}
+	response.Total = len(response.Plan)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
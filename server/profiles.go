@@ -0,0 +1,125 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+
+	"gogogajeto/agent/prompts"
+)
+
+// DefaultProfile runs sessions created without a profile, with every tool
+const DefaultProfile = "default"
+
+// Profile configures the agent of a session for one kind of work. The profile
+// is chosen when the session is created and cannot change later.
+type Profile struct {
+	Name         string   `json:"name"`
+	Description  string   `json:"description,omitempty"`
+	SystemPrompt string   `json:"systemPrompt,omitempty"` // Empty uses the default prompt
+	Tools        []string `json:"tools,omitempty"`        // Names of the enabled tools, empty enables all
+	Model        string   `json:"model,omitempty"`        // Chat model, empty uses OPENAI_MODEL
+	StepBudget   int      `json:"stepBudget,omitempty"`   // Chat model calls per request, 0 uses STEP_BUDGET
+}
+
+// profiles by name, the built-in ones plus those from PROFILES_FILE
+var profiles = map[string]Profile{
+	DefaultProfile: {
+		Name:        DefaultProfile,
+		Description: "All Python and Kali tools",
+	},
+	"recon": {
+		Name:         "recon",
+		Description:  "Reconnaissance of domains, hosts and services with the Kali tools",
+		SystemPrompt: prompts.ReconPrompt,
+		Tools:        []string{"kali_info_gathering", "job_status", "job_output", "job_cancel"},
+	},
+	"web-app": {
+		Name:         "web-app",
+		Description:  "Web application testing with the Kali tools and Python",
+		SystemPrompt: prompts.WebAppPrompt,
+		Tools:        []string{"kali_info_gathering", "job_status", "job_output", "job_cancel", "python_execute"},
+	},
+	"code-review": {
+		Name:         "code-review",
+		Description:  "Security review of source code in the Python sandbox",
+		SystemPrompt: prompts.CodeReviewPrompt,
+		Tools:        []string{"str_replace_editor", "python_execute"},
+	},
+	"python-only": {
+		Name:         "python-only",
+		Description:  "Python sandbox and file editor, no network scanners",
+		SystemPrompt: prompts.PythonPrompt,
+		Tools:        []string{"str_replace_editor", "python_execute"},
+	},
+}
+
+// loadProfiles adds the profiles of a JSON file holding a list of profiles.
+// A profile with the name of a built-in one replaces it.
+func loadProfiles(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read profiles: %w", err)
+	}
+	var loaded []Profile
+	if err := json.Unmarshal(data, &loaded); err != nil {
+		return fmt.Errorf("failed to parse profiles %s: %w", path, err)
+	}
+	for _, profile := range loaded {
+		if profile.Name == "" {
+			return fmt.Errorf("profile without name in %s", path)
+		}
+		if !validStepBudget(profile.StepBudget) {
+			return fmt.Errorf("profile %s: step budget must be between 0 and %d", profile.Name, maxStepBudget)
+		}
+		profiles[profile.Name] = profile
+	}
+	return nil
+}
+
+// validProfile reports whether name names a profile, empty means the default
+func validProfile(name string) bool {
+	if name == "" {
+		return true
+	}
+	_, ok := profiles[name]
+	return ok
+}
+
+// profileName returns the name of the session's profile. Sessions from before
+// profiles, or whose profile was removed from the configuration, run on the
+// default profile.
+func profileName(session *SessionInfo) string {
+	sessionMutex.RLock()
+	name := session.Profile
+	sessionMutex.RUnlock()
+	if _, ok := profiles[name]; !ok {
+		return DefaultProfile
+	}
+	return name
+}
+
+// profileOf returns the profile the session runs on
+func profileOf(session *SessionInfo) Profile {
+	return profiles[profileName(session)]
+}
+
+// profilesHandler lists the profiles a session can be created with,
+// GET /api/profiles
+func profilesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	list := make([]Profile, 0, len(profiles))
+	for _, profile := range profiles {
+		list = append(list, profile)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
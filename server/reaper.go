@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"gogogajeto/util"
+)
+
+const reapInterval = time.Minute
+
+// startSessionReaper periodically evicts sessions that have been idle for
+// longer than idleTTL and, if more than maxSessions remain, the least recently
+// used ones. A zero idleTTL or maxSessions disables the respective limit.
+func startSessionReaper(ctx context.Context, idleTTL time.Duration, maxSessions int) {
+	if idleTTL <= 0 && maxSessions <= 0 {
+		util.LogMessage("Session reaper disabled")
+		return
+	}
+	util.LogMessage(fmt.Sprintf("Session reaper started (idle TTL: %s, max sessions: %d)", idleTTL, maxSessions))
+
+	go func() {
+		ticker := time.NewTicker(reapInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case now := <-ticker.C:
+				reapSessions(ctx, now, idleTTL, maxSessions)
+			}
+		}
+	}()
+}
+
+// reapSessions evicts expired sessions and enforces the session limit
+func reapSessions(ctx context.Context, now time.Time, idleTTL time.Duration, maxSessions int) {
+	type candidate struct {
+		id         string
+		lastAccess time.Time
+	}
+
+	sessionMutex.RLock()
+	candidates := make([]candidate, 0, len(sessions))
+	for id, session := range sessions {
+		candidates = append(candidates, candidate{id: id, lastAccess: session.LastAccess})
+	}
+	sessionMutex.RUnlock()
+
+	// Least recently used first
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].lastAccess.Before(candidates[j].lastAccess)
+	})
+
+	remaining := len(candidates)
+	for _, c := range candidates {
+		// Never evict a session while the agent is working on it
+		if sessionRunStatus(c.id).Running {
+			continue
+		}
+
+		switch {
+		case idleTTL > 0 && now.Sub(c.lastAccess) > idleTTL:
+			evictSession(ctx, c.id, fmt.Sprintf("idle for more than %s", idleTTL))
+		case maxSessions > 0 && remaining > maxSessions:
+			evictSession(ctx, c.id, fmt.Sprintf("session limit of %d exceeded", maxSessions))
+		default:
+			continue
+		}
+		remaining--
+	}
+}
+
+// evictSession removes the session together with its checkpoint and notifies
+// the clients bound to it
+func evictSession(ctx context.Context, sessionID, reason string) {
+	purgeSession(ctx, sessionID)
+
+	util.LogMessage(fmt.Sprintf("Evicted session %s: %s", sessionID, reason))
+	emitEvent(Event{
+		Event:     EventSessionEvicted,
+		SessionID: sessionID,
+		Data:      map[string]string{"reason": reason},
+	})
+	unbindSession(sessionID)
+}
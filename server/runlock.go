@@ -0,0 +1,268 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"gogogajeto/util"
+)
+
+// LockPolicy decides what happens to a request for a session that is already
+// running the agent
+type LockPolicy string
+
+const (
+	LockPolicyQueue  LockPolicy = "queue"  // Wait until the running request has finished
+	LockPolicyReject LockPolicy = "reject" // Fail with errSessionBusy
+	LockPolicyCancel LockPolicy = "cancel" // Cancel the running request and take over
+)
+
+var errSessionBusy = errors.New("session is busy with another request")
+
+var sessionLockPolicy = LockPolicyQueue
+
+// sessionRun serializes the agent runs of one session. Every run writes the
+// session's checkpoint, so concurrent runs would overwrite each other's history.
+type sessionRun struct {
+	slot chan struct{} // Holds a token while a run is in progress
+
+	mu        sync.Mutex
+	cancel    context.CancelFunc // Cancels the current run
+	startedAt time.Time
+	waiting   int // Requests queued behind the current run
+
+	// Guarded by runsMutex: the requests holding or waiting for the run, and
+	// whether the session was removed. A removed session keeps its run state
+	// until the last of them is done, so later requests still queue behind.
+	users     int
+	forgotten bool
+}
+
+var runs = make(map[string]*sessionRun) // Run state by session ID
+var runsMutex = &sync.Mutex{}           // Protect runs map
+
+// RunStatus is the execution state of a session as shown by the API
+type RunStatus struct {
+	SessionID string     `json:"sessionId"`
+	Running   bool       `json:"running"`
+	StartedAt *time.Time `json:"startedAt,omitempty"`
+	Queued    int        `json:"queued"`
+	Policy    LockPolicy `json:"policy"`
+}
+
+// parseLockPolicy reads the policy from SESSION_LOCK_POLICY, queue by default
+func parseLockPolicy(value string) LockPolicy {
+	switch policy := LockPolicy(value); policy {
+	case LockPolicyQueue, LockPolicyReject, LockPolicyCancel:
+		return policy
+	case "":
+		return LockPolicyQueue
+	default:
+		util.LogMessage(fmt.Sprintf("Unknown SESSION_LOCK_POLICY %q, using %s", value, LockPolicyQueue))
+		return LockPolicyQueue
+	}
+}
+
+// getSessionRun returns the run state of the session for a request, which
+// must hand it back with putSessionRun
+func getSessionRun(sessionID string) *sessionRun {
+	runsMutex.Lock()
+	defer runsMutex.Unlock()
+	run, ok := runs[sessionID]
+	if !ok {
+		run = &sessionRun{slot: make(chan struct{}, 1)}
+		runs[sessionID] = run
+	}
+	run.users++
+	return run
+}
+
+// putSessionRun hands back the run state taken by getSessionRun, the state of
+// a removed session is dropped with its last user
+func putSessionRun(sessionID string, run *sessionRun) {
+	runsMutex.Lock()
+	defer runsMutex.Unlock()
+	run.users--
+	if run.forgotten && run.users == 0 && runs[sessionID] == run {
+		delete(runs, sessionID)
+	}
+}
+
+// beginSessionRun claims the session for one agent run according to the lock
+// policy. The returned context is cancelled if a later request takes over the
+// session; release must be called once the run has finished.
+func beginSessionRun(ctx context.Context, sessionID string) (context.Context, func(), error) {
+	run := getSessionRun(sessionID)
+
+	select {
+	case run.slot <- struct{}{}:
+	default:
+		switch sessionLockPolicy {
+		case LockPolicyReject:
+			putSessionRun(sessionID, run)
+			return nil, nil, errSessionBusy
+		case LockPolicyCancel:
+			run.mu.Lock()
+			if run.cancel != nil {
+				util.LogMessage("Cancelling running request of session " + sessionID)
+				run.cancel()
+			}
+			run.mu.Unlock()
+		}
+
+		run.mu.Lock()
+		run.waiting++
+		run.mu.Unlock()
+
+		var err error
+		select {
+		case run.slot <- struct{}{}:
+		case <-ctx.Done():
+			err = ctx.Err()
+		}
+
+		run.mu.Lock()
+		run.waiting--
+		run.mu.Unlock()
+		if err != nil {
+			putSessionRun(sessionID, run)
+			return nil, nil, err
+		}
+	}
+
+	runCtx, cancel := context.WithCancel(ctx)
+	run.mu.Lock()
+	run.cancel = cancel
+	run.startedAt = time.Now()
+	run.mu.Unlock()
+
+	release := func() {
+		run.mu.Lock()
+		run.cancel = nil
+		run.startedAt = time.Time{}
+		run.mu.Unlock()
+		cancel()
+		<-run.slot
+		putSessionRun(sessionID, run)
+	}
+	return runCtx, release, nil
+}
+
+// cancelSessionRun cancels the running request of the session, if any
+func cancelSessionRun(sessionID string) bool {
+	runsMutex.Lock()
+	run, ok := runs[sessionID]
+	runsMutex.Unlock()
+	if !ok {
+		return false
+	}
+
+	run.mu.Lock()
+	defer run.mu.Unlock()
+	if run.cancel == nil {
+		return false
+	}
+	run.cancel()
+	return true
+}
+
+// CancelResponse tells whether a running request of the session was cancelled
+type CancelResponse struct {
+	SessionID string `json:"sessionId"`
+	Cancelled bool   `json:"cancelled"`
+}
+
+// cancelSession cancels the running request of the session on behalf of the
+// user and tells the clients. The run stops its model and tool calls and
+// checkpoints the cancelled state itself, see manus.Cancellable.
+func cancelSession(sessionID string) CancelResponse {
+	response := CancelResponse{SessionID: sessionID, Cancelled: cancelSessionRun(sessionID)}
+	if response.Cancelled {
+		util.LogMessage("Session " + sessionID + ": running request cancelled by user")
+		emitEvent(Event{Event: EventRunCancelled, SessionID: sessionID, Data: response})
+	}
+	return response
+}
+
+// forgetSessionRun cancels the running request and drops the run state of a
+// removed session. While requests still hold or wait for the run, the state
+// is kept until they are done, see putSessionRun.
+func forgetSessionRun(sessionID string) {
+	cancelSessionRun(sessionID)
+
+	runsMutex.Lock()
+	defer runsMutex.Unlock()
+	if run, ok := runs[sessionID]; ok {
+		run.forgotten = true
+		if run.users == 0 {
+			delete(runs, sessionID)
+		}
+	}
+}
+
+// sessionRunStatus returns the execution state of the session
+func sessionRunStatus(sessionID string) RunStatus {
+	status := RunStatus{SessionID: sessionID, Policy: sessionLockPolicy}
+
+	runsMutex.Lock()
+	run, ok := runs[sessionID]
+	runsMutex.Unlock()
+	if !ok {
+		return status
+	}
+
+	run.mu.Lock()
+	defer run.mu.Unlock()
+	status.Running = run.cancel != nil
+	if status.Running {
+		startedAt := run.startedAt
+		status.StartedAt = &startedAt
+	}
+	status.Queued = run.waiting
+	return status
+}
+
+// sessionStatusHandler returns the execution state of a session, e.g.
+// GET /api/session/{id}/status
+func sessionStatusHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	sessionID, _ := parseSessionPath(r.URL.Path)
+	if _, exists := getSession(sessionID); !exists {
+		http.Error(w, "Session not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(sessionRunStatus(sessionID))
+}
+
+// sessionCancelHandler cancels the running request of a session, e.g.
+// POST /api/session/{id}/cancel. 409 if no request is running.
+func sessionCancelHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	sessionID, _ := parseSessionPath(r.URL.Path)
+	if _, exists := getSession(sessionID); !exists {
+		http.Error(w, "Session not found", http.StatusNotFound)
+		return
+	}
+
+	response := cancelSession(sessionID)
+	w.Header().Set("Content-Type", "application/json")
+	if !response.Cancelled {
+		w.WriteHeader(http.StatusConflict)
+	}
+	json.NewEncoder(w).Encode(response)
+}
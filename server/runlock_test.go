@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func withLockPolicy(t *testing.T, policy LockPolicy) {
+	previous := sessionLockPolicy
+	t.Cleanup(func() { sessionLockPolicy = previous })
+	sessionLockPolicy = policy
+}
+
+// beginInBackground starts a request for the session that waits for the run
+func beginInBackground(ctx context.Context, sessionID string) chan func() {
+	acquired := make(chan func(), 1)
+	go func() {
+		if _, release, err := beginSessionRun(ctx, sessionID); err == nil {
+			acquired <- release
+		} else {
+			close(acquired)
+		}
+	}()
+	return acquired
+}
+
+func hasSessionRun(sessionID string) bool {
+	runsMutex.Lock()
+	defer runsMutex.Unlock()
+	_, ok := runs[sessionID]
+	return ok
+}
+
+func TestParseLockPolicy(t *testing.T) {
+	tests := map[string]LockPolicy{
+		"":        LockPolicyQueue,
+		"queue":   LockPolicyQueue,
+		"reject":  LockPolicyReject,
+		"cancel":  LockPolicyCancel,
+		"unknown": LockPolicyQueue,
+	}
+	for value, want := range tests {
+		assert.Equal(t, want, parseLockPolicy(value), value)
+	}
+}
+
+func TestBeginSessionRun_Queue(t *testing.T) {
+	withLockPolicy(t, LockPolicyQueue)
+	const sessionID = "runlock-queue"
+
+	_, release, err := beginSessionRun(context.Background(), sessionID)
+	require.NoError(t, err)
+	assert.True(t, sessionRunStatus(sessionID).Running)
+
+	// The second request waits for the first and is counted as queued
+	acquired := beginInBackground(context.Background(), sessionID)
+	require.Eventually(t, func() bool { return sessionRunStatus(sessionID).Queued == 1 }, time.Second, time.Millisecond)
+	assert.Empty(t, acquired)
+
+	release()
+	second := <-acquired
+	require.NotNil(t, second)
+	status := sessionRunStatus(sessionID)
+	assert.True(t, status.Running)
+	assert.Equal(t, 0, status.Queued)
+	second()
+	assert.False(t, sessionRunStatus(sessionID).Running)
+}
+
+func TestBeginSessionRun_QueueGivesUp(t *testing.T) {
+	withLockPolicy(t, LockPolicyQueue)
+	const sessionID = "runlock-queue-timeout"
+
+	_, release, err := beginSessionRun(context.Background(), sessionID)
+	require.NoError(t, err)
+	defer release()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, _, err = beginSessionRun(ctx, sessionID)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Equal(t, 0, sessionRunStatus(sessionID).Queued)
+}
+
+func TestBeginSessionRun_Reject(t *testing.T) {
+	withLockPolicy(t, LockPolicyReject)
+	const sessionID = "runlock-reject"
+
+	runCtx, release, err := beginSessionRun(context.Background(), sessionID)
+	require.NoError(t, err)
+
+	_, _, err = beginSessionRun(context.Background(), sessionID)
+	assert.ErrorIs(t, err, errSessionBusy)
+	assert.NoError(t, runCtx.Err(), "the running request goes on")
+
+	release()
+	_, release, err = beginSessionRun(context.Background(), sessionID)
+	require.NoError(t, err)
+	release()
+}
+
+func TestBeginSessionRun_Cancel(t *testing.T) {
+	withLockPolicy(t, LockPolicyCancel)
+	const sessionID = "runlock-cancel"
+
+	runCtx, release, err := beginSessionRun(context.Background(), sessionID)
+	require.NoError(t, err)
+
+	// The new request cancels the running one and takes over once it stopped
+	acquired := beginInBackground(context.Background(), sessionID)
+	select {
+	case <-runCtx.Done():
+	case <-time.After(time.Second):
+		t.Fatal("running request was not cancelled")
+	}
+	assert.Empty(t, acquired)
+
+	release()
+	second := <-acquired
+	require.NotNil(t, second)
+	second()
+}
+
+func TestCancelSession(t *testing.T) {
+	withLockPolicy(t, LockPolicyQueue)
+	const sessionID = "runlock-cancel-session"
+
+	// Nothing runs, neither before nor after the first run
+	assert.False(t, cancelSession(sessionID).Cancelled)
+	_, release, err := beginSessionRun(context.Background(), sessionID)
+	require.NoError(t, err)
+	release()
+	assert.False(t, cancelSession(sessionID).Cancelled)
+
+	runCtx, release, err := beginSessionRun(context.Background(), sessionID)
+	require.NoError(t, err)
+	response := cancelSession(sessionID)
+	assert.Equal(t, CancelResponse{SessionID: sessionID, Cancelled: true}, response)
+	assert.ErrorIs(t, runCtx.Err(), context.Canceled)
+	release()
+}
+
+func TestForgetSessionRun_DuringRun(t *testing.T) {
+	withLockPolicy(t, LockPolicyQueue)
+	const sessionID = "runlock-forget"
+
+	runCtx, release, err := beginSessionRun(context.Background(), sessionID)
+	require.NoError(t, err)
+
+	// The run is cancelled, but holds the session until it released it
+	forgetSessionRun(sessionID)
+	assert.ErrorIs(t, runCtx.Err(), context.Canceled)
+	assert.True(t, hasSessionRun(sessionID))
+
+	acquired := beginInBackground(context.Background(), sessionID)
+	require.Eventually(t, func() bool { return sessionRunStatus(sessionID).Queued == 1 }, time.Second, time.Millisecond)
+	assert.Empty(t, acquired, "a later request must not run alongside")
+
+	release()
+	second := <-acquired
+	require.NotNil(t, second)
+	assert.True(t, hasSessionRun(sessionID))
+
+	// The state goes with the last request
+	second()
+	assert.False(t, hasSessionRun(sessionID))
+}
+
+func TestForgetSessionRun_Idle(t *testing.T) {
+	withLockPolicy(t, LockPolicyQueue)
+	const sessionID = "runlock-forget-idle"
+
+	_, release, err := beginSessionRun(context.Background(), sessionID)
+	require.NoError(t, err)
+	release()
+	require.True(t, hasSessionRun(sessionID))
+
+	forgetSessionRun(sessionID)
+	assert.False(t, hasSessionRun(sessionID))
+	assert.Equal(t, RunStatus{SessionID: sessionID, Policy: LockPolicyQueue}, sessionRunStatus(sessionID))
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"gogogajeto/agent/scope"
+)
+
+// scopeRequired refuses every network tool call of sessions without a scope,
+// set by SCOPE_REQUIRED=true. Otherwise such sessions are not checked.
+var scopeRequired bool
+
+// engagementScope returns the scope the tools of the session keep to, nil if
+// they are not checked
+func engagementScope(session *SessionInfo) *scope.Scope {
+	sessionMutex.RLock()
+	s := session.Scope
+	sessionMutex.RUnlock()
+	if s == nil && scopeRequired {
+		return &scope.Scope{}
+	}
+	return s
+}
+
+// normalizeScope validates a scope set through the API. A scope without any
+// entry clears it.
+func normalizeScope(s *scope.Scope) (*scope.Scope, error) {
+	if len(s.CIDRs)+len(s.Domains)+len(s.URLs)+len(s.Exclude) == 0 {
+		return nil, nil
+	}
+	if err := s.Validate(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
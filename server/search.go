@@ -0,0 +1,301 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+
+	manus "gogogajeto/agent/manus"
+	"gogogajeto/util"
+)
+
+const snippetRadius = 60 // Characters of context around a search hit
+
+// historySearchLimit caps the sessions whose checkpointed history one search
+// loads, the most recently active first, set by SEARCH_HISTORY_LIMIT. 0 for
+// no limit.
+var historySearchLimit = 100
+
+// SessionListItem is a session in the listing, with the messages that matched
+// the search query if one was given
+type SessionListItem struct {
+	SessionInfo
+	MatchedFields []string      `json:"matchedFields,omitempty"` // Metadata fields containing the query
+	Matches       []SearchMatch `json:"matches,omitempty"`
+}
+
+// SearchMatch is a message of a session's history that contains the query
+type SearchMatch struct {
+	OrderID int    `json:"orderId"`
+	Role    string `json:"role"`
+	Snippet string `json:"snippet"`
+}
+
+type SessionListResponse struct {
+	Total    int               `json:"total"`
+	Sessions []SessionListItem `json:"sessions"`
+
+	// Sessions only searched by their metadata, because the search reached
+	// historySearchLimit
+	HistorySkipped int `json:"historySkipped,omitempty"`
+}
+
+// sessionListQuery holds the parsed parameters of GET /api/sessions
+type sessionListQuery struct {
+	Sort   string    // createdAt, lastAccess or messageCount
+	Desc   bool      // Sort order, newest/largest first by default
+	Tag    string    // Only sessions carrying this tag
+	From   time.Time // Only sessions active at or after this time
+	To     time.Time // Only sessions active at or before this time
+	Text   string    // Full text search over the metadata and the checkpointed history
+	Offset int
+	Limit  int
+}
+
+// sessionListHandler lists the known sessions, e.g.
+// GET /api/sessions?sort=lastAccess&order=desc&tag=recon&from=2024-05-01&to=2024-05-02&q=nginx&offset=0&limit=20
+func sessionListHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	q, err := parseSessionListQuery(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	items, skipped := listSessions(r.Context(), q)
+
+	total := len(items)
+	if q.Offset < len(items) {
+		items = items[q.Offset:]
+	} else {
+		items = []SessionListItem{}
+	}
+	if q.Limit > 0 && len(items) > q.Limit {
+		items = items[:q.Limit]
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(SessionListResponse{Total: total, Sessions: items, HistorySkipped: skipped})
+}
+
+func parseSessionListQuery(r *http.Request) (sessionListQuery, error) {
+	query := r.URL.Query()
+	q := sessionListQuery{
+		Sort: query.Get("sort"),
+		Desc: query.Get("order") != "asc",
+		Tag:  query.Get("tag"),
+		Text: strings.TrimSpace(query.Get("q")),
+	}
+
+	switch q.Sort {
+	case "":
+		q.Sort = "lastAccess"
+	case "createdAt", "lastAccess", "messageCount":
+	default:
+		return q, errors.New("Invalid sort, use createdAt, lastAccess or messageCount")
+	}
+
+	var err error
+	if q.From, err = parseDateParam(query.Get("from"), false); err != nil {
+		return q, errors.New("Invalid from date")
+	}
+	if q.To, err = parseDateParam(query.Get("to"), true); err != nil {
+		return q, errors.New("Invalid to date")
+	}
+	if q.Offset, err = queryInt(query.Get("offset")); err != nil || q.Offset < 0 {
+		return q, errors.New("Invalid offset")
+	}
+	if q.Limit, err = queryInt(query.Get("limit")); err != nil || q.Limit < 0 {
+		return q, errors.New("Invalid limit")
+	}
+	return q, nil
+}
+
+// parseDateParam accepts RFC 3339 timestamps or plain dates. A plain date used
+// as the end of a range covers the whole day.
+func parseDateParam(value string, endOfDay bool) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	t, err := time.ParseInLocation(time.DateOnly, value, time.Local)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if endOfDay {
+		t = t.Add(24*time.Hour - time.Nanosecond)
+	}
+	return t, nil
+}
+
+// listSessions returns the sessions matching the query in the requested order,
+// and how many sessions were searched by their metadata only
+func listSessions(ctx context.Context, q sessionListQuery) ([]SessionListItem, int) {
+	// Work on copies so the checkpoint search runs without holding the lock
+	sessionMutex.RLock()
+	items := make([]SessionListItem, 0, len(sessions))
+	for _, session := range sessions {
+		if !matchesSessionFilters(session, q) {
+			continue
+		}
+		items = append(items, SessionListItem{SessionInfo: *session})
+	}
+	sessionMutex.RUnlock()
+
+	skipped := 0
+	if q.Text != "" {
+		items, skipped = searchSessions(ctx, items, q.Text)
+	}
+
+	sort.SliceStable(items, func(i, j int) bool {
+		a, b := items[i], items[j]
+		if q.Desc {
+			a, b = b, a
+		}
+		switch q.Sort {
+		case "createdAt":
+			return a.CreatedAt.Before(b.CreatedAt)
+		case "messageCount":
+			return a.MessageCount < b.MessageCount
+		default:
+			return a.LastAccess.Before(b.LastAccess)
+		}
+	})
+	return items, skipped
+}
+
+// searchSessions keeps the sessions whose metadata or history contains text.
+// The metadata is in memory and searched for all of them. Loading a history
+// decodes its checkpoint, so only the historySearchLimit most recently active
+// sessions are searched by their history, and none once ctx is done.
+func searchSessions(ctx context.Context, items []SessionListItem, text string) ([]SessionListItem, int) {
+	sort.SliceStable(items, func(i, j int) bool {
+		return items[i].LastAccess.After(items[j].LastAccess)
+	})
+
+	matched := items[:0]
+	skipped := 0
+	for i, item := range items {
+		item.MatchedFields = searchSessionMetadata(&item.SessionInfo, text)
+		if (historySearchLimit > 0 && i >= historySearchLimit) || ctx.Err() != nil {
+			skipped++
+		} else {
+			item.Matches = searchSessionHistory(ctx, item.SessionID, text)
+		}
+		if len(item.MatchedFields) > 0 || len(item.Matches) > 0 {
+			matched = append(matched, item)
+		}
+	}
+	return matched, skipped
+}
+
+// matchesSessionFilters checks the metadata filters. A session matches a date
+// range if it was active at some point within it.
+func matchesSessionFilters(session *SessionInfo, q sessionListQuery) bool {
+	if q.Tag != "" && !containsFold(session.Tags, q.Tag) {
+		return false
+	}
+	if !q.From.IsZero() && session.LastAccess.Before(q.From) {
+		return false
+	}
+	if !q.To.IsZero() && session.CreatedAt.After(q.To) {
+		return false
+	}
+	return true
+}
+
+func containsFold(values []string, value string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// searchSessionMetadata returns the names of the descriptive fields that
+// contain text, ignoring case
+func searchSessionMetadata(session *SessionInfo, text string) []string {
+	var fields []string
+	for _, field := range []struct{ name, value string }{
+		{"title", session.Title},
+		{"notes", session.Notes},
+		{"target", session.Target},
+	} {
+		if _, found := findSnippet(field.value, text); found {
+			fields = append(fields, field.name)
+		}
+	}
+	for _, tag := range session.Tags {
+		if _, found := findSnippet(tag, text); found {
+			fields = append(fields, "tags")
+			break
+		}
+	}
+	return fields
+}
+
+// searchSessionHistory returns the messages of the session's checkpointed
+// history that contain text, ignoring case
+func searchSessionHistory(ctx context.Context, sessionID, text string) []SearchMatch {
+	state, ok, err := manus.LoadState(ctx, agentForID(sessionID), checkpointStore, sessionID)
+	if err != nil {
+		util.LogMessage("Search skipped session " + sessionID + ": " + err.Error())
+		return nil
+	}
+	if !ok {
+		return nil
+	}
+
+	var matches []SearchMatch
+	for i, msg := range state.History {
+		if snippet, found := findSnippet(msg.Content, text); found {
+			matches = append(matches, SearchMatch{OrderID: i, Role: string(msg.Role), Snippet: snippet})
+		}
+	}
+	return matches
+}
+
+// findSnippet looks for text in content ignoring case and returns the hit
+// with some surrounding context
+func findSnippet(content, text string) (string, bool) {
+	haystack := []rune(content)
+	needle := []rune(strings.ToLower(text))
+	if len(needle) == 0 || len(needle) > len(haystack) {
+		return "", false
+	}
+
+	lower := make([]rune, len(haystack))
+	for i, r := range haystack {
+		lower[i] = unicode.ToLower(r)
+	}
+
+	for i := 0; i+len(needle) <= len(lower); i++ {
+		if !slices.Equal(lower[i:i+len(needle)], needle) {
+			continue
+		}
+		start := max(i-snippetRadius, 0)
+		end := min(i+len(needle)+snippetRadius, len(haystack))
+		snippet := string(haystack[start:end])
+		if start > 0 {
+			snippet = "…" + snippet
+		}
+		if end < len(haystack) {
+			snippet += "…"
+		}
+		return strings.Join(strings.Fields(snippet), " "), true
+	}
+	return "", false
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	manus "gogogajeto/agent/manus"
+	"gogogajeto/util"
+)
+
+var checkpointStore manus.Store   // Graph state of all sessions
+var sessionStore manus.KeyedStore // Persisted SessionInfo, nil when running in memory only
+var artifactStore manus.Store     // Files produced by tools, keyed by session
+
+// openStores selects the checkpoint store. When CHECKPOINT_DB_PATH is set the
+// graph state, the session metadata and the artifacts are kept in a BoltDB
+// file at that path, so sessions can be resumed after a server restart.
+// Otherwise everything lives in memory and is lost on shutdown.
+//
+// With encryption keys configured all values are encrypted before they reach
+// the store, see loadEncryptionKeys.
+func openStores() error {
+	keys, err := loadEncryptionKeys()
+	if err != nil {
+		return err
+	}
+
+	path := os.Getenv("CHECKPOINT_DB_PATH")
+	if path == "" {
+		util.LogMessage("CHECKPOINT_DB_PATH not set, using in-memory checkpoint store")
+		checkpointStore, err = encryptStore(manus.NewInMemoryStore(), keys)
+		if err != nil {
+			return err
+		}
+		artifactStore, err = encryptStore(manus.NewInMemoryStore(), keys)
+		return err
+	}
+
+	store, err := manus.NewBoltStore(path, manus.CheckpointBucket)
+	if err != nil {
+		return err
+	}
+	sessionBucket, err := store.Bucket(manus.SessionBucket)
+	if err != nil {
+		store.Close()
+		return err
+	}
+	artifactBucket, err := store.Bucket(manus.ArtifactBucket)
+	if err != nil {
+		store.Close()
+		return err
+	}
+
+	if checkpointStore, err = encryptStore(store, keys); err != nil {
+		store.Close()
+		return err
+	}
+	if sessionStore, err = encryptStore(sessionBucket, keys); err != nil {
+		store.Close()
+		return err
+	}
+	if artifactStore, err = encryptStore(artifactBucket, keys); err != nil {
+		store.Close()
+		return err
+	}
+
+	util.LogMessage("Using checkpoint database: " + path)
+	return nil
+}
+
+// loadEncryptionKeys reads the keys for encryption at rest from the keyfile at
+// CHECKPOINT_KEY_FILE or, if that is not set, from CHECKPOINT_ENCRYPTION_KEY.
+// Keys are base64 encoded 32 byte values separated by newlines or commas. The
+// first key encrypts, the others are only used to read values written before
+// a key rotation. No keys means no encryption.
+func loadEncryptionKeys() ([][]byte, error) {
+	data := os.Getenv("CHECKPOINT_ENCRYPTION_KEY")
+	if path := os.Getenv("CHECKPOINT_KEY_FILE"); path != "" {
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read keyfile: %w", err)
+		}
+		data = string(content)
+	}
+
+	keys, err := manus.ParseKeys(data)
+	if err != nil {
+		return nil, err
+	}
+	if len(keys) == 0 {
+		util.LogMessage("No encryption key configured, checkpoints are stored unencrypted")
+	}
+	return keys, nil
+}
+
+// encryptStore wraps the store with encryption if keys are configured and
+// re-encrypts existing entries that do not use the primary key yet
+func encryptStore(store manus.KeyedStore, keys [][]byte) (manus.KeyedStore, error) {
+	if len(keys) == 0 {
+		return store, nil
+	}
+
+	encrypted, err := manus.NewEncryptedStore(store, keys)
+	if err != nil {
+		return nil, err
+	}
+	migrated, err := encrypted.Migrate(context.Background())
+	if err != nil {
+		return nil, fmt.Errorf("failed to encrypt existing entries: %w", err)
+	}
+	if migrated > 0 {
+		util.LogMessage(fmt.Sprintf("Encrypted %d entries with the current key", migrated))
+	}
+	return encrypted, nil
+}
+
+// loadSessions restores the sessions map from the session store
+func loadSessions(ctx context.Context) error {
+	if sessionStore == nil {
+		return nil
+	}
+
+	ids, err := sessionStore.Keys(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to list stored sessions: %w", err)
+	}
+
+	sessionMutex.Lock()
+	defer sessionMutex.Unlock()
+	for _, id := range ids {
+		data, ok, err := sessionStore.Get(ctx, id)
+		if err != nil || !ok {
+			continue
+		}
+		session := &SessionInfo{}
+		if err := json.Unmarshal(data, session); err != nil {
+			util.LogMessage(fmt.Sprintf("Skipping unreadable session %s: %v", id, err))
+			continue
+		}
+		sessions[session.SessionID] = session
+	}
+
+	util.LogMessage(fmt.Sprintf("Restored %d sessions", len(sessions)))
+	return nil
+}
+
+// saveSession writes the session metadata to the session store
+func saveSession(ctx context.Context, session *SessionInfo) {
+	if sessionStore == nil {
+		return
+	}
+
+	sessionMutex.RLock()
+	data, err := json.Marshal(session)
+	sessionMutex.RUnlock()
+	if err == nil {
+		err = sessionStore.Set(ctx, session.SessionID, data)
+	}
+	if err != nil {
+		util.LogMessage(fmt.Sprintf("Failed to persist session %s: %v", session.SessionID, err))
+	}
+}
+
+// removeStoredSession deletes the persisted session metadata
+func removeStoredSession(ctx context.Context, sessionID string) {
+	if sessionStore == nil {
+		return
+	}
+
+	if err := sessionStore.Delete(ctx, sessionID); err != nil {
+		util.LogMessage(fmt.Sprintf("Failed to delete stored session %s: %v", sessionID, err))
+	}
+}
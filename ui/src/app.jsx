@@ -39,6 +39,7 @@ export default function App() {
   const [responses, setResponses] = useState([]); // Track AI responses separately
   const [reasoning, setReasoning] = useState(["Initial reasoning..."]);
   const [loading, setLoading] = useState(false);
+  const [streaming, setStreaming] = useState(""); // Assistant output received so far via message.delta
   const [selectedMessages, setSelectedMessages] = useState([]);
   const [tableData, setTableData] = useState([]);
   const [sessionInfo, setSessionInfo] = useState(null);
@@ -137,6 +138,22 @@ export default function App() {
       ws.current.onmessage = (event) => {
         const data = JSON.parse(event.data);
         console.log("WebSocket message received:", data);
+        if (data.event === "message.delta") {
+          // Partial assistant output, replaced by the final response
+          setStreaming(s => s + data.data.content);
+          return;
+        }
+        if (data.event === "plan.updated") {
+          // Task list of a planner session, shown as it progresses
+          setReasoning(r => [...r, `Plan: ${JSON.stringify(data.data)}`]);
+          return;
+        }
+        if (data.event) {
+          // Server side notification, not an agent response
+          setReasoning(r => [...r, `Event: ${data.event} ${data.sessionId || ""}`]);
+          return;
+        }
+        setStreaming("");
         const response = data.response;
         setMessages(msgs => [...msgs, response]);
         setResponses(responses => [...responses, response]); // Track as AI response
@@ -199,6 +216,13 @@ export default function App() {
     setLoading(false);
   };
 
+  const cancelRun = async () => {
+    const result = await SessionManager.cancelBackendRun(backendSessionId);
+    if (result && !result.cancelled) {
+      setReasoning(r => [...r, "Nothing to cancel, the request has already finished"]);
+    }
+  };
+
   const handleSelectMessage = (index) => {
     setSelectedMessages(prev => {
       if (prev.includes(index)) {
@@ -302,7 +326,8 @@ export default function App() {
         >
           <ChatPanel 
             onSend={sendMessage} 
-            messages={messages} 
+            onCancel={useBackendSession && backendSessionId ? cancelRun : undefined}
+            messages={streaming ? [...messages, streaming] : messages} 
             loading={loading}
             onSelectMessage={handleSelectMessage}
             selectedMessages={selectedMessages}
@@ -39,7 +39,7 @@ function formatChatMessage(text) {
   return elements;
 }
 
-function ChatPanel({ onSend, messages, loading, onSelectMessage, selectedMessages }) {
+function ChatPanel({ onSend, onCancel, messages, loading, onSelectMessage, selectedMessages }) {
   const [input, setInput] = useState("");
   const [selectedPreset, setSelectedPreset] = useState("");
   const chatEndRef = useRef(null);
@@ -131,6 +131,15 @@ function ChatPanel({ onSend, messages, loading, onSelectMessage, selectedMessage
               <path className="opacity-75" fill="currentColor" d="M4 12a8 8 0 018-8v4a4 4 0 00-4 4H4z"/>
             </svg>
             <span className="ml-2 text-gray-600">Processing your response...</span>
+            {onCancel && (
+              <button
+                type="button"
+                className="ml-3 bg-red-500 text-white px-2 py-0.5 rounded text-xs hover:bg-red-600"
+                onClick={onCancel}
+              >
+                Stop
+              </button>
+            )}
           </div>
         )}
         <div ref={chatEndRef} />
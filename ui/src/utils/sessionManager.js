@@ -206,14 +206,44 @@ export class SessionManager {
     }
   }
 
-  static async getBackendSessionHistory(sessionId = null) {
+  // Stops the request the session is running, resolves to { sessionId, cancelled }
+  static async cancelBackendRun(sessionId = null) {
     try {
       const currentSessionId = sessionId || this.getBackendSessionId();
       if (!currentSessionId) {
         return null;
       }
 
-      const response = await fetch(`${this.API_BASE}/${currentSessionId}/history`, {
+      const response = await fetch(`${this.API_BASE}/${currentSessionId}/cancel`, {
+        method: 'POST',
+        headers: {
+          'Content-Type': 'application/json',
+        },
+      });
+
+      // 409 means there was nothing to cancel, the body says so
+      if (!response.ok && response.status !== 409) {
+        throw new Error(`HTTP error! status: ${response.status}`);
+      }
+
+      return await response.json();
+    } catch (error) {
+      console.error('Failed to cancel backend run:', error);
+      return null;
+    }
+  }
+
+  // params: { offset, limit, role } - role may be a comma separated list, e.g. 'user,assistant'
+  static async getBackendSessionHistory(sessionId = null, params = {}) {
+    try {
+      const currentSessionId = sessionId || this.getBackendSessionId();
+      if (!currentSessionId) {
+        return null;
+      }
+
+      const query = new URLSearchParams(params).toString();
+      const url = `${this.API_BASE}/${currentSessionId}/history${query ? `?${query}` : ''}`;
+      const response = await fetch(url, {
         method: 'GET',
         headers: {
           'Content-Type': 'application/json',
@@ -231,6 +261,33 @@ export class SessionManager {
     }
   }
 
+  // fields: { title, notes, tags, target } - omitted fields stay unchanged
+  static async updateBackendSession(sessionId = null, fields = {}) {
+    try {
+      const currentSessionId = sessionId || this.getBackendSessionId();
+      if (!currentSessionId) {
+        return null;
+      }
+
+      const response = await fetch(`${this.API_BASE}/${currentSessionId}`, {
+        method: 'PATCH',
+        headers: {
+          'Content-Type': 'application/json',
+        },
+        body: JSON.stringify(fields),
+      });
+
+      if (!response.ok) {
+        throw new Error(`HTTP error! status: ${response.status}`);
+      }
+
+      return await response.json();
+    } catch (error) {
+      console.error('Failed to update backend session:', error);
+      return null;
+    }
+  }
+
   static async clearBackendSession(sessionId = null) {
     try {
       const currentSessionId = sessionId || this.getBackendSessionId();